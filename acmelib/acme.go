@@ -7,8 +7,11 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"fmt"
+	"net/http"
 	"time"
 
 	"encoding/pem"
@@ -24,9 +27,31 @@ const (
 	LetsEncryptLiveDirectory = acme.LetsEncryptURL
 	// LetsEncryptStagingDirectory is the directory path to the staging environment for LE.
 	// Use of this directory won't create real certificates.
-	LetsEncryptStagingDirectory = "https://acme-staging.api.letsencrypt.org/directory"
+	LetsEncryptStagingDirectory = "https://acme-staging-v02.api.letsencrypt.org/directory"
 )
 
+// Let's Encrypt ACME v1 directories, which no longer issue certificates.  Accounts registered on
+// them can be used on the v2 directories with the same key.
+const (
+	LetsEncryptLiveV1Directory    = "https://acme-v01.api.letsencrypt.org/directory"
+	LetsEncryptStagingV1Directory = "https://acme-staging.api.letsencrypt.org/directory"
+)
+
+// LegacyDirectory gets the ACME v1 directory whose accounts carry over to the given directory, or
+// an empty string if there isn't one
+func LegacyDirectory(directory string) string {
+	switch directory {
+	case LetsEncryptLiveDirectory:
+		return LetsEncryptLiveV1Directory
+	case LetsEncryptStagingDirectory:
+		return LetsEncryptStagingV1Directory
+	}
+	return ""
+}
+
+// challengePollInterval is how often a challenge is checked while waiting for it to be validated
+const challengePollInterval = time.Second
+
 // Client represents an acme client
 type Client interface {
 	// RegisterAccount creates a new user account for use with the directoy
@@ -34,9 +59,15 @@ type Client interface {
 	// UseAccount uses the specified account for directory methods
 	UseAccount(ctx context.Context, account *Account) (*Account, error)
 	// CreateCertificate creates a new certificate
-	CreateCertificate(ctx context.Context, domain string, san []string) (*CertificateBundle, error)
-	// BeginAuthorize begins authorization on a domain by requesting the challenge
+	CreateCertificate(ctx context.Context, domain string, san []string, options *CertificateOptions) (*CertificateBundle, error)
+	// CreateCertificateFromCSR creates a new certificate from a DER-encoded certificate request; the
+	// returned bundle has no private key
+	CreateCertificateFromCSR(ctx context.Context, csr []byte, options *CertificateOptions) (*CertificateBundle, error)
+	// BeginAuthorize begins authorization on a domain by ordering a certificate for it and
+	// requesting the challenge; the order is left unfinished
 	BeginAuthorize(ctx context.Context, domain string) (*HTTPAuthChallenge, error)
+	// GetChallenge gets the details of an existing challenge
+	GetChallenge(ctx context.Context, challengeURI string) (*HTTPAuthChallenge, error)
 	// CompleteAuthorize waits for authorization to complete on a challenge
 	CompleteAuthorize(ctx context.Context, challenge AuthChallenge) error
 	// CompleteAuthorizeURI waits for authorization to complete on a challenge
@@ -51,7 +82,18 @@ type clientInfo struct {
 // AuthChallenge describes any ACME authorization challenge
 type AuthChallenge struct {
 	challenge *acme.Challenge
-	URI       string
+	// authzURI is the URI of the authorization the challenge belongs to, if known
+	authzURI string
+	// URI is the URI of the challenge
+	URI string
+}
+
+// ChallengeSolver makes the responses to http-01 challenges available while the CA validates them
+type ChallengeSolver interface {
+	// PresentChallenge makes the response available at the challenge's path
+	PresentChallenge(challenge *HTTPAuthChallenge) error
+	// CleanUpChallenge removes the response once the CA has finished with the challenge
+	CleanUpChallenge(challenge *HTTPAuthChallenge)
 }
 
 // HTTPAuthChallenge describes an ACME http-01 challenge
@@ -61,6 +103,17 @@ type HTTPAuthChallenge struct {
 	Response string
 }
 
+// CertificateOptions describes optional settings used when creating a certificate
+type CertificateOptions struct {
+	// PreferredChain is the common name of the root or issuer of the chain to use if the CA offers
+	// alternate chains.  The default chain is used if none match.
+	PreferredChain string
+	// Key is the private key to request the certificate for.  A new key is generated if not set.
+	Key crypto.Signer
+	// Solver presents the challenges for any names which the account isn't already authorized for
+	Solver ChallengeSolver
+}
+
 // CertificateBundle contains the certificate chain and private key
 type CertificateBundle struct {
 	CertificatesRaw [][]byte
@@ -89,6 +142,27 @@ func NewClient(directoryURL string) (Client, error) {
 	}, nil
 }
 
+// NewClientWithRoots creates a new client connection to an ACME directory whose TLS certificate is
+// signed by one of the given PEM-encoded root certificates, such as a local test directory.
+func NewClientWithRoots(directoryURL string, rootsPEM []byte) (Client, error) {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(rootsPEM) {
+		return nil, logger.Error("no root certificates found")
+	}
+	logger.Debug("creating new ACME client with custom roots", golog.String("directory", directoryURL))
+	return &clientInfo{
+		client: &acme.Client{
+			DirectoryURL: directoryURL,
+			HTTPClient: &http.Client{
+				Transport: &http.Transport{
+					Proxy:           http.ProxyFromEnvironment,
+					TLSClientConfig: &tls.Config{RootCAs: roots},
+				},
+			},
+		},
+	}, nil
+}
+
 // RegisterAccount registers the given account with the ACME service
 func (c *clientInfo) RegisterAccount(ctx context.Context, email string, acceptTOS bool) (*Account, error) {
 	logger.Debug("registering new account",
@@ -114,6 +188,7 @@ func (c *clientInfo) RegisterAccount(ctx context.Context, email string, acceptTO
 	// create a new client, in case everything goes wrong
 	client := &acme.Client{
 		DirectoryURL: c.client.DirectoryURL,
+		HTTPClient:   c.client.HTTPClient,
 		Key:          key,
 	}
 
@@ -144,6 +219,7 @@ func (c *clientInfo) UseAccount(ctx context.Context, account *Account) (*Account
 	// create a new client, in case everything goes wrong
 	client := &acme.Client{
 		DirectoryURL: c.client.DirectoryURL,
+		HTTPClient:   c.client.HTTPClient,
 		Key:          account.Key,
 	}
 
@@ -155,40 +231,68 @@ func (c *clientInfo) UseAccount(ctx context.Context, account *Account) (*Account
 
 	c.client = client
 
+	// the server looks the account up by its key, so its URI may differ from the stored one, e.g. for
+	// an account registered on an ACME v1 directory
+	uri := acc.URI
+	if uri == "" {
+		uri = account.URI
+	}
 	return &Account{
 		AgreedTerms:  acc.AgreedTerms,
 		CurrentTerms: acc.CurrentTerms,
 		Email:        account.Email,
 		Key:          account.Key,
 		KeyBytes:     account.KeyBytes,
-		URI:          account.URI,
+		URI:          uri,
 	}, nil
 }
 
-// BeginAuthorize begins authorization on a domain by requesting the challenge
+// BeginAuthorize begins authorization on a domain by ordering a certificate for it and requesting
+// the challenge.  The order is left unfinished and expires on the server.
 func (c *clientInfo) BeginAuthorize(ctx context.Context, domain string) (*HTTPAuthChallenge, error) {
-	// start domain authorization and get the challenge
-	authz, err := c.client.Authorize(ctx, domain)
+	order, err := c.client.AuthorizeOrder(ctx, acme.DomainIDs(domain))
 	if err != nil {
 		logger.Error("error starting authorization for domain", golog.String("domain", domain))
 		return nil, logger.Errore(err)
 	}
+	if len(order.AuthzURLs) == 0 {
+		return nil, logger.Error("no authorization provided by server", golog.String("domain", domain))
+	}
+	authz, err := c.client.GetAuthorization(ctx, order.AuthzURLs[0])
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
 	// don't need to authorize
 	if authz.Status == acme.StatusValid {
 		return nil, nil
 	}
-	// pick a challenge
-	var challenge *acme.Challenge
-	for _, c := range authz.Challenges {
-		if c.Type == "http-01" {
-			challenge = c
-			break
-		}
+	return c.authzChallenge(authz)
+}
+
+// GetChallenge gets the details of an existing challenge
+func (c *clientInfo) GetChallenge(ctx context.Context, challengeURI string) (*HTTPAuthChallenge, error) {
+	challenge, err := c.client.GetChallenge(ctx, challengeURI)
+	if err != nil {
+		return nil, logger.Errore(err)
 	}
-	if challenge == nil {
-		return nil, logger.Error("no supported challenge provided by server")
+	if challenge.Type != "http-01" {
+		return nil, logger.Error("unsupported challenge type", golog.String("type", challenge.Type))
+	}
+	return c.httpAuthChallenge(challenge, "")
+}
+
+// authzChallenge picks the http-01 challenge of an authorization
+func (c *clientInfo) authzChallenge(authz *acme.Authorization) (*HTTPAuthChallenge, error) {
+	for _, challenge := range authz.Challenges {
+		if challenge.Type == "http-01" {
+			return c.httpAuthChallenge(challenge, authz.URI)
+		}
 	}
-	// get the response params
+	return nil, logger.Error("no supported challenge provided by server", golog.String("domain", authz.Identifier.Value))
+}
+
+// httpAuthChallenge gets the response params for a http-01 challenge
+func (c *clientInfo) httpAuthChallenge(challenge *acme.Challenge, authzURI string) (*HTTPAuthChallenge, error) {
 	challengePath := c.client.HTTP01ChallengePath(challenge.Token)
 	challengeResponse, err := c.client.HTTP01ChallengeResponse(challenge.Token)
 	if err != nil {
@@ -198,7 +302,8 @@ func (c *clientInfo) BeginAuthorize(ctx context.Context, domain string) (*HTTPAu
 	return &HTTPAuthChallenge{
 		AuthChallenge: AuthChallenge{
 			challenge: challenge,
-			URI:       authz.URI,
+			authzURI:  authzURI,
+			URI:       challenge.URI,
 		},
 		Path:     challengePath,
 		Response: challengeResponse,
@@ -211,7 +316,10 @@ func (c *clientInfo) CompleteAuthorize(ctx context.Context, challenge AuthChalle
 	if err != nil {
 		return logger.Errore(err)
 	}
-	_, err = c.client.WaitAuthorization(ctx, challenge.URI)
+	if challenge.authzURI == "" {
+		return c.waitChallenge(ctx, challenge.URI)
+	}
+	_, err = c.client.WaitAuthorization(ctx, challenge.authzURI)
 	if err != nil {
 		return logger.Errore(err)
 	}
@@ -228,22 +336,46 @@ func (c *clientInfo) CompleteAuthorizeURI(ctx context.Context, challengeURI stri
 	if err != nil {
 		return logger.Errore(err)
 	}
-	_, err = c.client.WaitAuthorization(ctx, challenge.URI)
-	if err != nil {
-		return logger.Errore(err)
+	return c.waitChallenge(ctx, challenge.URI)
+}
+
+// waitChallenge polls a challenge until the CA has validated it or it has failed
+func (c *clientInfo) waitChallenge(ctx context.Context, challengeURI string) error {
+	for {
+		challenge, err := c.client.GetChallenge(ctx, challengeURI)
+		if err != nil {
+			return logger.Errore(err)
+		}
+		switch challenge.Status {
+		case acme.StatusValid:
+			return nil
+		case acme.StatusInvalid:
+			return logger.Error("challenge failed", golog.String("error", fmt.Sprint(challenge.Error)))
+		}
+		select {
+		case <-ctx.Done():
+			return logger.Errore(ctx.Err())
+		case <-time.After(challengePollInterval):
+		}
 	}
-	return nil
 }
 
 // CreateCertificate creates a new certificate
-func (c *clientInfo) CreateCertificate(ctx context.Context, domain string, san []string) (*CertificateBundle, error) {
-	// generate key
-	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		return nil, logger.Errore(err)
+func (c *clientInfo) CreateCertificate(ctx context.Context, domain string, san []string, options *CertificateOptions) (*CertificateBundle, error) {
+	var key crypto.Signer
+	var err error
+	if options != nil && options.Key != nil {
+		// reuse the given key
+		key = options.Key
+	} else {
+		// generate key
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
 	}
 	// encode key
-	keyBytes, err := x509.MarshalECPrivateKey(key)
+	keyBytes, keyType, err := marshalPrivateKey(key)
 	if err != nil {
 		return nil, logger.Errore(err)
 	}
@@ -253,45 +385,199 @@ func (c *clientInfo) CreateCertificate(ctx context.Context, domain string, san [
 		return nil, logger.Errore(err)
 	}
 	// get cert from ACME server
-	der, _, err := c.client.CreateCert(ctx, csr, 0, true)
+	req, err := x509.ParseCertificateRequest(csr)
 	if err != nil {
 		return nil, logger.Errore(err)
 	}
-	// parse cert bundle
-	bundle, err := parseCertificates(der)
+	der, bundle, err := c.createCert(ctx, csr, req.DNSNames, key.Public(), options)
 	if err != nil {
 		return nil, logger.Errore(err)
 	}
-	// validate bundle and return leaf cert
-	err = validateCertificateChain(domain, bundle, key)
+	return &CertificateBundle{
+		CertificatesRaw: der,
+		Certificates:    bundle,
+		PrivateKey:      keyBytes,
+		PrivateKeyType:  keyType,
+	}, nil
+}
+
+// CreateCertificateFromCSR creates a new certificate from a DER-encoded certificate request
+func (c *clientInfo) CreateCertificateFromCSR(ctx context.Context, csr []byte, options *CertificateOptions) (*CertificateBundle, error) {
+	req, err := x509.ParseCertificateRequest(csr)
+	if err != nil {
+		return nil, logger.Errorex("unable to parse certificate request", err)
+	}
+	if err := req.CheckSignature(); err != nil {
+		return nil, logger.Errorex("invalid certificate request signature", err)
+	}
+	var names []string
+	if req.Subject.CommonName != "" {
+		names = append(names, req.Subject.CommonName)
+	}
+	for _, name := range req.DNSNames {
+		if name != req.Subject.CommonName {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, logger.Error("certificate request has no names")
+	}
+	// get cert from ACME server
+	der, bundle, err := c.createCert(ctx, csr, names, req.PublicKey, options)
 	if err != nil {
 		return nil, logger.Errore(err)
 	}
 	return &CertificateBundle{
 		CertificatesRaw: der,
 		Certificates:    bundle,
-		PrivateKey:      keyBytes,
-		PrivateKeyType:  "EC",
 	}, nil
 }
 
-// CertificatesPEM encodes the certificates to PEM format
-func (c *CertificateBundle) CertificatesPEM() []byte {
-	var buf []byte
+// createCert orders a certificate for the names, authorizes them and finalizes the order with the
+// csr, then validates the certificate.  The first name is checked against the certificate.
+func (c *clientInfo) createCert(ctx context.Context, csr []byte, names []string, pub crypto.PublicKey, options *CertificateOptions) ([][]byte, []*x509.Certificate, error) {
+	if options == nil {
+		options = &CertificateOptions{}
+	}
+	order, err := c.authorizeOrder(ctx, names, options.Solver)
+	if err != nil {
+		return nil, nil, logger.Errore(err)
+	}
+	der, certURL, err := c.finalizeOrder(ctx, order, csr)
+	if err != nil {
+		return nil, nil, logger.Errore(err)
+	}
+	// parse cert bundle
+	bundle, err := parseCertificates(der)
+	if err != nil {
+		return nil, nil, logger.Errore(err)
+	}
+	// use an alternate chain if preferred
+	if options.PreferredChain != "" && !chainMatches(bundle, options.PreferredChain) {
+		der, bundle = c.preferredChain(ctx, certURL, options.PreferredChain, der, bundle)
+	}
+	// validate bundle and return leaf cert
+	err = validateCertificateChain(names[0], bundle, pub)
+	if err != nil {
+		return nil, nil, logger.Errore(err)
+	}
+	return der, bundle, nil
+}
+
+// authorizeOrder creates an order for the names and completes the challenge of each authorization
+// which isn't already valid, then waits for the order to be ready to finalize
+func (c *clientInfo) authorizeOrder(ctx context.Context, names []string, solver ChallengeSolver) (*acme.Order, error) {
+	order, err := c.client.AuthorizeOrder(ctx, acme.DomainIDs(names...))
+	if err != nil {
+		return nil, logger.Errorex("error creating order", err, golog.Strings("domains", names))
+	}
+
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := c.client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+		if solver == nil {
+			return nil, logger.Error("authorization required but no challenge solver given", golog.String("domain", authz.Identifier.Value))
+		}
+		challenge, err := c.authzChallenge(authz)
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+		if err := solver.PresentChallenge(challenge); err != nil {
+			return nil, logger.Errore(err)
+		}
+		err = c.CompleteAuthorize(ctx, challenge.AuthChallenge)
+		solver.CleanUpChallenge(challenge)
+		if err != nil {
+			return nil, logger.Errorex("authorization failed", err, golog.String("domain", authz.Identifier.Value))
+		}
+		logger.Info("authorization of domain successful", golog.String("domain", authz.Identifier.Value))
+	}
 
-	for _, cert := range c.CertificatesRaw {
-		block := pem.Block{
-			Type:  "CERTIFICATE",
-			Bytes: cert,
+	ready, err := c.client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	if ready.URI == "" {
+		// the location is only given when the order is created
+		ready.URI = order.URI
+	}
+	return ready, nil
+}
+
+// finalizeOrder submits the csr for a ready order and fetches the certificate chain.  The server
+// needn't give the order's location when it is finalized, in which case the order is waited on at
+// the location it was created at.
+func (c *clientInfo) finalizeOrder(ctx context.Context, order *acme.Order, csr []byte) ([][]byte, string, error) {
+	der, certURL, err := c.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err == nil {
+		return der, certURL, nil
+	}
+
+	finalized, waitErr := c.client.WaitOrder(ctx, order.URI)
+	if waitErr != nil || finalized.Status != acme.StatusValid || finalized.CertURL == "" {
+		return nil, "", err
+	}
+	der, err = c.client.FetchCert(ctx, finalized.CertURL, true)
+	if err != nil {
+		return nil, "", err
+	}
+	return der, finalized.CertURL, nil
+}
+
+// preferredChain looks for an alternate chain with the given root or issuer name, and returns the
+// default chain if there isn't one.
+func (c *clientInfo) preferredChain(ctx context.Context, certURL string, name string, der [][]byte, bundle []*x509.Certificate) ([][]byte, []*x509.Certificate) {
+	alternates, err := c.client.ListCertAlternates(ctx, certURL)
+	if err != nil {
+		logger.Errorex("unable to list alternate chains, using default chain", err)
+		return der, bundle
+	}
+
+	for _, url := range alternates {
+		altDER, err := c.client.FetchCert(ctx, url, true)
+		if err != nil {
+			logger.Errorex("unable to fetch alternate chain", err, golog.String("url", url))
+			continue
+		}
+		altBundle, err := parseCertificates(altDER)
+		if err != nil {
+			logger.Errorex("unable to parse alternate chain", err, golog.String("url", url))
+			continue
+		}
+		if chainMatches(altBundle, name) {
+			logger.Debug("using preferred chain", golog.String("chain", name), golog.String("url", url))
+			return altDER, altBundle
 		}
-		buf = append(buf, pem.EncodeToMemory(&block)...)
 	}
 
-	return buf
+	logger.Info("preferred chain not offered, using default chain", golog.String("chain", name))
+	return der, bundle
 }
 
-// PrivateKeyPEM encodes the private key to PEM format
+// chainMatches returns true if the top certificate in the chain is, or is issued by, the named CA
+func chainMatches(bundle []*x509.Certificate, name string) bool {
+	if len(bundle) == 0 {
+		return false
+	}
+	top := bundle[len(bundle)-1]
+	return top.Issuer.CommonName == name || top.Subject.CommonName == name
+}
+
+// CertificatesPEM encodes the certificates to PEM format
+func (c *CertificateBundle) CertificatesPEM() []byte {
+	return encodeCertificates(c.CertificatesRaw)
+}
+
+// PrivateKeyPEM encodes the private key to PEM format, or returns nil if there is no private key
 func (c *CertificateBundle) PrivateKeyPEM() []byte {
+	if len(c.PrivateKey) == 0 {
+		return nil
+	}
 	block := pem.Block{
 		Type:  c.PrivateKeyType + " PRIVATE KEY",
 		Bytes: c.PrivateKey,
@@ -299,6 +585,19 @@ func (c *CertificateBundle) PrivateKeyPEM() []byte {
 	return pem.EncodeToMemory(&block)
 }
 
+// marshalPrivateKey encodes a private key and gets its PEM type prefix
+func marshalPrivateKey(key crypto.Signer) ([]byte, string, error) {
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(key)
+		return der, "EC", err
+	case *rsa.PrivateKey:
+		return x509.MarshalPKCS1PrivateKey(key), "RSA", nil
+	default:
+		return nil, "", logger.Error("unsupported private key type")
+	}
+}
+
 // parseCertificates parses a DER-encoded certificate bundle into a slice of X509 Certificates
 func parseCertificates(der [][]byte) ([]*x509.Certificate, error) {
 	var err error
@@ -312,21 +611,25 @@ func parseCertificates(der [][]byte) ([]*x509.Certificate, error) {
 	return certs, nil
 }
 
-// certRequest creates a certificate request for the given common name cn and optional SANs.
+// certRequest creates a certificate request for the given common name cn and optional SANs.  The
+// common name is included in the SANs, as CAs issue for the SANs of the request.
 func certRequest(key crypto.Signer, cn string, san []string) ([]byte, error) {
 	req := &x509.CertificateRequest{
 		Subject:  pkix.Name{CommonName: cn},
-		DNSNames: san,
+		DNSNames: []string{cn},
+	}
+	for _, name := range san {
+		if name != cn {
+			req.DNSNames = append(req.DNSNames, name)
+		}
 	}
 	return x509.CreateCertificateRequest(rand.Reader, req, key)
 }
 
 // validateCertificateChain parses a cert chain provided as der argument and verifies the leaf, der[0],
-// corresponds to the private key, as well as the domain match and expiration dates.
+// corresponds to the public key, as well as the domain match and expiration dates.
 // It doesn't do any revocation checking.
-//
-// The returned value is the verified leaf cert.
-func validateCertificateChain(domain string, bundle []*x509.Certificate, key crypto.Signer) error {
+func validateCertificateChain(domain string, bundle []*x509.Certificate, key crypto.PublicKey) error {
 	// verify the leaf is not expired and matches the domain name
 	leaf := bundle[0]
 	now := time.Now()
@@ -339,23 +642,23 @@ func validateCertificateChain(domain string, bundle []*x509.Certificate, key cry
 	if err := leaf.VerifyHostname(domain); err != nil {
 		return err
 	}
-	// ensure the leaf corresponds to the private key
+	// ensure the leaf corresponds to the key
 	switch pub := leaf.PublicKey.(type) {
 	case *rsa.PublicKey:
-		prv, ok := key.(*rsa.PrivateKey)
+		req, ok := key.(*rsa.PublicKey)
 		if !ok {
-			return logger.Error("private key type does not match public key type")
+			return logger.Error("requested key type does not match public key type")
 		}
-		if pub.N.Cmp(prv.N) != 0 {
-			return logger.Error("private key does not match public key")
+		if pub.N.Cmp(req.N) != 0 {
+			return logger.Error("requested key does not match public key")
 		}
 	case *ecdsa.PublicKey:
-		prv, ok := key.(*ecdsa.PrivateKey)
+		req, ok := key.(*ecdsa.PublicKey)
 		if !ok {
-			return logger.Error("private key type does not match public key type")
+			return logger.Error("requested key type does not match public key type")
 		}
-		if pub.X.Cmp(prv.X) != 0 || pub.Y.Cmp(prv.Y) != 0 {
-			return logger.Error("private key does not match public key")
+		if pub.X.Cmp(req.X) != 0 || pub.Y.Cmp(req.Y) != 0 {
+			return logger.Error("requested key does not match public key")
 		}
 	default:
 		return logger.Error("unknown public key algorithm")
@@ -0,0 +1,129 @@
+package acmelib
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestPreferredChain(t *testing.T) {
+	leaf := newTestCertificate(t, "example.com")
+	defaultRoot := newTestCertificate(t, "Default Root")
+	altRoot := newTestCertificate(t, "Alt Root")
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "nonce")
+		switch r.URL.Path {
+		case "/directory":
+			json.NewEncoder(w).Encode(map[string]string{
+				"newNonce":   server.URL + "/nonce",
+				"newAccount": server.URL + "/account",
+				"newOrder":   server.URL + "/order",
+			})
+		case "/nonce":
+		case "/cert":
+			w.Header().Set("Content-Type", "application/pem-certificate-chain")
+			w.Header().Add("Link", `<`+server.URL+`/cert/missing>;rel="alternate"`)
+			w.Header().Add("Link", `<`+server.URL+`/cert/alt>;rel="alternate"`)
+			w.Write(pemChain(leaf, defaultRoot))
+		case "/cert/alt":
+			w.Header().Set("Content-Type", "application/pem-certificate-chain")
+			w.Write(pemChain(leaf, altRoot))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &clientInfo{client: &acme.Client{
+		DirectoryURL: server.URL + "/directory",
+		Key:          key,
+		KID:          acme.KeyID(server.URL + "/account/1"),
+	}}
+	der := [][]byte{leaf.Raw, defaultRoot.Raw}
+	bundle := []*x509.Certificate{leaf, defaultRoot}
+
+	tests := []struct {
+		preferred string
+		want      string
+	}{
+		{"Alt Root", "Alt Root"},
+		{"Default Root", "Default Root"},
+		{"Unknown Root", "Default Root"},
+	}
+	for _, test := range tests {
+		_, got := c.preferredChain(context.Background(), server.URL+"/cert", test.preferred, der, bundle)
+		if len(got) != 2 || got[1].Subject.CommonName != test.want {
+			t.Errorf("preferred %q: got chain ending in %q, want %q", test.preferred, got[len(got)-1].Subject.CommonName, test.want)
+		}
+	}
+}
+
+func TestChainMatches(t *testing.T) {
+	leaf := newTestCertificate(t, "example.com")
+	root := newTestCertificate(t, "ISRG Root X1")
+	tests := []struct {
+		bundle []*x509.Certificate
+		name   string
+		want   bool
+	}{
+		{nil, "ISRG Root X1", false},
+		{[]*x509.Certificate{leaf, root}, "ISRG Root X1", true},
+		{[]*x509.Certificate{leaf, root}, "example.com", false},
+		{[]*x509.Certificate{leaf}, "example.com", true},
+	}
+	for _, test := range tests {
+		if got := chainMatches(test.bundle, test.name); got != test.want {
+			t.Errorf("chainMatches(%d certificates, %q) = %v, want %v", len(test.bundle), test.name, got, test.want)
+		}
+	}
+}
+
+// newTestCertificate creates a self-signed certificate with the common name
+func newTestCertificate(t *testing.T, cn string) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// pemChain PEM encodes a certificate chain
+func pemChain(certs ...*x509.Certificate) []byte {
+	var data []byte
+	for _, cert := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return data
+}
@@ -0,0 +1,66 @@
+package acmelib
+
+import (
+	"crypto"
+	"encoding/pem"
+
+	"github.com/stugotech/coyote/cryptutil"
+)
+
+// NewCertificateBundleFromPEM creates a bundle from a PEM encoded certificate chain, leaf first,
+// and an optional PEM encoded private key
+func NewCertificateBundleFromPEM(chainPEM []byte, keyPEM []byte) (*CertificateBundle, error) {
+	certs, err := cryptutil.ParseCertificatesFromPEM(chainPEM)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	bundle := &CertificateBundle{
+		Certificates: certs,
+	}
+	for _, cert := range certs {
+		bundle.CertificatesRaw = append(bundle.CertificatesRaw, cert.Raw)
+	}
+
+	if len(keyPEM) > 0 {
+		block, _ := pem.Decode(keyPEM)
+		if block == nil {
+			return nil, logger.Error("no PEM data found for private key")
+		}
+		key, err := cryptutil.ParsePrivateKeyFromDER(block.Bytes)
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+		bundle.PrivateKey, bundle.PrivateKeyType, err = marshalPrivateKey(key)
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+	}
+	return bundle, nil
+}
+
+// LeafPEM encodes the leaf certificate to PEM format
+func (c *CertificateBundle) LeafPEM() []byte {
+	return encodeCertificates(c.CertificatesRaw[:1])
+}
+
+// ChainPEM encodes the issuer certificates, without the leaf, to PEM format
+func (c *CertificateBundle) ChainPEM() []byte {
+	return encodeCertificates(c.CertificatesRaw[1:])
+}
+
+// Signer parses the private key, or returns nil if there is no private key
+func (c *CertificateBundle) Signer() (crypto.Signer, error) {
+	if len(c.PrivateKey) == 0 {
+		return nil, nil
+	}
+	return cryptutil.ParsePrivateKeyFromDER(c.PrivateKey)
+}
+
+// encodeCertificates encodes DER certificates to PEM format
+func encodeCertificates(der [][]byte) []byte {
+	var buf []byte
+	for _, cert := range der {
+		buf = append(buf, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})...)
+	}
+	return buf
+}
@@ -3,14 +3,32 @@ package cmd
 import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"github.com/stugotech/coyote/store"
-	"github.com/stugotech/coyote/sync"
-	"github.com/stugotech/coyote/sync/vulcand"
+	"github.com/stugotech/coyote/sync/directory"
+	"github.com/stugotech/coyote/sync/webhook"
 )
 
 // Flags
 const (
-	VulcandKey = "vulcand"
+	CaddyAdminKey        = "caddy-admin"
+	HAProxyCrtListKey    = "haproxy-crt-list"
+	HAProxyDirKey        = "haproxy-dir"
+	HAProxySocketKey     = "haproxy-socket"
+	KubeCleanupKey       = "kube-cleanup"
+	KubeconfigKey        = "kubeconfig"
+	KubeNamespacesKey    = "kube-namespaces"
+	KubeSecretPrefixKey  = "kube-secret-prefix"
+	SyncDirKey           = "sync-dir"
+	SyncDirCertPathKey   = "sync-dir-cert-path"
+	SyncDirKeyPathKey    = "sync-dir-key-path"
+	SyncReloadCommandKey = "sync-reload-command"
+	TraefikConfigKey     = "traefik-config"
+	TraefikDirKey        = "traefik-dir"
+	VulcandKey           = "vulcand"
+	WebhookAttemptsKey   = "webhook-attempts"
+	WebhookGetURLKey     = "webhook-get-url"
+	WebhookSecretKey     = "webhook-secret"
+	WebhookTemplateKey   = "webhook-template"
+	WebhookURLsKey       = "webhook-urls"
 )
 
 // certsCmd represents the certs command
@@ -22,17 +40,28 @@ var certsCmd = &cobra.Command{
 
 func init() {
 	RootCmd.AddCommand(certsCmd)
-	pf := certsCmd.PersistentFlags()
+	// sync targets are shared with the sync commands, so they live on the root command; more can be
+	// listed in the config file under sync-targets
+	pf := RootCmd.PersistentFlags()
 	pf.String(VulcandKey, "", "A vulcand API endpoint to sync with")
+	pf.String(SyncDirKey, "", "A directory to write certificate and key files to")
+	pf.String(SyncDirCertPathKey, directory.DefaultCertPath, "Template for the path of each certificate file in the sync directory")
+	pf.String(SyncDirKeyPathKey, directory.DefaultKeyPath, "Template for the path of each key file in the sync directory")
+	pf.String(HAProxyDirKey, "", "A directory to write combined certificate files for HAProxy to")
+	pf.String(HAProxyCrtListKey, "", "The HAProxy crt-list file listing the certificate files")
+	pf.String(HAProxySocketKey, "", "The HAProxy runtime API socket (path or host:port) used to update certificates without a reload")
+	pf.String(TraefikDirKey, "", "A directory to write certificate files for the Traefik file provider to")
+	pf.String(TraefikConfigKey, "", "The Traefik dynamic configuration file listing the certificates")
+	pf.String(CaddyAdminKey, "", "A Caddy admin API endpoint to load certificates into, e.g. http://localhost:2019")
+	pf.StringSlice(KubeNamespacesKey, nil, "Comma-separated list of Kubernetes namespaces to write kubernetes.io/tls secrets to")
+	pf.String(KubeconfigKey, "", "kubeconfig file for the Kubernetes cluster; the in-cluster config is used if not given")
+	pf.String(KubeSecretPrefixKey, "", "Prefix for the names of Kubernetes secrets")
+	pf.Bool(KubeCleanupKey, false, "Delete the Kubernetes secrets of certificates which are deleted from the KV store")
+	pf.StringSlice(WebhookURLsKey, nil, "Comma-separated list of URLs to POST certificates to")
+	pf.String(WebhookSecretKey, "", "Secret used to sign webhook requests in the "+webhook.SignatureHeader+" header")
+	pf.String(WebhookTemplateKey, "", "File containing a Go template for the webhook request body; JSON is sent if not given")
+	pf.String(WebhookGetURLKey, "", "URL returning the certificate currently installed for {domain}, used to skip unchanged certificates")
+	pf.Int(WebhookAttemptsKey, webhook.DefaultAttempts, "Number of times to try each webhook request")
+	pf.String(SyncReloadCommandKey, "", "Shell command to run after certificate files have changed, e.g. \"nginx -s reload\"")
 	viper.BindPFlags(pf)
 }
-
-func certificateSync(certs []*store.Certificate) error {
-	vulcandEndpoint := viper.GetString(VulcandKey)
-	if vulcandEndpoint == "" {
-		return nil
-	}
-
-	vulcandClient := vulcand.NewClient(vulcandEndpoint)
-	return sync.Certificates(certs, vulcandClient)
-}
@@ -1,7 +1,17 @@
 package cmd
 
 import (
+	"io/ioutil"
+
 	"github.com/spf13/cobra"
+	"github.com/stugotech/coyote/store"
+)
+
+// Flags
+const (
+	CAFlag       = "ca"
+	CSRFlag      = "csr"
+	ReuseKeyFlag = "reuse-key"
 )
 
 // certsAddCmd represents the certsAdd command
@@ -9,23 +19,63 @@ var certsAddCmd = &cobra.Command{
 	Use:   "add",
 	Short: "Add a new certificate",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if len(args) < 1 {
+		fl := cmd.Flags()
+		ca, _ := fl.GetString(CAFlag)
+		csrFile, _ := fl.GetString(CSRFlag)
+		reuseKey, _ := fl.GetBool(ReuseKeyFlag)
+		overwrite, _ := fl.GetBool(OverwriteFlag)
+
+		if csrFile != "" && len(args) > 0 {
+			return NewCommandError(2, "domains are taken from the CSR and can't be specified")
+		}
+		if csrFile == "" && len(args) < 1 {
 			return NewCommandError(2, "must specify one or more domains")
 		}
+		if csrFile != "" && reuseKey {
+			return NewCommandError(2, "the key for a CSR is always reused")
+		}
+		if csrFile == "" && overwrite {
+			return NewCommandError(2, "--overwrite only applies to certificates for a CSR")
+		}
 		// init
 		coy, err := createCoyoteFromConfig()
 		if err != nil {
 			return NewCommandErrorF(255, "unable to create coyote: %v", err)
 		}
+		// get certificate from the user-supplied request
+		if csrFile != "" {
+			csr, err := ioutil.ReadFile(csrFile)
+			if err != nil {
+				return NewCommandErrorF(255, "unable to read CSR: %v", err)
+			}
+			cert, err := coy.NewCertificateFromCSR(ca, csr, overwrite)
+			if err != nil {
+				return NewCommandErrorF(255, "unable to get certificate for CSR: %v", err)
+			}
+			return certificateSync([]*store.Certificate{cert})
+		}
 		// get certificate
-		certs, err := coy.NewCertificate(args)
+		certs, err := coy.NewCertificateWithCA(ca, args)
 		if err != nil {
 			return NewCommandErrorF(255, "unable to get certificates (%v): %v", args, err)
 		}
+		if reuseKey {
+			for _, cert := range certs {
+				if err := coy.SetReuseKey(cert.Domain, true); err != nil {
+					return NewCommandErrorF(255, "unable to set key reuse for %q: %v", cert.Domain, err)
+				}
+				cert.ReuseKey = true
+			}
+		}
 		return certificateSync(certs)
 	},
 }
 
 func init() {
 	certsCmd.AddCommand(certsAddCmd)
+	fl := certsAddCmd.Flags()
+	fl.String(CAFlag, "", "name of the CA profile from the config file to request the certificate from")
+	fl.Bool(ReuseKeyFlag, false, "keep the same private key when the certificate is renewed")
+	fl.String(CSRFlag, "", "PEM or DER file containing a certificate request to use instead of generating a key")
+	fl.Bool(OverwriteFlag, false, "replace an existing certificate with a private key for the CSR's domain")
 }
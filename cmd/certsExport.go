@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/stugotech/coyote/export"
+	"github.com/stugotech/coyote/store"
+	"github.com/stugotech/goconfig"
+)
+
+// Flags
+const (
+	BundleNameFlag     = "bundle-name"
+	CertNameFlag       = "cert-name"
+	ChainNameFlag      = "chain-name"
+	FileModeFlag       = "file-mode"
+	FormatFlag         = "format"
+	FullChainNameFlag  = "fullchain-name"
+	KeyModeFlag        = "key-mode"
+	KeyNameFlag        = "key-name"
+	OutFlag            = "out"
+	PassphraseFlag     = "passphrase"
+	PassphraseFileFlag = "passphrase-file"
+)
+
+// certsExportCmd represents the certsExport command
+var certsExportCmd = &cobra.Command{
+	Use:   "export [domain]",
+	Short: "Export a certificate to files",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return NewCommandError(2, "must specify domain")
+		}
+		options, err := exportOptionsFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+		// init
+		st, err := store.NewStoreFromConfig(goconfig.Viper())
+		if err != nil {
+			return NewCommandErrorF(255, "unable to create store: %v", err)
+		}
+		// get certificate
+		cert, err := st.GetCertificate(args[0])
+		if err != nil {
+			return NewCommandErrorF(255, "unable to get certificate: %v", err)
+		}
+		if cert == nil {
+			return NewUserErrorF("no certificate found for %q", args[0])
+		}
+		// export
+		paths, err := export.Certificate(cert, options)
+		for _, path := range paths {
+			fmt.Println(path)
+		}
+		if err != nil {
+			return NewCommandErrorF(255, "unable to export certificate: %v", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	certsCmd.AddCommand(certsExportCmd)
+	fl := certsExportCmd.Flags()
+	fl.String(OutFlag, ".", "directory to write the files to")
+	fl.String(FormatFlag, export.FormatPEM, "format of the files [pem|der|pkcs12|jks-compatible]")
+	fl.String(FullChainNameFlag, "", "name of the file containing the leaf and issuer certificates (pem only)")
+	fl.String(CertNameFlag, "", "name of the leaf certificate file")
+	fl.String(ChainNameFlag, "", "name of the issuer certificates file; for der, an index is added for each issuer")
+	fl.String(KeyNameFlag, "", "name of the private key file")
+	fl.String(BundleNameFlag, "", "name of the PKCS#12 bundle file")
+	fl.String(FileModeFlag, "0644", "permissions of the certificate files")
+	fl.String(KeyModeFlag, "0600", "permissions of the private key file and PKCS#12 bundle")
+	fl.String(PassphraseFlag, "", "passphrase protecting the PKCS#12 bundle")
+	fl.String(PassphraseFileFlag, "", "file containing the passphrase protecting the PKCS#12 bundle")
+}
+
+// exportOptionsFromFlags reads the export options from the command's flags
+func exportOptionsFromFlags(cmd *cobra.Command) (*export.Options, error) {
+	fl := cmd.Flags()
+	options := &export.Options{}
+	options.Dir, _ = fl.GetString(OutFlag)
+	options.Format, _ = fl.GetString(FormatFlag)
+	options.Names.FullChain, _ = fl.GetString(FullChainNameFlag)
+	options.Names.Leaf, _ = fl.GetString(CertNameFlag)
+	options.Names.Chain, _ = fl.GetString(ChainNameFlag)
+	options.Names.Key, _ = fl.GetString(KeyNameFlag)
+	options.Names.Bundle, _ = fl.GetString(BundleNameFlag)
+	options.Passphrase, _ = fl.GetString(PassphraseFlag)
+
+	var err error
+	fileMode, _ := fl.GetString(FileModeFlag)
+	if options.FileMode, err = parseFileMode(fileMode); err != nil {
+		return nil, NewUserErrorF("invalid %s: %v", FileModeFlag, err)
+	}
+	keyMode, _ := fl.GetString(KeyModeFlag)
+	if options.KeyMode, err = parseFileMode(keyMode); err != nil {
+		return nil, NewUserErrorF("invalid %s: %v", KeyModeFlag, err)
+	}
+
+	passphraseFile, _ := fl.GetString(PassphraseFileFlag)
+	if passphraseFile != "" {
+		if options.Passphrase != "" {
+			return nil, NewCommandErrorF(2, "only one of %s and %s can be given", PassphraseFlag, PassphraseFileFlag)
+		}
+		passphrase, err := ioutil.ReadFile(passphraseFile)
+		if err != nil {
+			return nil, NewCommandErrorF(255, "unable to read passphrase: %v", err)
+		}
+		options.Passphrase = strings.TrimRight(string(passphrase), "\r\n")
+	}
+
+	switch options.Format {
+	case export.FormatPEM, export.FormatDER:
+	case export.FormatPKCS12, export.FormatJKSCompatible:
+		if options.Passphrase == "" {
+			return nil, NewCommandErrorF(2, "a passphrase is required for the %s format", options.Format)
+		}
+	default:
+		return nil, NewUserErrorF("unknown format %q", options.Format)
+	}
+	return options, nil
+}
+
+// parseFileMode parses octal file permissions, e.g. "0640"
+func parseFileMode(mode string) (os.FileMode, error) {
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	return os.FileMode(m) & os.ModePerm, nil
+}
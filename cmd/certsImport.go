@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stugotech/coyote/sync/vulcand"
+)
+
+// Flags
+const (
+	CertFlag        = "cert"
+	CertbotFlag     = "certbot"
+	FromVulcandFlag = "from-vulcand"
+	KeyFlag         = "key"
+)
+
+// certbot file names
+const (
+	certbotChainFile = "fullchain.pem"
+	certbotKeyFile   = "privkey.pem"
+)
+
+// importSource is a certificate chain and private key to import
+type importSource struct {
+	name     string
+	chainPEM []byte
+	keyPEM   []byte
+}
+
+// certsImportCmd represents the certsImport command
+var certsImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import existing certificates so that coyote renews them",
+	Long: `Import existing certificates so that coyote renews them.
+
+Certificates can be read from a PEM chain and key file, from a certbot live directory
+(e.g. /etc/letsencrypt/live) or from the hosts in the configured vulcand.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fl := cmd.Flags()
+		ca, _ := fl.GetString(CAFlag)
+		certFile, _ := fl.GetString(CertFlag)
+		keyFile, _ := fl.GetString(KeyFlag)
+		certbotDir, _ := fl.GetString(CertbotFlag)
+		fromVulcand, _ := fl.GetBool(FromVulcandFlag)
+		overwrite, _ := fl.GetBool(OverwriteFlag)
+
+		if len(args) > 0 {
+			return NewCommandError(2, "too many arguments")
+		}
+		if (certFile == "") != (keyFile == "") {
+			return NewCommandErrorF(2, "both %s and %s must be given", CertFlag, KeyFlag)
+		}
+		sourceCount := 0
+		for _, given := range []bool{certFile != "", certbotDir != "", fromVulcand} {
+			if given {
+				sourceCount++
+			}
+		}
+		if sourceCount != 1 {
+			return NewCommandErrorF(2, "must specify exactly one of %s, %s or %s", CertFlag, CertbotFlag, FromVulcandFlag)
+		}
+
+		// read the certificates to import
+		var sources []*importSource
+		var err error
+		switch {
+		case certFile != "":
+			var source *importSource
+			source, err = readImportFiles(certFile, certFile, keyFile)
+			sources = []*importSource{source}
+		case certbotDir != "":
+			sources, err = readCertbotDir(certbotDir)
+		default:
+			sources, err = readVulcandHosts()
+		}
+		if err != nil {
+			return NewCommandErrorF(255, "unable to read certificates: %v", err)
+		}
+
+		// init
+		coy, err := createCoyoteFromConfig()
+		if err != nil {
+			return NewCommandErrorF(255, "unable to create coyote: %v", err)
+		}
+
+		// import
+		failed := 0
+		for _, source := range sources {
+			cert, err := coy.ImportCertificate(ca, source.chainPEM, source.keyPEM, overwrite)
+			switch {
+			case err != nil:
+				failed++
+				fmt.Printf("failed   %s: %v\n", source.name, err)
+			case cert == nil:
+				fmt.Printf("skipped  %s: certificate already exists\n", source.name)
+			default:
+				fmt.Printf("imported %s: %s (expires %s)\n", source.name, cert.Domain, cert.Expires.Format("2006-01-02"))
+			}
+		}
+		if failed > 0 {
+			return NewCommandErrorF(255, "unable to import %d of %d certificates", failed, len(sources))
+		}
+		return nil
+	},
+}
+
+func init() {
+	certsCmd.AddCommand(certsImportCmd)
+	fl := certsImportCmd.Flags()
+	fl.String(CertFlag, "", "PEM file containing the certificate chain, leaf first")
+	fl.String(KeyFlag, "", "PEM file containing the private key")
+	fl.String(CertbotFlag, "", "certbot live directory containing a directory per certificate, or a single certificate's directory")
+	fl.Bool(FromVulcandFlag, false, "import the certificates of the hosts in the vulcand given by --vulcand")
+	fl.String(CAFlag, "", "name of the CA profile from the config file to renew the certificates with")
+	fl.Bool(OverwriteFlag, false, "replace certificates which already exist in the store")
+}
+
+// readImportFiles reads a certificate chain and private key from PEM files
+func readImportFiles(name string, certFile string, keyFile string) (*importSource, error) {
+	chainPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &importSource{name: name, chainPEM: chainPEM, keyPEM: keyPEM}, nil
+}
+
+// readCertbotDir reads the certificates in a certbot live directory, which has a directory per
+// certificate, or a single certificate's directory
+func readCertbotDir(dir string) ([]*importSource, error) {
+	if isCertbotCertDir(dir) {
+		source, err := readCertbotCertDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		return []*importSource{source}, nil
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var sources []*importSource
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !entry.IsDir() || !isCertbotCertDir(path) {
+			continue
+		}
+		source, err := readCertbotCertDir(path)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no certificates found in %s", dir)
+	}
+	return sources, nil
+}
+
+// isCertbotCertDir returns true if the directory contains a certbot certificate
+func isCertbotCertDir(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, certbotChainFile))
+	return err == nil
+}
+
+// readCertbotCertDir reads the certificate in a certbot certificate directory; the files are
+// usually links into the archive directory, which are followed
+func readCertbotCertDir(dir string) (*importSource, error) {
+	return readImportFiles(filepath.Base(dir), filepath.Join(dir, certbotChainFile), filepath.Join(dir, certbotKeyFile))
+}
+
+// readVulcandHosts reads the certificates of the hosts in vulcand.  Hosts which share a
+// certificate are only imported once.
+func readVulcandHosts() ([]*importSource, error) {
+	endpoint := viper.GetString(VulcandKey)
+	if endpoint == "" {
+		return nil, fmt.Errorf("--%s must be given to import from vulcand", VulcandKey)
+	}
+	hosts, err := vulcand.NewClient(endpoint).GetHosts()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var sources []*importSource
+	for _, host := range hosts {
+		if host.CertificatePEM == "" || seen[host.CertificatePEM] {
+			continue
+		}
+		seen[host.CertificatePEM] = true
+		sources = append(sources, &importSource{
+			name:     host.Domain,
+			chainPEM: []byte(host.CertificatePEM),
+			keyPEM:   []byte(host.PrivateKeyPEM),
+		})
+	}
+	return sources, nil
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stugotech/coyote/store"
+)
+
+// Certificate statuses
+const (
+	StatusValid        = "valid"
+	StatusExpiring     = "expiring"
+	StatusExpired      = "expired"
+	StatusRenewalError = "renewal-failed"
+)
+
+// expiringThreshold is how long before expiry a certificate is reported as expiring; it matches
+// the renewal threshold used by renew and watch
+const expiringThreshold = time.Duration(7) * time.Hour * 24
+
+// certificateSummary describes a certificate in the list output
+type certificateSummary struct {
+	Domain           string    `json:"domain" yaml:"domain"`
+	AlternativeNames []string  `json:"alternativeNames" yaml:"alternativeNames"`
+	Expires          time.Time `json:"expires" yaml:"expires"`
+	DaysLeft         int       `json:"daysLeft" yaml:"daysLeft"`
+	Issuer           string    `json:"issuer" yaml:"issuer"`
+	KeyType          string    `json:"keyType" yaml:"keyType"`
+	Status           string    `json:"status" yaml:"status"`
+}
+
+// certsListCmd represents the certsList command
+var certsListCmd = &cobra.Command{
+	Use:     "list",
+	Short:   "List the certificates managed by coyote",
+	Aliases: []string{"ls"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// init
+		coy, err := createCoyoteFromConfig()
+		if err != nil {
+			return NewCommandErrorF(255, "unable to create coyote: %v", err)
+		}
+		// get certificates
+		certs, err := coy.GetCertificates()
+		if err != nil {
+			return NewCommandErrorF(255, "unable to get certificates: %v", err)
+		}
+		sort.Slice(certs, func(i, j int) bool { return certs[i].Domain < certs[j].Domain })
+
+		now := time.Now()
+		summaries := make([]*certificateSummary, 0, len(certs))
+		for _, cert := range certs {
+			summaries = append(summaries, newCertificateSummary(cert, now))
+		}
+
+		return writeOutput(cmd, summaries, func(w io.Writer) {
+			fmt.Fprintln(w, "DOMAIN\tALTERNATIVE NAMES\tEXPIRES\tDAYS LEFT\tISSUER\tKEY TYPE\tSTATUS")
+			for _, s := range summaries {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+					s.Domain,
+					strings.Join(s.AlternativeNames, ","),
+					s.Expires.Format(time.RFC3339),
+					s.DaysLeft,
+					s.Issuer,
+					s.KeyType,
+					s.Status,
+				)
+			}
+		})
+	},
+}
+
+func init() {
+	certsCmd.AddCommand(certsListCmd)
+	addOutputFlag(certsListCmd)
+}
+
+// newCertificateSummary creates the list output for a certificate
+func newCertificateSummary(cert *store.Certificate, now time.Time) *certificateSummary {
+	return &certificateSummary{
+		Domain:           cert.Domain,
+		AlternativeNames: cert.AlternativeNames,
+		Expires:          cert.Expires,
+		DaysLeft:         int(cert.Expires.Sub(now).Hours() / 24),
+		Issuer:           cert.Issuer,
+		KeyType:          cert.KeyType,
+		Status:           certificateStatus(cert, now),
+	}
+}
+
+// certificateStatus describes the state of a certificate
+func certificateStatus(cert *store.Certificate, now time.Time) string {
+	switch {
+	case now.After(cert.Expires):
+		return StatusExpired
+	case cert.LastRenewalError != "":
+		return StatusRenewalError
+	case now.Add(expiringThreshold).After(cert.Expires):
+		return StatusExpiring
+	default:
+		return StatusValid
+	}
+}
@@ -17,11 +17,15 @@ var certsRenewCmd = &cobra.Command{
 			return NewCommandErrorF(255, "unable to create coyote: %v", err)
 		}
 		// renew certificates that will expire in less than a week
-		certs, err := coy.RenewExpiringCertificates(time.Duration(7) * time.Hour * 24)
-		if err != nil {
-			return NewCommandErrorF(255, "unable to renew certificates (%v): %v", args, err)
+		certs, renewErr := coy.RenewExpiringCertificates(time.Duration(7) * time.Hour * 24)
+		// sync the certificates which were renewed even if others failed
+		if err := certificateSync(certs); err != nil {
+			return NewCommandErrorF(255, "unable to sync certificates: %v", err)
+		}
+		if renewErr != nil {
+			return NewCommandErrorF(255, "unable to renew certificates (%v): %v", args, renewErr)
 		}
-		return certificateSync(certs)
+		return nil
 	},
 }
 
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// Flags
+const (
+	DisableFlag = "disable"
+)
+
+// certsReuseKeyCmd represents the certsReuseKey command
+var certsReuseKeyCmd = &cobra.Command{
+	Use:   "reuse-key [domain...]",
+	Short: "Keep the same private key when renewing certificates",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return NewCommandError(2, "must specify one or more domains")
+		}
+		disable, _ := cmd.Flags().GetBool(DisableFlag)
+		// init
+		coy, err := createCoyoteFromConfig()
+		if err != nil {
+			return NewCommandErrorF(255, "unable to create coyote: %v", err)
+		}
+		// update certificates
+		for _, domain := range args {
+			if err := coy.SetReuseKey(domain, !disable); err != nil {
+				return NewCommandErrorF(255, "unable to set key reuse for %q: %v", domain, err)
+			}
+			fmt.Printf("key reuse for %q set to %v\n", domain, !disable)
+		}
+		return nil
+	},
+}
+
+func init() {
+	certsCmd.AddCommand(certsReuseKeyCmd)
+	fl := certsReuseKeyCmd.Flags()
+	fl.Bool(DisableFlag, false, "generate a new private key on each renewal instead")
+}
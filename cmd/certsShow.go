@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stugotech/coyote/cryptutil"
+)
+
+// ocspTimeout limits how long to wait for the OCSP responder
+const ocspTimeout = 10 * time.Second
+
+// chainCertificate describes a certificate in the chain in the show output
+type chainCertificate struct {
+	Subject           string    `json:"subject" yaml:"subject"`
+	Issuer            string    `json:"issuer" yaml:"issuer"`
+	SerialNumber      string    `json:"serialNumber" yaml:"serialNumber"`
+	NotBefore         time.Time `json:"notBefore" yaml:"notBefore"`
+	NotAfter          time.Time `json:"notAfter" yaml:"notAfter"`
+	KeyType           string    `json:"keyType" yaml:"keyType"`
+	SHA1Fingerprint   string    `json:"sha1Fingerprint" yaml:"sha1Fingerprint"`
+	SHA256Fingerprint string    `json:"sha256Fingerprint" yaml:"sha256Fingerprint"`
+}
+
+// certificateDetails describes a certificate in the show output
+type certificateDetails struct {
+	certificateSummary `yaml:",inline"`
+	SerialNumber       string              `json:"serialNumber" yaml:"serialNumber"`
+	NotBefore          time.Time           `json:"notBefore" yaml:"notBefore"`
+	Thumbprint         string              `json:"thumbprint" yaml:"thumbprint"`
+	CA                 string              `json:"ca" yaml:"ca"`
+	DirectoryURI       string              `json:"directory" yaml:"directory"`
+	AccountEmail       string              `json:"accountEmail" yaml:"accountEmail"`
+	Issued             time.Time           `json:"issued" yaml:"issued"`
+	RenewalCount       int                 `json:"renewalCount" yaml:"renewalCount"`
+	LastRenewalAttempt time.Time           `json:"lastRenewalAttempt" yaml:"lastRenewalAttempt"`
+	LastRenewalError   string              `json:"lastRenewalError,omitempty" yaml:"lastRenewalError,omitempty"`
+	ReuseKey           bool                `json:"reuseKey" yaml:"reuseKey"`
+	HasPrivateKey      bool                `json:"hasPrivateKey" yaml:"hasPrivateKey"`
+	OCSPStatus         string              `json:"ocspStatus" yaml:"ocspStatus"`
+	Chain              []*chainCertificate `json:"chain" yaml:"chain"`
+}
+
+// certsShowCmd represents the certsShow command
+var certsShowCmd = &cobra.Command{
+	Use:   "show [domain]",
+	Short: "Show the details of a certificate",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return NewCommandError(2, "must specify domain")
+		}
+		// init
+		coy, err := createCoyoteFromConfig()
+		if err != nil {
+			return NewCommandErrorF(255, "unable to create coyote: %v", err)
+		}
+		// get certificate
+		cert, err := coy.GetCertificate(args[0])
+		if err != nil {
+			return NewCommandErrorF(255, "unable to get certificate: %v", err)
+		}
+		if cert == nil {
+			return NewUserErrorF("no certificate found for %q", args[0])
+		}
+		chain, err := cryptutil.ParseCertificatesFromPEM(cert.CertificateChain)
+		if err != nil {
+			return NewCommandErrorF(255, "unable to parse certificate chain: %v", err)
+		}
+
+		details := &certificateDetails{
+			certificateSummary: *newCertificateSummary(cert, time.Now()),
+			SerialNumber:       cert.SerialNumber,
+			NotBefore:          cert.NotBefore,
+			Thumbprint:         cert.Thumbprint,
+			CA:                 cert.CA,
+			DirectoryURI:       cert.DirectoryURI,
+			AccountEmail:       cert.AccountEmail,
+			Issued:             cert.Issued,
+			RenewalCount:       cert.RenewalCount,
+			LastRenewalAttempt: cert.LastRenewalAttempt,
+			LastRenewalError:   cert.LastRenewalError,
+			ReuseKey:           cert.ReuseKey,
+			HasPrivateKey:      len(cert.PrivateKey) > 0,
+			OCSPStatus:         ocspStatus(chain),
+		}
+		for _, c := range chain {
+			details.Chain = append(details.Chain, newChainCertificate(c))
+		}
+
+		return writeOutput(cmd, details, func(w io.Writer) {
+			fmt.Fprintf(w, "Domain:\t%s\n", details.Domain)
+			fmt.Fprintf(w, "Alternative names:\t%s\n", strings.Join(details.AlternativeNames, ", "))
+			fmt.Fprintf(w, "Status:\t%s\n", details.Status)
+			fmt.Fprintf(w, "OCSP status:\t%s\n", details.OCSPStatus)
+			fmt.Fprintf(w, "Not before:\t%s\n", details.NotBefore.Format(time.RFC3339))
+			fmt.Fprintf(w, "Expires:\t%s (%d days)\n", details.Expires.Format(time.RFC3339), details.DaysLeft)
+			fmt.Fprintf(w, "Issuer:\t%s\n", details.Issuer)
+			fmt.Fprintf(w, "Serial number:\t%s\n", details.SerialNumber)
+			fmt.Fprintf(w, "Key type:\t%s\n", details.KeyType)
+			fmt.Fprintf(w, "Private key stored:\t%v\n", details.HasPrivateKey)
+			fmt.Fprintf(w, "Reuse key:\t%v\n", details.ReuseKey)
+			fmt.Fprintf(w, "Thumbprint:\t%s\n", details.Thumbprint)
+			fmt.Fprintf(w, "CA:\t%s (%s)\n", details.CA, details.DirectoryURI)
+			fmt.Fprintf(w, "Account:\t%s\n", details.AccountEmail)
+			fmt.Fprintf(w, "Issued:\t%s\n", details.Issued.Format(time.RFC3339))
+			fmt.Fprintf(w, "Renewals:\t%d\n", details.RenewalCount)
+			if details.LastRenewalError != "" {
+				fmt.Fprintf(w, "Last renewal error:\t%s (%s)\n", details.LastRenewalError, details.LastRenewalAttempt.Format(time.RFC3339))
+			}
+			for i, c := range details.Chain {
+				fmt.Fprintf(w, "\nChain [%d]\n", i)
+				fmt.Fprintf(w, "  Subject:\t%s\n", c.Subject)
+				fmt.Fprintf(w, "  Issuer:\t%s\n", c.Issuer)
+				fmt.Fprintf(w, "  Serial number:\t%s\n", c.SerialNumber)
+				fmt.Fprintf(w, "  Valid:\t%s to %s\n", c.NotBefore.Format(time.RFC3339), c.NotAfter.Format(time.RFC3339))
+				fmt.Fprintf(w, "  Key type:\t%s\n", c.KeyType)
+				fmt.Fprintf(w, "  SHA-1:\t%s\n", c.SHA1Fingerprint)
+				fmt.Fprintf(w, "  SHA-256:\t%s\n", c.SHA256Fingerprint)
+			}
+		})
+	},
+}
+
+func init() {
+	certsCmd.AddCommand(certsShowCmd)
+	addOutputFlag(certsShowCmd)
+}
+
+// newChainCertificate creates the show output for a certificate in the chain
+func newChainCertificate(cert *x509.Certificate) *chainCertificate {
+	sha := sha256.Sum256(cert.Raw)
+	return &chainCertificate{
+		Subject:           cert.Subject.String(),
+		Issuer:            cert.Issuer.String(),
+		SerialNumber:      fmt.Sprintf("%x", cert.SerialNumber),
+		NotBefore:         cert.NotBefore,
+		NotAfter:          cert.NotAfter,
+		KeyType:           cryptutil.KeyType(cert.PublicKey),
+		SHA1Fingerprint:   cryptutil.Thumbprint(cert.Raw),
+		SHA256Fingerprint: hex.EncodeToString(sha[:]),
+	}
+}
+
+// ocspStatus gets the OCSP status of the leaf certificate, or a description of why it couldn't be
+// checked
+func ocspStatus(chain []*x509.Certificate) string {
+	if len(chain) < 2 {
+		return "unavailable (no issuer in chain)"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ocspTimeout)
+	defer cancel()
+
+	status, err := cryptutil.GetOCSPStatus(ctx, chain[0], chain[1])
+	if err != nil {
+		return fmt.Sprintf("unavailable (%v)", err)
+	}
+	return status
+}
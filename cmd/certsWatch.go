@@ -21,12 +21,13 @@ var certsWatchCmd = &cobra.Command{
 		for {
 			// renew certificates that will expire in less than a week
 			certs, err := coy.RenewExpiringCertificates(day * 7)
-			if err == nil {
-				err = certificateSync(certs)
-			}
 			if err != nil {
 				logger.Errore(err)
 			}
+			// sync the certificates which were renewed even if others failed
+			if err = certificateSync(certs); err != nil {
+				logger.Errore(err)
+			}
 			time.Sleep(day)
 		}
 	},
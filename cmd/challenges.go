@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// challengesCmd represents the challenges command
+var challengesCmd = &cobra.Command{
+	Use:   "challenges [command]",
+	Short: "Manage ACME challenges in the KV store",
+}
+
+func init() {
+	RootCmd.AddCommand(challengesCmd)
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stugotech/coyote/store"
+	"github.com/stugotech/goconfig"
+)
+
+// challengesGcCmd represents the challengesGc command
+var challengesGcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove stale challenges from the KV store",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// init
+		st, err := store.NewStoreFromConfig(goconfig.Viper())
+		if err != nil {
+			return NewCommandErrorF(255, "unable to create store: %v", err)
+		}
+		// purge challenges
+		deleted, err := store.DeleteExpiredChallenges(st, time.Now(), viper.GetDuration(ChallengeTTLFlag))
+		if err != nil {
+			return NewCommandErrorF(255, "unable to remove stale challenges: %v", err)
+		}
+		fmt.Printf("removed %d stale challenges\n", len(deleted))
+		return nil
+	},
+}
+
+func init() {
+	challengesCmd.AddCommand(challengesGcCmd)
+}
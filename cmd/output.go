@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats
+const (
+	OutputFlag  = "output"
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+)
+
+// addOutputFlag adds the output format flag to a command
+func addOutputFlag(cmd *cobra.Command) {
+	cmd.Flags().StringP(OutputFlag, "o", OutputTable, "output format [table|json|yaml]")
+}
+
+// writeOutput writes the value to stdout in the format chosen by the output flag, using the table
+// function for table output
+func writeOutput(cmd *cobra.Command, value interface{}, table func(w io.Writer)) error {
+	format, _ := cmd.Flags().GetString(OutputFlag)
+
+	switch format {
+	case OutputJSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(value)
+	case OutputYAML:
+		bytes, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(bytes)
+		return err
+	case OutputTable, "":
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		table(w)
+		return w.Flush()
+	default:
+		return NewUserErrorF("unknown output format %q", format)
+	}
+}
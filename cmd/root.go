@@ -18,17 +18,27 @@ var logger = golog.NewPackageLogger()
 const (
 	AcceptTOSFlag          = "accept-tos"
 	AcmeDirectoryFlag      = "acme-directory"
+	ChallengeTTLFlag       = "challenge-ttl"
 	ConfigFlag             = "config"
 	EmailFlag              = "email"
+	FallbackCAsFlag        = "fallback-cas"
 	LetsEncryptStagingFlag = "le-staging"
 	LogFlag                = "log"
+	PreferredChainFlag     = "preferred-chain"
 	SealKeyFlag            = "seal-key"
+	SealPassphraseFlag     = "seal-passphrase"
+)
+
+// Config keys which can only be set in the config file
+const (
+	// CAsKey is a list of named CA profiles, each with a name, directory, email and accept-tos
+	CAsKey = "cas"
 )
 
 // Default flag values
 var (
-	AcmeDirectoryProduction = "https://acme-v01.api.letsencrypt.org/directory"
-	AcmeDirectoryStaging    = "https://acme-staging.api.letsencrypt.org/directory"
+	AcmeDirectoryProduction = "https://acme-v02.api.letsencrypt.org/directory"
+	AcmeDirectoryStaging    = "https://acme-staging-v02.api.letsencrypt.org/directory"
 	LogDefault              = "info"
 	StoreDefault            = "etcd"
 	StoreNodesDefault       = []string{"127.0.0.1:2379"}
@@ -62,6 +72,9 @@ func init() {
 	pf.String(AcmeDirectoryFlag, AcmeDirectoryProduction, "ACME directory")
 	pf.Bool(AcceptTOSFlag, false, "accept the terms of the ACME service")
 	pf.String(EmailFlag, "", "the contact email address of the registrant")
+	pf.String(PreferredChainFlag, "", "Common name of the root or issuer of the preferred chain, e.g. \"ISRG Root X1\"")
+	pf.StringSlice(FallbackCAsFlag, nil, "Comma-separated list of CA profiles to try in order if the requested CA fails")
+	pf.Duration(ChallengeTTLFlag, coyote.DefaultChallengeTTL, "how long ACME challenges are kept in the KV store")
 
 	// KV store settings
 	pf.String(store.StoreKey, StoreDefault, "Name of the KV store to use [etcd|consul|boltdb|zookeeper]")
@@ -70,6 +83,7 @@ func init() {
 
 	// other settings
 	pf.String(SealKeyFlag, "", "Key used to encrypt secret values")
+	pf.String(SealPassphraseFlag, "", "Passphrase used to derive the key to encrypt secret values, if no seal key is given")
 
 	// bind all persistent flags to config
 	viper.BindPFlags(pf)
@@ -99,13 +113,22 @@ func createCoyoteFromConfig() (coyote.Coyote, error) {
 	if err != nil {
 		return nil, err
 	}
+	var cas []*coyote.CAProfile
+	if err := viper.UnmarshalKey(CAsKey, &cas); err != nil {
+		return nil, err
+	}
 	return coyote.NewCoyote(
 		&coyote.Config{
-			AcceptTOS:    viper.GetBool(AcceptTOSFlag),
-			ContactEmail: viper.GetString(EmailFlag),
-			DirectoyURI:  viper.GetString(AcmeDirectoryFlag),
-			SecretKey:    viper.GetString(SealKeyFlag),
-			Store:        store,
+			AcceptTOS:        viper.GetBool(AcceptTOSFlag),
+			CAs:              cas,
+			FallbackCAs:      viper.GetStringSlice(FallbackCAsFlag),
+			PreferredChain:   viper.GetString(PreferredChainFlag),
+			ChallengeTTL:     viper.GetDuration(ChallengeTTLFlag),
+			ContactEmail:     viper.GetString(EmailFlag),
+			DirectoyURI:      viper.GetString(AcmeDirectoryFlag),
+			SecretKey:        viper.GetString(SealKeyFlag),
+			SecretPassphrase: viper.GetString(SealPassphraseFlag),
+			Store:            store,
 		},
 	)
 }
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/stugotech/coyote/sds"
+	"github.com/stugotech/coyote/store"
+	"github.com/stugotech/goconfig"
+)
+
+// Flags
+const (
+	ClientCAFlag = "client-ca"
+	TLSCertFlag  = "tls-cert"
+	TLSKeyFlag   = "tls-key"
+)
+
+// sdsCmd represents the sds command
+var sdsCmd = &cobra.Command{
+	Use:   "sds [interface]",
+	Short: "Serve certificates to Envoy over the Secret Discovery Service",
+	Long: `Serve certificates to Envoy over the Secret Discovery Service.
+
+Each certificate is served as a TLS certificate secret named after its domain, and connected
+Envoys are sent the new certificate when it changes in the KV store.  The interface is either
+host:port or unix:<path>.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return NewCommandError(2, "must specify interface to listen on")
+		}
+		tlsConfig, err := sdsTLSConfigFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+		if tlsConfig == nil && !strings.HasPrefix(args[0], "unix:") {
+			logger.Info("serving private keys without TLS; use --" + TLSCertFlag + " or a unix socket")
+		}
+		// init
+		st, err := store.NewStoreFromConfig(goconfig.Viper())
+		if err != nil {
+			return NewCommandErrorF(255, "unable to create store: %v", err)
+		}
+		err = sds.NewServer(st, args[0], tlsConfig).Listen()
+		if err != nil {
+			return NewCommandErrorF(255, "error while serving secrets: %v", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(sdsCmd)
+	fl := sdsCmd.Flags()
+	fl.String(TLSCertFlag, "", "PEM file containing the certificate chain for the SDS server")
+	fl.String(TLSKeyFlag, "", "PEM file containing the private key for the SDS server")
+	fl.String(ClientCAFlag, "", "PEM file containing the CA certificates which Envoy client certificates must be signed by")
+}
+
+// sdsTLSConfigFromFlags creates the TLS settings for the SDS server, or nil if TLS isn't used
+func sdsTLSConfigFromFlags(cmd *cobra.Command) (*tls.Config, error) {
+	fl := cmd.Flags()
+	certFile, _ := fl.GetString(TLSCertFlag)
+	keyFile, _ := fl.GetString(TLSKeyFlag)
+	clientCAFile, _ := fl.GetString(ClientCAFlag)
+
+	if certFile == "" && keyFile == "" {
+		if clientCAFile != "" {
+			return nil, NewCommandErrorF(2, "--%s requires --%s and --%s", ClientCAFlag, TLSCertFlag, TLSKeyFlag)
+		}
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, NewCommandErrorF(2, "both --%s and --%s must be given", TLSCertFlag, TLSKeyFlag)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, NewCommandErrorF(255, "unable to load TLS certificate: %v", err)
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		caPEM, err := ioutil.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, NewCommandErrorF(255, "unable to read client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, NewUserErrorF("no certificates found in %s", clientCAFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// storeCmd represents the store command
+var storeCmd = &cobra.Command{
+	Use:   "store [command]",
+	Short: "Manage the KV store",
+}
+
+func init() {
+	RootCmd.AddCommand(storeCmd)
+}
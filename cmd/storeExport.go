@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stugotech/coyote/coyote"
+	"github.com/stugotech/coyote/store"
+	"github.com/stugotech/goconfig"
+)
+
+// storeExportCmd represents the storeExport command
+var storeExportCmd = &cobra.Command{
+	Use:   "export [file]",
+	Short: "Export the KV store to a sealed archive",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return NewCommandError(2, "too many arguments")
+		}
+		// init
+		st, err := store.NewStoreFromConfig(goconfig.Viper())
+		if err != nil {
+			return NewCommandErrorF(255, "unable to create store: %v", err)
+		}
+		box, err := coyote.NewSecretBox(&coyote.Config{
+			Store:            st,
+			SecretKey:        viper.GetString(SealKeyFlag),
+			SecretPassphrase: viper.GetString(SealPassphraseFlag),
+		})
+		if err != nil {
+			return NewCommandErrorF(255, "unable to create seal: %v", err)
+		}
+		// export
+		archive, err := coyote.ExportStore(st, box)
+		if err != nil {
+			return NewCommandErrorF(255, "unable to export store: %v", err)
+		}
+		if len(args) == 0 {
+			_, err = os.Stdout.Write(archive)
+		} else {
+			err = ioutil.WriteFile(args[0], archive, 0600)
+		}
+		if err != nil {
+			return NewCommandErrorF(255, "unable to write archive: %v", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	storeCmd.AddCommand(storeExportCmd)
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stugotech/coyote/coyote"
+	"github.com/stugotech/coyote/store"
+	"github.com/stugotech/goconfig"
+)
+
+// Flags
+const (
+	NewSealKeyFlag        = "new-seal-key"
+	NewSealPassphraseFlag = "new-seal-passphrase"
+	OverwriteFlag         = "overwrite"
+)
+
+// storeImportCmd represents the storeImport command
+var storeImportCmd = &cobra.Command{
+	Use:   "import [file]",
+	Short: "Import a sealed archive created with export into the KV store",
+	Long: `Import a sealed archive created with export into the KV store.
+
+Accounts and certificates which already exist are skipped unless --overwrite is given.  An
+archive with an older schema version than the store can only be imported into an empty store.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return NewCommandError(2, "must specify archive file")
+		}
+		fl := cmd.Flags()
+		newKey, _ := fl.GetString(NewSealKeyFlag)
+		newPassphrase, _ := fl.GetString(NewSealPassphraseFlag)
+		overwrite, _ := fl.GetBool(OverwriteFlag)
+
+		archive, err := ioutil.ReadFile(args[0])
+		if err != nil {
+			return NewCommandErrorF(255, "unable to read archive: %v", err)
+		}
+		// init
+		st, err := store.NewStoreFromConfig(goconfig.Viper())
+		if err != nil {
+			return NewCommandErrorF(255, "unable to create store: %v", err)
+		}
+		// the archive is opened with the key it was exported with; this mustn't create a salt in
+		// the store, so that the archive's salt is kept
+		box, err := coyote.NewArchiveBox(&coyote.Config{
+			SecretKey:        viper.GetString(SealKeyFlag),
+			SecretPassphrase: viper.GetString(SealPassphraseFlag),
+		})
+		if err != nil {
+			return NewCommandErrorF(255, "unable to create seal: %v", err)
+		}
+		options := &coyote.ImportOptions{Overwrite: overwrite}
+		if newKey != "" || newPassphrase != "" {
+			options.NewSecretBox, err = coyote.NewSecretBox(&coyote.Config{
+				Store:            st,
+				SecretKey:        newKey,
+				SecretPassphrase: newPassphrase,
+			})
+			if err != nil {
+				return NewCommandErrorF(255, "unable to create new seal: %v", err)
+			}
+		}
+		// import
+		result, err := coyote.ImportStore(st, archive, box, options)
+		if err != nil {
+			return NewCommandErrorF(255, "unable to import archive: %v", err)
+		}
+		for _, email := range result.SkippedAccounts {
+			fmt.Printf("skipped  account %s: account already exists\n", email)
+		}
+		for _, domain := range result.SkippedCertificates {
+			fmt.Printf("skipped  certificate %s: certificate already exists\n", domain)
+		}
+		fmt.Printf("imported %d accounts and %d certificates\n",
+			len(result.Accounts)-len(result.SkippedAccounts),
+			len(result.Certificates)-len(result.SkippedCertificates),
+		)
+		version, err := st.GetSchemaVersion()
+		if err != nil {
+			return NewCommandErrorF(255, "unable to read schema version: %v", err)
+		}
+		if version < store.SchemaVersion {
+			fmt.Printf("store has schema version %d, run \"coyote store migrate\" to upgrade it\n", version)
+		}
+		return nil
+	},
+}
+
+func init() {
+	storeCmd.AddCommand(storeImportCmd)
+	fl := storeImportCmd.Flags()
+	fl.String(NewSealKeyFlag, "", "re-seal secret values with this key")
+	fl.String(NewSealPassphraseFlag, "", "re-seal secret values with a key derived from this passphrase")
+	fl.Bool(OverwriteFlag, false, "replace accounts and certificates which already exist")
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stugotech/coyote/coyote"
+	"github.com/stugotech/coyote/store"
+	"github.com/stugotech/goconfig"
+)
+
+// Flags
+const (
+	DryRunFlag            = "dry-run"
+	BackupFlag            = "backup"
+	InsecurePlaintextFlag = "insecure-plaintext"
+)
+
+// storeMigrateCmd represents the storeMigrate command
+var storeMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Migrate the KV store to the current schema version",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fl := cmd.Flags()
+		dryRun, _ := fl.GetBool(DryRunFlag)
+		backupFile, _ := fl.GetString(BackupFlag)
+		plaintext, _ := fl.GetBool(InsecurePlaintextFlag)
+		// init
+		st, err := store.NewStoreFromConfig(goconfig.Viper())
+		if err != nil {
+			return NewCommandErrorF(255, "unable to create store: %v", err)
+		}
+		// back up first if requested
+		if backupFile != "" && !dryRun {
+			if err := backupStore(st, backupFile, plaintext); err != nil {
+				return NewCommandErrorF(255, "unable to back up store: %v", err)
+			}
+			fmt.Printf("store backed up to %s\n", backupFile)
+		}
+		// migrate
+		migrations, err := store.Migrate(st, dryRun)
+		for _, m := range migrations {
+			fmt.Printf("version %d: %s\n", m.Version, m.Description)
+		}
+		if err != nil {
+			return NewCommandErrorF(255, "unable to migrate store: %v", err)
+		}
+		if len(migrations) == 0 {
+			fmt.Println("store is up to date")
+		} else if dryRun {
+			fmt.Printf("%d migrations pending\n", len(migrations))
+		} else {
+			fmt.Printf("store migrated to version %d\n", store.SchemaVersion)
+		}
+		return nil
+	},
+}
+
+func init() {
+	storeCmd.AddCommand(storeMigrateCmd)
+	fl := storeMigrateCmd.Flags()
+	fl.Bool(DryRunFlag, false, "list the pending migrations without applying them")
+	fl.String(BackupFlag, "", "file to back up the store to before migrating, as an archive sealed like store export")
+	fl.Bool(InsecurePlaintextFlag, false, "write the backup as plain JSON, including private keys")
+}
+
+// backupStore writes a backup of the store to the given file, sealed with the configured seal key or
+// passphrase unless plaintext is set.  A sealed backup can be restored with store import.
+func backupStore(st store.Store, file string, plaintext bool) error {
+	var archive []byte
+	if !plaintext {
+		box, err := coyote.NewSecretBox(&coyote.Config{
+			Store:            st,
+			SecretKey:        viper.GetString(SealKeyFlag),
+			SecretPassphrase: viper.GetString(SealPassphraseFlag),
+		})
+		if err != nil {
+			return fmt.Errorf("unable to create seal, set a seal key or passphrase or use --%s: %v", InsecurePlaintextFlag, err)
+		}
+		archive, err = coyote.ExportStore(st, box)
+		if err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if plaintext {
+		err = store.WriteBackup(st, f)
+	} else {
+		_, err = f.Write(archive)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync [command]",
+	Short: "Keep external systems in step with the certificates in the KV store",
+}
+
+func init() {
+	RootCmd.AddCommand(syncCmd)
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/spf13/cobra"
+	"github.com/stugotech/coyote/sync"
+)
+
+// syncApplyCmd represents the syncApply command
+var syncApplyCmd = &cobra.Command{
+	Use:   "apply [plan file]",
+	Short: "Carry out a sync plan",
+	Long: `Puts the certificates shown by "coyote sync plan" into each sync target.  If a plan file
+saved by "coyote sync plan --out" is given, exactly those changes are made, and any host
+whose certificate has changed since the plan was made is skipped; otherwise a new plan is
+made and shown before it is carried out.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		targets, err := syncTargetsFromConfig()
+		if err != nil {
+			return err
+		}
+		if len(targets) == 0 {
+			return NewUserError("must specify a sync target")
+		}
+		certs, err := syncCertificatesFromConfig()
+		if err != nil {
+			return err
+		}
+
+		var plans []*sync.Plan
+		var planErr error
+		if len(args) > 0 {
+			data, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				return NewUserErrorF("unable to read plan: %v", err)
+			}
+			if err := json.Unmarshal(data, &plans); err != nil {
+				return NewUserErrorF("invalid plan: %v", err)
+			}
+		} else {
+			// targets which can't be planned are reported after the others are applied
+			plans, planErr = planSync(certs, targets)
+			if err := writePlans(cmd, plans); err != nil {
+				return err
+			}
+		}
+
+		byName := make(map[string]*sync.Target)
+		for _, target := range targets {
+			byName[target.Name] = target
+		}
+		// check every target before changing any, so that a plan for the wrong config does nothing
+		for _, plan := range plans {
+			if _, ok := byName[plan.Target]; !ok {
+				return NewUserErrorF("plan is for sync target %q, which isn't configured", plan.Target)
+			}
+		}
+
+		var failed []string
+		for _, plan := range plans {
+			results, err := sync.ApplyPlan(plan, certs, byName[plan.Target])
+			for _, result := range results {
+				if result.Err != nil {
+					fmt.Printf("failed %s %s: %v\n", plan.Target, result.Change.Domain, result.Err)
+				} else {
+					fmt.Printf("put    %s %s%s\n", plan.Target, result.Change.Domain, unverifiedNote(result.Verified))
+				}
+			}
+			if err != nil {
+				failed = append(failed, plan.Target)
+			}
+		}
+		if len(failed) > 0 {
+			return NewCommandErrorF(255, "unable to apply plan to sync targets: %v", failed)
+		}
+		return planErr
+	},
+}
+
+func init() {
+	syncCmd.AddCommand(syncApplyCmd)
+	addOutputFlag(syncApplyCmd)
+}
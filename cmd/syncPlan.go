@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stugotech/coyote/export"
+	"github.com/stugotech/coyote/store"
+	"github.com/stugotech/coyote/sync"
+)
+
+// shortThumbprintLength is how much of each thumbprint is shown in the plan
+const shortThumbprintLength = 12
+
+// syncPlanCmd represents the syncPlan command
+var syncPlanCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show what a sync would change in each sync target",
+	Long: `Compares every host in each sync target with the certificates in the KV store and
+shows which hosts are missing, stale, expired or unknown to coyote.  The plan can be
+saved with --out and then carried out with "coyote sync apply <file>".  Webhook targets
+can't be listed, so they need a get-url and only the names in the store are compared.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out, _ := cmd.Flags().GetString(OutFlag)
+
+		targets, err := syncTargetsFromConfig()
+		if err != nil {
+			return err
+		}
+		if len(targets) == 0 {
+			return NewUserError("must specify a sync target")
+		}
+		certs, err := syncCertificatesFromConfig()
+		if err != nil {
+			return err
+		}
+
+		plans, planErr := planSync(certs, targets)
+		if out != "" {
+			data, err := json.MarshalIndent(plans, "", "  ")
+			if err != nil {
+				return NewCommandErrorF(255, "unable to encode plan: %v", err)
+			}
+			if err := export.WriteFile(out, data, export.DefaultFileMode); err != nil {
+				return NewCommandErrorF(255, "unable to write plan: %v", err)
+			}
+		}
+		if err := writePlans(cmd, plans); err != nil {
+			return err
+		}
+		return planErr
+	},
+}
+
+func init() {
+	syncCmd.AddCommand(syncPlanCmd)
+	addOutputFlag(syncPlanCmd)
+	syncPlanCmd.Flags().String(OutFlag, "", "File to save the plan to, for sync apply")
+}
+
+// syncCertificatesFromConfig gets the certificates in the store
+func syncCertificatesFromConfig() ([]*store.Certificate, error) {
+	coy, err := createCoyoteFromConfig()
+	if err != nil {
+		return nil, NewCommandErrorF(255, "unable to create coyote: %v", err)
+	}
+	certs, err := coy.GetCertificates()
+	if err != nil {
+		return nil, NewCommandErrorF(255, "unable to get certificates: %v", err)
+	}
+	return certs, nil
+}
+
+// planSync plans every target; a target which can't be planned is left out and reported in the
+// error so that the others are still shown
+func planSync(certs []*store.Certificate, targets []*sync.Target) ([]*sync.Plan, error) {
+	plans := make([]*sync.Plan, 0, len(targets))
+	var failed []string
+	now := time.Now()
+
+	for _, target := range targets {
+		plan, err := sync.NewPlan(certs, target, now)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s (%v)", target.Name, err))
+			continue
+		}
+		plans = append(plans, plan)
+	}
+	if len(failed) > 0 {
+		return plans, NewCommandErrorF(255, "unable to plan sync targets: %v", failed)
+	}
+	return plans, nil
+}
+
+// writePlans writes the plans in the chosen output format; the table format is a diff of each
+// target against the store
+func writePlans(cmd *cobra.Command, plans []*sync.Plan) error {
+	return writeOutput(cmd, plans, func(w io.Writer) {
+		for _, plan := range plans {
+			puts := 0
+			fmt.Fprintf(w, "%s:\n", plan.Target)
+			for _, change := range plan.Changes {
+				if change.Action == sync.ActionPut {
+					puts++
+				}
+				fmt.Fprintf(w, "  %s %s\t%s\t%s\n", changeSymbol(change), change.Domain, change.Type, changeDetail(change))
+			}
+			if len(plan.Changes) == 0 {
+				fmt.Fprintln(w, "  no changes")
+			} else {
+				fmt.Fprintf(w, "  %d to put, %d left alone\n", puts, len(plan.Changes)-puts)
+			}
+		}
+	})
+}
+
+// changeSymbol marks each line of the diff with the kind of change
+func changeSymbol(change *sync.Change) string {
+	switch change.Type {
+	case sync.HostMissing:
+		return "+"
+	case sync.HostStale:
+		return "~"
+	case sync.HostExpired:
+		return "!"
+	default:
+		return "?"
+	}
+}
+
+// changeDetail describes the certificates before and after a change
+func changeDetail(change *sync.Change) string {
+	current := shortThumbprint(change.ExternalThumbprint)
+	if change.ExternalExpires != nil && change.Type == sync.HostExpired {
+		current += fmt.Sprintf(" (expired %s)", change.ExternalExpires.Format("2006-01-02"))
+	}
+
+	switch {
+	case change.Type == sync.HostUnknown:
+		return current + " not managed by coyote, left alone"
+	case change.Action != sync.ActionPut:
+		return current + " nothing newer in store"
+	case change.Type == sync.HostMissing:
+		return "-> " + shortThumbprint(change.Thumbprint)
+	default:
+		return current + " -> " + shortThumbprint(change.Thumbprint)
+	}
+}
+
+func shortThumbprint(thumbprint string) string {
+	if thumbprint == "" {
+		return "(none)"
+	}
+	if len(thumbprint) > shortThumbprintLength {
+		return thumbprint[:shortThumbprintLength]
+	}
+	return thumbprint
+}
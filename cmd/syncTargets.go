@@ -0,0 +1,311 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+	"github.com/stugotech/coyote/store"
+	"github.com/stugotech/coyote/sync"
+	"github.com/stugotech/coyote/sync/caddy"
+	"github.com/stugotech/coyote/sync/directory"
+	"github.com/stugotech/coyote/sync/haproxy"
+	"github.com/stugotech/coyote/sync/kubernetes"
+	"github.com/stugotech/coyote/sync/traefik"
+	"github.com/stugotech/coyote/sync/vulcand"
+	"github.com/stugotech/coyote/sync/webhook"
+)
+
+// Config keys which can only be set in the config file
+const (
+	// SyncTargetsKey is a list of sync targets, each with a type, an optional name and the options
+	// of that type, e.g.
+	//
+	//   sync-targets:
+	//     - name: edge
+	//       type: haproxy
+	//       dir: /etc/haproxy/certs
+	//       crt-list: /etc/haproxy/crt-list.txt
+	SyncTargetsKey = "sync-targets"
+)
+
+// Options of each sync target in the config file, besides those of its type
+const (
+	syncTargetNameOption = "name"
+	syncTargetTypeOption = "type"
+)
+
+// optionsDecoder reads the options of a sync target into a config struct
+type optionsDecoder func(config interface{}) error
+
+// syncTargetType creates sync clients of one type
+type syncTargetType struct {
+	// flag configures a target of this type from the command line when it is set
+	flag string
+	// options maps the names of the target's options to the flags which set them
+	options map[string]string
+	// create makes a client from the target's options
+	create func(decode optionsDecoder) (sync.Client, error)
+}
+
+// syncTargetTypes is the registry of sync target types, by the name used in the config file
+var syncTargetTypes = map[string]*syncTargetType{
+	"vulcand": {
+		flag:    VulcandKey,
+		options: map[string]string{"endpoint": VulcandKey},
+		create: func(decode optionsDecoder) (sync.Client, error) {
+			var config struct {
+				Endpoint string `mapstructure:"endpoint"`
+			}
+			if err := decode(&config); err != nil {
+				return nil, err
+			}
+			if config.Endpoint == "" {
+				return nil, fmt.Errorf("endpoint is required")
+			}
+			return vulcand.NewClient(config.Endpoint), nil
+		},
+	},
+	"directory": {
+		flag: SyncDirKey,
+		options: map[string]string{
+			"dir":            SyncDirKey,
+			"cert-path":      SyncDirCertPathKey,
+			"key-path":       SyncDirKeyPathKey,
+			"reload-command": SyncReloadCommandKey,
+		},
+		create: func(decode optionsDecoder) (sync.Client, error) {
+			var config directory.Config
+			if err := decode(&config); err != nil {
+				return nil, err
+			}
+			if config.Dir == "" {
+				return nil, fmt.Errorf("dir is required")
+			}
+			return directory.NewClient(&config)
+		},
+	},
+	"haproxy": {
+		flag: HAProxyDirKey,
+		options: map[string]string{
+			"dir":      HAProxyDirKey,
+			"crt-list": HAProxyCrtListKey,
+			"socket":   HAProxySocketKey,
+		},
+		create: func(decode optionsDecoder) (sync.Client, error) {
+			var config haproxy.Config
+			if err := decode(&config); err != nil {
+				return nil, err
+			}
+			if config.Dir == "" || config.CrtList == "" {
+				return nil, fmt.Errorf("dir and crt-list are required")
+			}
+			return haproxy.NewClient(&config), nil
+		},
+	},
+	"traefik": {
+		flag: TraefikDirKey,
+		options: map[string]string{
+			"dir":         TraefikDirKey,
+			"config-file": TraefikConfigKey,
+		},
+		create: func(decode optionsDecoder) (sync.Client, error) {
+			var config traefik.Config
+			if err := decode(&config); err != nil {
+				return nil, err
+			}
+			if config.Dir == "" || config.ConfigFile == "" {
+				return nil, fmt.Errorf("dir and config-file are required")
+			}
+			return traefik.NewClient(&config), nil
+		},
+	},
+	"caddy": {
+		flag:    CaddyAdminKey,
+		options: map[string]string{"admin": CaddyAdminKey},
+		create: func(decode optionsDecoder) (sync.Client, error) {
+			var config struct {
+				Admin string `mapstructure:"admin"`
+			}
+			if err := decode(&config); err != nil {
+				return nil, err
+			}
+			return caddy.NewClient(config.Admin), nil
+		},
+	},
+	"kubernetes": {
+		flag: KubeNamespacesKey,
+		options: map[string]string{
+			"namespaces":  KubeNamespacesKey,
+			"kubeconfig":  KubeconfigKey,
+			"name-prefix": KubeSecretPrefixKey,
+			"cleanup":     KubeCleanupKey,
+		},
+		create: func(decode optionsDecoder) (sync.Client, error) {
+			var config struct {
+				Kubeconfig        string `mapstructure:"kubeconfig"`
+				kubernetes.Config `mapstructure:",squash"`
+			}
+			if err := decode(&config); err != nil {
+				return nil, err
+			}
+			if len(config.Namespaces) == 0 {
+				return nil, fmt.Errorf("namespaces are required")
+			}
+			return kubernetes.NewClientFromKubeconfig(config.Kubeconfig, &config.Config)
+		},
+	},
+	"webhook": {
+		flag: WebhookURLsKey,
+		options: map[string]string{
+			"urls":          WebhookURLsKey,
+			"secret":        WebhookSecretKey,
+			"template-file": WebhookTemplateKey,
+			"get-url":       WebhookGetURLKey,
+			"attempts":      WebhookAttemptsKey,
+		},
+		create: func(decode optionsDecoder) (sync.Client, error) {
+			var config struct {
+				// TemplateFile is read into the template, if given
+				TemplateFile   string `mapstructure:"template-file"`
+				webhook.Config `mapstructure:",squash"`
+			}
+			if err := decode(&config); err != nil {
+				return nil, err
+			}
+			if config.TemplateFile != "" {
+				data, err := ioutil.ReadFile(config.TemplateFile)
+				if err != nil {
+					return nil, fmt.Errorf("unable to read template: %v", err)
+				}
+				config.Template = string(data)
+			}
+			return webhook.NewClient(&config.Config)
+		},
+	},
+}
+
+// certificateSync pushes certificates to every configured sync target and prints the outcome for
+// each host that was put or failed
+func certificateSync(certs []*store.Certificate) error {
+	targets, err := syncTargetsFromConfig()
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 || len(certs) == 0 {
+		return nil
+	}
+	results, err := sync.CertificatesToTargets(certs, targets)
+	for _, result := range results {
+		changed, failed := 0, 0
+		for _, host := range result.Hosts {
+			switch {
+			case host.Err != nil:
+				failed++
+				fmt.Printf("failed %s %s: %v\n", result.Target, host.Domain, host.Err)
+			case host.Changed:
+				changed++
+				fmt.Printf("put    %s %s%s\n", result.Target, host.Domain, unverifiedNote(host.Verified))
+			}
+		}
+		if result.Err != nil && failed == 0 {
+			// e.g. the client couldn't be committed
+			fmt.Printf("failed %s: %v\n", result.Target, result.Err)
+		}
+		fmt.Printf("synced %s: %d hosts, %d changed, %d failed\n", result.Target, len(result.Hosts), changed, failed)
+	}
+	return err
+}
+
+// unverifiedNote marks hosts which couldn't be read back after they were put
+func unverifiedNote(verified bool) string {
+	if verified {
+		return ""
+	}
+	return " (not verified)"
+}
+
+// syncTargetsFromConfig creates the sync targets listed in the config file, followed by those
+// configured by flags, which are named after their type
+func syncTargetsFromConfig() ([]*sync.Target, error) {
+	var targets []*sync.Target
+	names := make(map[string]bool)
+
+	add := func(name string, typeName string, options map[string]interface{}) error {
+		if names[name] {
+			return NewUserErrorF("there is more than one sync target named %q", name)
+		}
+		targetType, ok := syncTargetTypes[typeName]
+		if !ok {
+			return NewUserErrorF("sync target %q has unknown type %q", name, typeName)
+		}
+		client, err := targetType.create(func(config interface{}) error {
+			return decodeSyncTargetOptions(options, config)
+		})
+		if err != nil {
+			return NewUserErrorF("invalid settings for sync target %q: %v", name, err)
+		}
+		names[name] = true
+		targets = append(targets, &sync.Target{Name: name, Client: client})
+		return nil
+	}
+
+	var listed []map[string]interface{}
+	if err := viper.UnmarshalKey(SyncTargetsKey, &listed); err != nil {
+		return nil, NewUserErrorF("invalid %s: %v", SyncTargetsKey, err)
+	}
+	for i, options := range listed {
+		typeName, _ := options[syncTargetTypeOption].(string)
+		if typeName == "" {
+			return nil, NewUserErrorF("%s entry %d has no %s", SyncTargetsKey, i+1, syncTargetTypeOption)
+		}
+		name, _ := options[syncTargetNameOption].(string)
+		if name == "" {
+			name = typeName
+		}
+		delete(options, syncTargetTypeOption)
+		delete(options, syncTargetNameOption)
+		if err := add(name, typeName, options); err != nil {
+			return nil, err
+		}
+	}
+
+	// flags are checked in a fixed order so that targets are always synced in the same order
+	typeNames := make([]string, 0, len(syncTargetTypes))
+	for typeName := range syncTargetTypes {
+		typeNames = append(typeNames, typeName)
+	}
+	sort.Strings(typeNames)
+
+	for _, typeName := range typeNames {
+		targetType := syncTargetTypes[typeName]
+		if len(viper.GetStringSlice(targetType.flag)) == 0 {
+			continue
+		}
+		options := make(map[string]interface{})
+		for option, flag := range targetType.options {
+			options[option] = viper.Get(flag)
+		}
+		if err := add(typeName, typeName, options); err != nil {
+			return nil, err
+		}
+	}
+	return targets, nil
+}
+
+// decodeSyncTargetOptions reads options into a config struct, rejecting options the type doesn't
+// have so that typos aren't silently ignored
+func decodeSyncTargetOptions(options map[string]interface{}, config interface{}) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+		ErrorUnused:      true,
+		WeaklyTypedInput: true,
+		Result:           config,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(options)
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/spf13/cobra"
+	"github.com/stugotech/coyote/store"
+	"github.com/stugotech/coyote/sync"
+	"github.com/stugotech/goconfig"
+)
+
+// syncWatchCmd represents the syncWatch command
+var syncWatchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Watch the KV store and sync certificates as they change",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		targets, err := syncTargetsFromConfig()
+		if err != nil {
+			return err
+		}
+		if len(targets) == 0 {
+			return NewUserError("must specify a sync target")
+		}
+		// init
+		st, err := store.NewStoreFromConfig(goconfig.Viper())
+		if err != nil {
+			return NewCommandErrorF(255, "unable to create store: %v", err)
+		}
+		// sync until the watch fails
+		err = sync.Watch(context.Background(), st, targets)
+		if err != nil {
+			return NewCommandErrorF(255, "error while watching certificates: %v", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	syncCmd.AddCommand(syncWatchCmd)
+}
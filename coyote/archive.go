@@ -0,0 +1,175 @@
+package coyote
+
+import (
+	"encoding/json"
+
+	"github.com/stugotech/coyote/secret"
+	"github.com/stugotech/coyote/store"
+	"github.com/stugotech/golog"
+)
+
+// ImportOptions describes how an archive is imported into a store
+type ImportOptions struct {
+	// Overwrite replaces accounts and certificates which already exist in the store.
+	Overwrite bool
+	// NewSecretBox re-seals secret values with a different key, if set.
+	NewSecretBox secret.Box
+}
+
+// ImportResult is the outcome of importing an archive
+type ImportResult struct {
+	*store.Backup
+	// SkippedAccounts are the emails of the accounts which already existed and weren't overwritten
+	SkippedAccounts []string
+	// SkippedCertificates are the domains of the certificates which already existed and weren't
+	// overwritten
+	SkippedCertificates []string
+}
+
+// ExportStore creates an archive of the accounts, certificates and metadata in the store, sealed
+// with the given box.
+func ExportStore(st store.Store, box secret.Box) ([]byte, error) {
+	backup, err := store.NewBackup(st)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	bytes, err := json.Marshal(backup)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	archive, err := box.Seal(bytes)
+	if err != nil {
+		return nil, logger.Errorex("unable to seal archive", err)
+	}
+	return archive, nil
+}
+
+// ImportStore restores an archive created by ExportStore into the store.  The archive is opened
+// with the box that it was sealed with.  Accounts and certificates which already exist are skipped
+// unless options.Overwrite is set.  An archive with an older schema than the store can only be
+// imported into an empty store, which is given the archive's schema version so that the migrations
+// since then are applied.
+func ImportStore(st store.Store, archive []byte, box secret.Box, options *ImportOptions) (*ImportResult, error) {
+	bytes, err := box.Open(archive)
+	if err != nil {
+		return nil, logger.Errorex("unable to open archive", err)
+	}
+	var backup store.Backup
+	if err := json.Unmarshal(bytes, &backup); err != nil {
+		return nil, logger.Errorex("unable to decode archive", err)
+	}
+	if backup.SchemaVersion > store.SchemaVersion {
+		return nil, logger.Error("archive was created by a newer version of coyote", golog.Int("version", backup.SchemaVersion))
+	}
+
+	version, err := st.GetSchemaVersion()
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	empty, err := isStoreEmpty(st)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	if backup.SchemaVersion < version && !empty {
+		// the imported records would be written at the store's version without being upgraded
+		return nil, logger.Error("archive has an older schema version than the store, import it into an empty store",
+			golog.Int("version", backup.SchemaVersion),
+			golog.Int("storeVersion", version),
+		)
+	}
+	result := &ImportResult{Backup: &backup}
+
+	// keep the salt so passphrase-derived keys still match, unless re-sealing under a new key
+	if options.NewSecretBox == nil && len(backup.SealSalt) > 0 {
+		salt, err := st.GetSealSalt()
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+		if salt == nil {
+			if err := st.PutSealSalt(backup.SealSalt); err != nil {
+				return nil, logger.Errore(err)
+			}
+		}
+	}
+
+	for _, account := range backup.Accounts {
+		if options.NewSecretBox != nil {
+			account.Key, err = reseal(account.Key, box, options.NewSecretBox)
+			if err != nil {
+				return nil, logger.Errorex("unable to re-seal account key", err, golog.String("email", account.Email))
+			}
+		}
+		account.LastIndex = 0
+		if options.Overwrite {
+			existing, err := st.GetAccount(account.Directory, account.Email)
+			if err != nil {
+				return nil, logger.Errore(err)
+			}
+			if existing != nil {
+				account.LastIndex = existing.LastIndex
+			}
+		}
+		err := st.PutAccount(account)
+		if store.IsConflict(err) && !options.Overwrite {
+			logger.Info("skipping account which already exists", golog.String("email", account.Email))
+			result.SkippedAccounts = append(result.SkippedAccounts, account.Email)
+			continue
+		}
+		if err != nil {
+			return nil, logger.Errorex("unable to import account", err, golog.String("email", account.Email))
+		}
+	}
+
+	for _, cert := range backup.Certificates {
+		cert.LastIndex = 0
+		if options.Overwrite {
+			existing, err := st.GetCertificate(cert.Domain)
+			if err != nil {
+				return nil, logger.Errore(err)
+			}
+			if existing != nil {
+				cert.LastIndex = existing.LastIndex
+			}
+		}
+		err := st.PutCertificate(cert)
+		if store.IsConflict(err) && !options.Overwrite {
+			logger.Info("skipping certificate which already exists", golog.String("domain", cert.Domain))
+			result.SkippedCertificates = append(result.SkippedCertificates, cert.Domain)
+			continue
+		}
+		if err != nil {
+			return nil, logger.Errorex("unable to import certificate", err, golog.String("domain", cert.Domain))
+		}
+	}
+
+	// the imported records are only as up to date as the archive, so leave migrations since then
+	// to be applied; a store which already had records keeps its own version
+	if empty && backup.SchemaVersion != version {
+		if err := st.PutSchemaVersion(backup.SchemaVersion); err != nil {
+			return nil, logger.Errore(err)
+		}
+	}
+	return result, nil
+}
+
+// isStoreEmpty returns true if the store has no accounts or certificates
+func isStoreEmpty(st store.Store) (bool, error) {
+	accounts, err := st.GetAccounts()
+	if err != nil {
+		return false, err
+	}
+	certs, err := st.GetCertificates()
+	if err != nil {
+		return false, err
+	}
+	return len(accounts) == 0 && len(certs) == 0, nil
+}
+
+// reseal opens a value with one box and seals it with another
+func reseal(value []byte, from secret.Box, to secret.Box) ([]byte, error) {
+	bytes, err := from.Open(value)
+	if err != nil {
+		return nil, err
+	}
+	return to.Seal(bytes)
+}
@@ -0,0 +1,142 @@
+package coyote
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stugotech/coyote/secret"
+	coyotestore "github.com/stugotech/coyote/store"
+	"github.com/stugotech/coyote/store/memkv"
+)
+
+func TestImportStoreSkipsExistingRecords(t *testing.T) {
+	box, err := secret.NewBoxFromKeyString(strings.Repeat("ab", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := newTestStore(t)
+	putTestRecords(t, source, "archived", "a.example.com", "b.example.com")
+	if err := source.PutSchemaVersion(coyotestore.SchemaVersion); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := ExportStore(source, box)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := newTestStore(t)
+	putTestRecords(t, st, "existing", "a.example.com")
+	result, err := ImportStore(st, archive, box, &ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.SkippedCertificates) != 1 || result.SkippedCertificates[0] != "a.example.com" {
+		t.Errorf("got skipped certificates %v, want the existing one", result.SkippedCertificates)
+	}
+	if len(result.SkippedAccounts) != 1 {
+		t.Errorf("got skipped accounts %v, want the existing one", result.SkippedAccounts)
+	}
+	for domain, want := range map[string]string{"a.example.com": "existing", "b.example.com": "archived"} {
+		cert, err := st.GetCertificate(domain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cert == nil || cert.Thumbprint != want {
+			t.Errorf("%s: got certificate %+v, want the %s one", domain, cert, want)
+		}
+	}
+	// the store had records before the import, so keeps its version
+	if version, err := st.GetSchemaVersion(); err != nil || version != 0 {
+		t.Errorf("got schema version %d and error %v, want the store's version", version, err)
+	}
+
+	result, err = ImportStore(st, archive, box, &ImportOptions{Overwrite: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.SkippedCertificates) != 0 || len(result.SkippedAccounts) != 0 {
+		t.Errorf("got skipped certificates %v and accounts %v, want none", result.SkippedCertificates, result.SkippedAccounts)
+	}
+	cert, err := st.GetCertificate("a.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Thumbprint != "archived" {
+		t.Errorf("got certificate %q, want the archived one", cert.Thumbprint)
+	}
+}
+
+func TestImportStoreSchemaVersion(t *testing.T) {
+	box, err := secret.NewBoxFromKeyString(strings.Repeat("ab", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := newTestStore(t)
+	putTestRecords(t, source, "archived", "a.example.com")
+	if err := source.PutSchemaVersion(1); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := ExportStore(source, box)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an older archive can't be mixed with the records of a newer store
+	st := newTestStore(t)
+	putTestRecords(t, st, "existing", "b.example.com")
+	if err := st.PutSchemaVersion(coyotestore.SchemaVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportStore(st, archive, box, &ImportOptions{}); err == nil {
+		t.Error("expected error importing an older archive into a newer store")
+	}
+	if cert, err := st.GetCertificate("a.example.com"); err != nil || cert != nil {
+		t.Errorf("got certificate %+v and error %v, want nothing imported", cert, err)
+	}
+
+	// an empty store takes the archive's version, so that the migrations since then are applied
+	st = newTestStore(t)
+	if err := st.PutSchemaVersion(coyotestore.SchemaVersion); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ImportStore(st, archive, box, &ImportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if version, err := st.GetSchemaVersion(); err != nil || version != 1 {
+		t.Errorf("got schema version %d and error %v, want the archive's version", version, err)
+	}
+}
+
+// newTestStore creates a store backed by an in-memory libkv store
+func newTestStore(t *testing.T) coyotestore.Store {
+	st, err := coyotestore.NewLibKVStore(memkv.New(), "coyote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st
+}
+
+// putTestRecords puts an account and certificates for the domains, using the thumbprint to tell
+// them apart
+func putTestRecords(t *testing.T, st coyotestore.Store, thumbprint string, domains ...string) {
+	account := &coyotestore.Account{
+		URI:       "https://acme.example.com/acct/1",
+		Directory: "https://acme.example.com/directory",
+		Email:     "admin@example.com",
+		Key:       []byte(thumbprint),
+	}
+	if err := st.PutAccount(account); err != nil {
+		t.Fatal(err)
+	}
+	for _, domain := range domains {
+		cert := &coyotestore.Certificate{
+			Domain:           domain,
+			Thumbprint:       thumbprint,
+			PrivateKey:       []byte("key"),
+			CertificateChain: []byte("chain"),
+		}
+		if err := st.PutCertificate(cert); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
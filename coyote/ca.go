@@ -0,0 +1,269 @@
+package coyote
+
+import (
+	"context"
+	"io/ioutil"
+
+	"github.com/stugotech/coyote/acmelib"
+	"github.com/stugotech/coyote/cryptutil"
+	"github.com/stugotech/coyote/store"
+	"github.com/stugotech/golog"
+)
+
+// DefaultCAName is the name of the CA profile described by the DirectoyURI, ContactEmail and
+// AcceptTOS settings.
+const DefaultCAName = "default"
+
+// CAProfile describes an ACME directory and the account used with it
+type CAProfile struct {
+	Name         string `mapstructure:"name"`
+	DirectoryURI string `mapstructure:"directory"`
+	ContactEmail string `mapstructure:"email"`
+	AcceptTOS    bool   `mapstructure:"accept-tos"`
+	// TLSRootsFile is a PEM file of root certificates to trust for the directory, if it doesn't
+	// have a publicly trusted certificate (e.g. a local test CA)
+	TLSRootsFile string `mapstructure:"tls-roots"`
+	// PreferredChain is the common name of the root or issuer of the chain to use if the CA offers
+	// alternate chains
+	PreferredChain string `mapstructure:"preferred-chain"`
+}
+
+// caClient is an ACME client using the account for a CA profile
+type caClient struct {
+	profile *CAProfile
+	client  acmelib.Client
+}
+
+// defaultProfile gets the CA profile described by the top-level settings
+func (c *coyote) defaultProfile() *CAProfile {
+	return &CAProfile{
+		Name:           DefaultCAName,
+		DirectoryURI:   c.config.DirectoyURI,
+		ContactEmail:   c.config.ContactEmail,
+		AcceptTOS:      c.config.AcceptTOS,
+		PreferredChain: c.config.PreferredChain,
+	}
+}
+
+// caProfile gets the CA profile with the given name, or the default profile if name is empty
+func (c *coyote) caProfile(name string) (*CAProfile, error) {
+	if name == "" || name == DefaultCAName {
+		return c.defaultProfile(), nil
+	}
+	for _, profile := range c.config.CAs {
+		if profile.Name == name {
+			return profile, nil
+		}
+	}
+	return nil, logger.Error("unknown CA profile", golog.String("ca", name))
+}
+
+// certificateProfile gets the CA profile that a certificate was issued with
+func (c *coyote) certificateProfile(cert *store.Certificate) *CAProfile {
+	if cert.DirectoryURI == "" {
+		// issued before certificates recorded their CA
+		return c.defaultProfile()
+	}
+	profiles := append([]*CAProfile{c.defaultProfile()}, c.config.CAs...)
+	for _, profile := range profiles {
+		if profile.DirectoryURI == cert.DirectoryURI && profile.ContactEmail == cert.AccountEmail {
+			return profile
+		}
+	}
+	for _, profile := range profiles {
+		if profile.Name == cert.CA {
+			return profile
+		}
+	}
+	logger.Info("CA which issued certificate is no longer configured, using default",
+		golog.String("domain", cert.Domain),
+		golog.String("directory", cert.DirectoryURI),
+	)
+	return c.defaultProfile()
+}
+
+// primaryProfile gets the CA profile that a certificate was originally requested from
+func (c *coyote) primaryProfile(cert *store.Certificate) *CAProfile {
+	if cert.PrimaryCA != "" {
+		profile, err := c.caProfile(cert.PrimaryCA)
+		if err == nil {
+			return profile
+		}
+		logger.Errorex("CA which certificate was requested from is no longer configured", err,
+			golog.String("domain", cert.Domain),
+		)
+	}
+	return c.certificateProfile(cert)
+}
+
+// getClient gets a client for the CA profile, creating an account with the CA if required
+func (c *coyote) getClient(profile *CAProfile) (*caClient, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if ca, ok := c.clients[profile.Name]; ok {
+		return ca, nil
+	}
+
+	client, err := newACMEClient(profile)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	ca := &caClient{
+		profile: profile,
+		client:  client,
+	}
+
+	account, err := c.getAccount(profile)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+
+	if account != nil {
+		used, err := client.UseAccount(context.Background(), account)
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+		c.updateAccountURI(profile, used.URI)
+	} else {
+		// no account found - create new account
+		_, err = c.createAccount(ca)
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+	}
+
+	c.clients[profile.Name] = ca
+	return ca, nil
+}
+
+// newACMEClient creates an ACME client for the CA profile
+func newACMEClient(profile *CAProfile) (acmelib.Client, error) {
+	if profile.TLSRootsFile == "" {
+		return acmelib.NewClient(profile.DirectoryURI)
+	}
+	roots, err := ioutil.ReadFile(profile.TLSRootsFile)
+	if err != nil {
+		return nil, logger.Errorex("unable to read TLS roots", err, golog.String("file", profile.TLSRootsFile))
+	}
+	return acmelib.NewClientWithRoots(profile.DirectoryURI, roots)
+}
+
+// getAccount looks up the account and returns the key if it exists
+func (c *coyote) getAccount(profile *CAProfile) (*acmelib.Account, error) {
+	account, err := c.config.Store.GetAccount(profile.DirectoryURI, profile.ContactEmail)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	if account == nil {
+		account, err = c.getLegacyDirectoryAccount(profile)
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+	}
+	if account == nil {
+		return nil, nil
+	}
+	if account.LastIndex == 0 {
+		// account was stored by an older version without its directory, or under the ACME v1
+		// directory, so store it under its directory
+		logger.Info("storing account under its directory", golog.String("email", account.Email))
+		if err := c.config.Store.PutAccount(account); err != nil && !store.IsConflict(err) {
+			return nil, logger.Errore(err)
+		}
+	}
+	key, err := c.secretBox.Open(account.Key)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	signer, err := cryptutil.ParsePrivateKeyFromDER(key)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+
+	return &acmelib.Account{
+		URI:   account.URI,
+		Key:   signer,
+		Email: account.Email,
+	}, nil
+}
+
+// getLegacyDirectoryAccount gets the account registered on the ACME v1 directory which the profile's
+// directory replaced, so that it is carried over rather than a new account being registered.  The
+// account is returned as a new account in the profile's directory.
+func (c *coyote) getLegacyDirectoryAccount(profile *CAProfile) (*store.Account, error) {
+	legacy := acmelib.LegacyDirectory(profile.DirectoryURI)
+	if legacy == "" {
+		return nil, nil
+	}
+	account, err := c.config.Store.GetAccount(legacy, profile.ContactEmail)
+	if err != nil || account == nil {
+		return nil, err
+	}
+	logger.Info("using account from ACME v1 directory",
+		golog.String("email", account.Email),
+		golog.String("directory", legacy),
+	)
+	account.Directory = profile.DirectoryURI
+	if account.LegacyIndex == 0 {
+		// the record under the v1 directory is left alone
+		account.LastIndex = 0
+	}
+	return account, nil
+}
+
+// updateAccountURI saves the URI which the CA gave for an account, if it has changed, e.g. because
+// the account was registered on an ACME v1 directory
+func (c *coyote) updateAccountURI(profile *CAProfile, uri string) {
+	account, err := c.config.Store.GetAccount(profile.DirectoryURI, profile.ContactEmail)
+	if err != nil || account == nil || account.URI == uri || uri == "" {
+		return
+	}
+	account.URI = uri
+	if err := c.config.Store.PutAccount(account); err != nil && !store.IsConflict(err) {
+		logger.Errorex("unable to update account URI", err, golog.String("email", account.Email))
+	}
+}
+
+// createAccount creates a new account
+func (c *coyote) createAccount(ca *caClient) (*acmelib.Account, error) {
+	email := ca.profile.ContactEmail
+	account, err := ca.client.RegisterAccount(context.Background(), email, ca.profile.AcceptTOS)
+	if err != nil {
+		return nil, logger.Errorex("error creating new account", err, golog.String("email", email))
+	}
+	// encrypt key
+	keyBytes, err := c.secretBox.Seal(account.KeyBytes)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	// save new account
+	storeAccount := &store.Account{
+		URI:       account.URI,
+		Directory: ca.profile.DirectoryURI,
+		Email:     email,
+		Key:       keyBytes,
+	}
+	err = c.config.Store.PutAccount(storeAccount)
+	if store.IsConflict(err) {
+		// someone else created the account at the same time, so use theirs
+		logger.Info("account created concurrently, using stored account", golog.String("email", email))
+		return c.useStoredAccount(ca)
+	}
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	return account, nil
+}
+
+// useStoredAccount loads the account from the store and uses it for directory methods
+func (c *coyote) useStoredAccount(ca *caClient) (*acmelib.Account, error) {
+	account, err := c.getAccount(ca.profile)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	if account == nil {
+		return nil, logger.Error("account not found in store", golog.String("email", ca.profile.ContactEmail))
+	}
+	return ca.client.UseAccount(context.Background(), account)
+}
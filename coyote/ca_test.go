@@ -0,0 +1,69 @@
+package coyote
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+
+	"github.com/stugotech/coyote/acmelib"
+	"github.com/stugotech/coyote/store"
+)
+
+func TestAccountIsCarriedOverFromV1Directory(t *testing.T) {
+	tests := []struct {
+		name      string
+		directory string
+		uri       string
+	}{
+		{"v1 directory", acmelib.LetsEncryptLiveV1Directory, "https://acme-v01.api.letsencrypt.org/acme/reg/1"},
+		{"without directory", "", "https://acme-v01.api.letsencrypt.org/acme/reg/1"},
+	}
+	for _, test := range tests {
+		c, st := newTestCoyote(t, &Config{}, newFakeCA(t, "primary"))
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed, err := c.secretBox.Seal(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = st.PutAccount(&store.Account{URI: test.uri, Directory: test.directory, Email: "admin@example.com", Key: sealed})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		profile := &CAProfile{Name: "le", DirectoryURI: acmelib.LetsEncryptLiveDirectory, ContactEmail: "admin@example.com"}
+		account, err := c.getAccount(profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if account == nil || !account.Key.Public().(*ecdsa.PublicKey).Equal(key.Public()) {
+			t.Errorf("%s: v1 account wasn't used", test.name)
+			continue
+		}
+		moved, err := st.GetAccount(acmelib.LetsEncryptLiveDirectory, "admin@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if moved == nil || moved.LastIndex == 0 {
+			t.Errorf("%s: account wasn't stored under the v2 directory", test.name)
+		}
+
+		// the account is given its v2 URI once the CA has looked it up
+		c.updateAccountURI(profile, "https://acme-v02.api.letsencrypt.org/acme/acct/1")
+		moved, err = st.GetAccount(acmelib.LetsEncryptLiveDirectory, "admin@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if moved.URI != "https://acme-v02.api.letsencrypt.org/acme/acct/1" {
+			t.Errorf("%s: got account URI %q, want the v2 URI", test.name, moved.URI)
+		}
+	}
+}
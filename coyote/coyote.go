@@ -2,6 +2,8 @@ package coyote
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"time"
 
 	"path/filepath"
@@ -18,9 +20,15 @@ var logger = golog.NewPackageLogger()
 
 const (
 	authRetries = 5
+	putRetries  = 5
 	backoffMs   = 300
+	// DefaultChallengeTTL is how long challenges are kept in the store if no TTL is configured.
+	DefaultChallengeTTL = time.Hour
 )
 
+// errUnchanged is returned by a certificate update which leaves the certificate as it is
+var errUnchanged = errors.New("certificate unchanged")
+
 // Coyote describes the things that the coyote tool can do
 type Coyote interface {
 	// Authorize authorizes a domain under the users control.
@@ -31,11 +39,24 @@ type Coyote interface {
 	CompleteAuthorize(challengeURI string) error
 	// NewCertificate creates one or more certificates for the specified domains, grouped by registered domain.
 	NewCertificate(domains []string) ([]*store.Certificate, error)
+	// NewCertificateWithCA creates certificates like NewCertificate, using the named CA profile.
+	NewCertificateWithCA(ca string, domains []string) ([]*store.Certificate, error)
+	// NewCertificateFromCSR creates a certificate for the names in a user-supplied certificate
+	// request, using the named CA profile or the default if ca is empty.  A certificate with a
+	// private key for the same domain is only replaced if overwrite is set.
+	NewCertificateFromCSR(ca string, csr []byte, overwrite bool) (*store.Certificate, error)
+	// SetReuseKey sets whether the certificate for the domain keeps its private key on renewal.
+	SetReuseKey(domain string, reuse bool) error
+	// ImportCertificate stores an existing certificate chain and private key so that coyote renews
+	// it.  Nil is returned if the domain already has a certificate and overwrite is false.
+	ImportCertificate(ca string, chainPEM []byte, keyPEM []byte, overwrite bool) (*store.Certificate, error)
 	// RenewExpiringCertificates checks expiry dates on certificates and renews certificates that will
 	// expire before `before` has elapsed.
 	RenewExpiringCertificates(before time.Duration) ([]*store.Certificate, error)
 	// GetCertificates gets all certificates in the store.
 	GetCertificates() ([]*store.Certificate, error)
+	// GetCertificate gets the certificate for the domain, or nil if there isn't one.
+	GetCertificate(domain string) (*store.Certificate, error)
 }
 
 // Config describes the coyote configuration settings
@@ -45,18 +66,31 @@ type Config struct {
 	DirectoyURI  string
 	AcceptTOS    bool
 	SecretKey    string
+	// SecretPassphrase is used to derive the seal key if SecretKey is not set.
+	SecretPassphrase string
+	// ChallengeTTL is how long challenges are kept in the store; defaults to DefaultChallengeTTL.
+	ChallengeTTL time.Duration
+	// CAs are additional named CA profiles that certificates can be requested from.
+	CAs []*CAProfile
+	// FallbackCAs are the names of CA profiles to try in order if the requested CA fails.
+	FallbackCAs []string
+	// PreferredChain is the common name of the root or issuer of the chain to use with the default
+	// CA profile, if it offers alternate chains.
+	PreferredChain string
 }
 
 // coyote implements the Coyote interface
 type coyote struct {
 	config    *Config
-	client    acmelib.Client
 	secretBox secret.Box
+
+	mutex   sync.Mutex
+	clients map[string]*caClient
 }
 
 // NewCoyote creates a new instance of the Coyote interface
 func NewCoyote(config *Config) (Coyote, error) {
-	secretBox, err := secret.NewBoxFromKeyString(config.SecretKey)
+	secretBox, err := NewSecretBox(config)
 	if err != nil {
 		return nil, logger.Errore(err)
 	}
@@ -64,94 +98,81 @@ func NewCoyote(config *Config) (Coyote, error) {
 	c := &coyote{
 		config:    config,
 		secretBox: secretBox,
+		clients:   make(map[string]*caClient),
 	}
 
-	c.client, err = acmelib.NewClient(config.DirectoyURI)
-	if err != nil {
-		return nil, logger.Errore(err)
+	// clients are created when first used, so that an unavailable CA doesn't prevent falling back
+	// to another one
+	return c, nil
+}
+
+// NewSecretBox creates the box used to seal secrets, from either the seal key or passphrase.  Only
+// the Store, SecretKey and SecretPassphrase settings are used.
+func NewSecretBox(config *Config) (secret.Box, error) {
+	if config.SecretKey != "" || config.SecretPassphrase == "" {
+		return secret.NewBoxFromKeyString(config.SecretKey)
 	}
 
-	account, err := c.getAccount(config.ContactEmail)
+	salt, err := config.Store.GetSealSalt()
 	if err != nil {
 		return nil, logger.Errore(err)
 	}
-
-	if account != nil {
-		_, err = c.client.UseAccount(context.Background(), account)
+	if salt == nil {
+		logger.Info("no seal salt found in store, creating new salt")
+		salt, err = secret.NewSalt()
 		if err != nil {
 			return nil, logger.Errore(err)
 		}
-	} else {
-		// no account found - create new account
-		account, err = c.createAccount(config.ContactEmail, config.AcceptTOS)
+		err = config.Store.PutSealSalt(salt)
+		if store.IsConflict(err) {
+			// another node created the salt first, so use theirs
+			salt, err = config.Store.GetSealSalt()
+		}
 		if err != nil {
 			return nil, logger.Errore(err)
 		}
 	}
-
-	return c, nil
+	return secret.NewBoxFromPassphrase(config.SecretPassphrase, salt)
 }
 
-// getAccount looks up the account and returns the key if it exists
-func (c *coyote) getAccount(email string) (*acmelib.Account, error) {
-	account, err := c.config.Store.GetAccount(email)
-	if err != nil {
-		return nil, logger.Errore(err)
-	}
-	if account == nil {
-		return nil, nil
-	}
-	key, err := c.secretBox.Open(account.Key)
-	if err != nil {
-		return nil, logger.Errore(err)
+// NewArchiveBox creates a box which opens archives sealed with the seal key or passphrase, without
+// reading or creating the seal salt in the store.  Values sealed with a passphrase record the salt
+// they were sealed with, so the salt of the box itself doesn't matter.
+func NewArchiveBox(config *Config) (secret.Box, error) {
+	if config.SecretKey != "" || config.SecretPassphrase == "" {
+		return secret.NewBoxFromKeyString(config.SecretKey)
 	}
-	signer, err := cryptutil.ParsePrivateKeyFromDER(key)
+	salt, err := secret.NewSalt()
 	if err != nil {
 		return nil, logger.Errore(err)
 	}
-
-	return &acmelib.Account{
-		URI:   account.URI,
-		Key:   signer,
-		Email: account.Email,
-	}, nil
+	return secret.NewBoxFromPassphrase(config.SecretPassphrase, salt)
 }
 
-// createAccount creates a new account
-func (c *coyote) createAccount(email string, acceptTOS bool) (*acmelib.Account, error) {
-	account, err := c.client.RegisterAccount(context.Background(), email, acceptTOS)
-	if err != nil {
-		return nil, logger.Errorex("error creating new account", err, golog.String("email", email))
-	}
-	// encrypt key
-	keyBytes, err := c.secretBox.Seal(account.KeyBytes)
-	if err != nil {
-		return nil, logger.Errore(err)
-	}
-	// save new account
-	storeAccount := &store.Account{
-		URI:   account.URI,
-		Email: email,
-		Key:   keyBytes,
-	}
-	err = c.config.Store.PutAccount(storeAccount)
+// Authorize runs authorization on the given domain
+func (c *coyote) Authorize(domain string) error {
+	ca, err := c.getClient(c.defaultProfile())
 	if err != nil {
-		return nil, logger.Errore(err)
+		return logger.Errore(err)
 	}
-	return account, nil
+	return c.authorize(ca, domain)
 }
 
-// Authorize runs authorization on the given domain
-func (c *coyote) Authorize(domain string) error {
-	challenge, err := c.BeginAuthorize(domain)
+// authorize runs authorization on the given domain with the given CA
+func (c *coyote) authorize(ca *caClient, domain string) error {
+	challenge, err := c.beginAuthorize(ca, domain)
 	if err != nil {
 		return logger.Errore(err)
 	}
+	if challenge == nil {
+		return nil
+	}
+	defer c.deleteChallenge(challenge)
 
 	ctx := context.Background()
 
 	for i := 1; ; i++ {
-		err = c.client.CompleteAuthorize(ctx, challenge.AuthChallenge)
+		err = ca.client.CompleteAuthorize(ctx, challenge.AuthChallenge)
 		if err == nil {
 			break
 		}
@@ -168,10 +189,22 @@ func (c *coyote) Authorize(domain string) error {
 
 // BeginAuthorize gets the challenge details for the given domain
 func (c *coyote) BeginAuthorize(domain string) (*acmelib.HTTPAuthChallenge, error) {
-	logger.Info("begin authorization of domain", golog.String("domain", domain))
+	ca, err := c.getClient(c.defaultProfile())
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	return c.beginAuthorize(ca, domain)
+}
+
+// beginAuthorize gets the challenge details for the given domain from the given CA
+func (c *coyote) beginAuthorize(ca *caClient, domain string) (*acmelib.HTTPAuthChallenge, error) {
+	logger.Info("begin authorization of domain",
+		golog.String("domain", domain),
+		golog.String("ca", ca.profile.Name),
+	)
 	ctx := context.Background()
 
-	challenge, err := c.client.BeginAuthorize(ctx, domain)
+	challenge, err := ca.client.BeginAuthorize(ctx, domain)
 	if err != nil {
 		return nil, logger.Errore(err)
 	}
@@ -181,29 +214,57 @@ func (c *coyote) BeginAuthorize(domain string) (*acmelib.HTTPAuthChallenge, erro
 		return nil, nil
 	}
 
+	if err := c.PresentChallenge(challenge); err != nil {
+		return nil, logger.Errore(err)
+	}
+	return challenge, nil
+}
+
+// PresentChallenge saves a challenge in the store, where the challenge server answers it from
+func (c *coyote) PresentChallenge(challenge *acmelib.HTTPAuthChallenge) error {
 	logger.Debug("challenge received",
 		golog.String("URI", challenge.URI),
 		golog.String("path", challenge.Path),
 		golog.String("response", challenge.Response),
 	)
 
-	err = c.config.Store.PutChallenge(&store.Challenge{
-		Key:   filepath.Base(challenge.Path),
-		Value: challenge.Response,
-	})
+	ttl := c.config.ChallengeTTL
+	if ttl <= 0 {
+		ttl = DefaultChallengeTTL
+	}
 
+	err := c.config.Store.PutChallenge(&store.Challenge{
+		Key:     challengeKey(challenge),
+		Value:   challenge.Response,
+		Expires: time.Now().Add(ttl),
+	})
 	if err != nil {
-		return nil, logger.Errore(err)
+		return logger.Errore(err)
 	}
+	return nil
+}
 
-	return challenge, nil
+// CleanUpChallenge removes a challenge from the store once the CA has finished with it
+func (c *coyote) CleanUpChallenge(challenge *acmelib.HTTPAuthChallenge) {
+	c.deleteChallenge(challenge)
 }
 
 // CompleteAuthorize waits until the challenge can be completed
 func (c *coyote) CompleteAuthorize(challengeURI string) error {
 	ctx := context.Background()
 
-	err := c.client.CompleteAuthorizeURI(ctx, challengeURI)
+	ca, err := c.getClient(c.defaultProfile())
+	if err != nil {
+		return logger.Errore(err)
+	}
+
+	challenge, err := ca.client.GetChallenge(ctx, challengeURI)
+	if err != nil {
+		return logger.Errore(err)
+	}
+	defer c.deleteChallenge(challenge)
+
+	err = ca.client.CompleteAuthorize(ctx, challenge.AuthChallenge)
 	if err != nil {
 		return logger.Errore(err)
 	}
@@ -212,19 +273,50 @@ func (c *coyote) CompleteAuthorize(challengeURI string) error {
 	return nil
 }
 
+// deleteChallenge removes a challenge from the store once authorization has finished, unless it
+// has since been replaced, e.g. by another node authorizing the same name
+func (c *coyote) deleteChallenge(challenge *acmelib.HTTPAuthChallenge) {
+	key := challengeKey(challenge)
+	stored, err := c.config.Store.GetChallenge(key)
+	if err == nil && stored != nil && stored.Value == challenge.Response {
+		err = c.config.Store.DeleteChallenge(stored)
+	}
+	if err != nil && !store.IsConflict(err) {
+		logger.Errorex("unable to remove challenge from store", err, golog.String("key", key))
+	}
+}
+
+// challengeKey gets the key that a challenge is stored under
+func challengeKey(challenge *acmelib.HTTPAuthChallenge) string {
+	return filepath.Base(challenge.Path)
+}
+
 // NewCertificate creates a new certificate for the specified domains.
 func (c *coyote) NewCertificate(domains []string) ([]*store.Certificate, error) {
+	return c.newCertificate(c.defaultProfile(), domains)
+}
+
+// NewCertificateWithCA creates a new certificate for the specified domains using the named CA.
+func (c *coyote) NewCertificateWithCA(caName string, domains []string) ([]*store.Certificate, error) {
+	profile, err := c.caProfile(caName)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	return c.newCertificate(profile, domains)
+}
+
+// newCertificate creates a new certificate for the specified domains, trying the primary CA first
+// and then each of the fallback CAs.
+func (c *coyote) newCertificate(primary *CAProfile, domains []string) ([]*store.Certificate, error) {
 	logger.Info("create new certificate",
 		golog.Strings("domains", domains),
+		golog.String("ca", primary.Name),
 	)
 
 	groupedDomains := make(map[string][]string)
 
-	// authorize domains first and group under registered domains
+	// group under registered domains
 	for _, d := range domains {
-		if err := c.Authorize(d); err != nil {
-			return nil, logger.Errore(err)
-		}
 		reg, err := publicsuffix.EffectiveTLDPlusOne(d)
 		if err != nil {
 			return nil, logger.Errorex("can't get public suffix for domain", err, golog.String("domain", d))
@@ -244,43 +336,144 @@ func (c *coyote) NewCertificate(domains []string) ([]*store.Certificate, error)
 
 	// now create certificates
 	for domain, sans := range groupedDomains {
-		// see if the domain already has a certificate
-		storeCert, err := c.config.Store.GetCertificate(domain)
+		storeCert, err := c.issueCertificate(primary, domain, sans)
 		if err != nil {
 			return nil, logger.Errore(err)
 		}
+		certs = append(certs, storeCert)
+	}
+
+	return certs, nil
+}
+
+// issueCertificate creates a certificate for the domain from the first CA that succeeds.
+func (c *coyote) issueCertificate(primary *CAProfile, domain string, sans []string) (*store.Certificate, error) {
+	var err error
 
-		if storeCert != nil {
-			sans = uniqueStrings(sans, storeCert.AlternativeNames)
+	for _, profile := range c.caChain(primary) {
+		var ca *caClient
+		ca, err = c.getClient(profile)
+		if err == nil {
+			var cert *store.Certificate
+			cert, err = c.createCertificate(ca, primary, domain, sans)
+			if err == nil {
+				return cert, nil
+			}
 		}
+		logger.Errorex("unable to get certificate from CA", err,
+			golog.String("domain", domain),
+			golog.String("ca", profile.Name),
+		)
+	}
+
+	return nil, logger.Errorex("unable to get certificate from any CA", err, golog.String("domain", domain))
+}
 
-		cert, err := c.client.CreateCertificate(context.Background(), domain, sans)
+// caChain gets the CA profiles to try in order: the primary CA followed by the fallback CAs.
+func (c *coyote) caChain(primary *CAProfile) []*CAProfile {
+	chain := []*CAProfile{primary}
+
+	for _, name := range c.config.FallbackCAs {
+		if name == primary.Name {
+			continue
+		}
+		profile, err := c.caProfile(name)
 		if err != nil {
-			return nil, logger.Errore(err)
+			logger.Errorex("ignoring fallback CA", err, golog.String("ca", name))
+			continue
 		}
+		chain = append(chain, profile)
+	}
+
+	return chain
+}
+
+// createCertificate creates a certificate for the domain, authorizing the names as needed, then
+// saves it in the store.  If the stored certificate is changed by someone else in the meantime, the
+// stored certificate is re-read and a new certificate is only requested if the other change added
+// names.
+func (c *coyote) createCertificate(ca *caClient, primary *CAProfile, domain string, sans []string) (*store.Certificate, error) {
+	var issued *store.Certificate
+
+	return c.updateCertificate(domain, func(cert *store.Certificate) error {
+		sans = uniqueStrings(sans, cert.AlternativeNames)
 
-		storeCert = &store.Certificate{
-			Domain:           domain,
-			AlternativeNames: sans,
-			CertificateChain: cert.CertificatesPEM(),
-			PrivateKey:       cert.PrivateKeyPEM(),
-			Expires:          cert.Certificates[0].NotAfter,
-			Thumbprint:       cryptutil.Thumbprint(cert.Certificates[0].Raw),
+		if issued == nil || !containsAll(issued.AlternativeNames, sans) {
+			options := &acmelib.CertificateOptions{
+				PreferredChain: ca.profile.PreferredChain,
+				Solver:         c,
+			}
+			if cert.ReuseKey && len(cert.PrivateKey) > 0 {
+				var err error
+				options.Key, err = cryptutil.ParsePrivateKeyFromPEM(cert.PrivateKey)
+				if err != nil {
+					return logger.Errorex("unable to parse existing private key", err, golog.String("domain", domain))
+				}
+			}
+
+			bundle, err := ca.client.CreateCertificate(context.Background(), domain, sans, options)
+			if err != nil {
+				return logger.Errore(err)
+			}
+
+			issued = &store.Certificate{
+				Domain:           domain,
+				AlternativeNames: sans,
+				CertificateChain: bundle.CertificatesPEM(),
+				PrivateKey:       bundle.PrivateKeyPEM(),
+				CA:               ca.profile.Name,
+				PrimaryCA:        primary.Name,
+				DirectoryURI:     ca.profile.DirectoryURI,
+				AccountEmail:     ca.profile.ContactEmail,
+				Issued:           time.Now(),
+			}
+			issued.SetLeafDetails(bundle.Certificates[0])
 		}
 
-		err = c.config.Store.PutCertificate(storeCert)
+		replaceCertificate(cert, issued)
+		return nil
+	})
+}
+
+// updateCertificate applies update to the stored certificate for the domain and saves it.  The
+// certificate passed to update has a LastIndex of zero if the domain has no certificate yet.  If the
+// certificate is changed by someone else before it is saved, it is read again and update is applied
+// to the new version.  If update returns errUnchanged, nothing is saved.
+func (c *coyote) updateCertificate(domain string, update func(cert *store.Certificate) error) (*store.Certificate, error) {
+	for i := 1; ; i++ {
+		cert, err := c.config.Store.GetCertificate(domain)
 		if err != nil {
 			return nil, logger.Errore(err)
 		}
+		if cert == nil {
+			cert = &store.Certificate{Domain: domain}
+		}
 
-		certs = append(certs, storeCert)
-	}
+		err = update(cert)
+		if err == errUnchanged {
+			return cert, nil
+		}
+		if err != nil {
+			// returned as it is, so that callers can check for their own errors
+			return nil, err
+		}
 
-	return certs, nil
+		err = c.config.Store.PutCertificate(cert)
+		if err == nil {
+			return cert, nil
+		}
+		if !store.IsConflict(err) || i >= putRetries {
+			return nil, logger.Errore(err)
+		}
+
+		logger.Info("certificate changed in store while updating, retrying", golog.String("domain", domain))
+		time.Sleep(time.Duration(i*backoffMs) * time.Millisecond)
+	}
 }
 
 // RenewExpiringCertificates checks expiry dates on certificates and renews certificates that will
-// expire before `before` has elapsed.
+// expire before `before` has elapsed.  Certificates are renewed with the CA they were originally
+// requested from, even if a fallback CA issued them last time.
 func (c *coyote) RenewExpiringCertificates(before time.Duration) ([]*store.Certificate, error) {
 	certs, err := c.config.Store.GetCertificates()
 	if err != nil {
@@ -288,27 +481,142 @@ func (c *coyote) RenewExpiringCertificates(before time.Duration) ([]*store.Certi
 	}
 
 	var renewedCerts []*store.Certificate
+	var failed []string
 	threshold := time.Now().Add(before)
 
 	for _, cert := range certs {
-		if threshold.After(cert.Expires) {
-			domains := append(cert.AlternativeNames[:], cert.Domain)
-			newCerts, err := c.NewCertificate(domains)
-			if err != nil {
-				return nil, logger.Errore(err)
-			}
-			renewedCerts = append(renewedCerts, newCerts...)
+		if !threshold.After(cert.Expires) {
+			continue
 		}
+		newCerts, err := c.renewCertificate(cert)
+		if err != nil {
+			// record the failure and carry on with the other certificates
+			c.recordRenewalError(cert.Domain, err)
+			failed = append(failed, cert.Domain)
+			continue
+		}
+		renewedCerts = append(renewedCerts, newCerts...)
 	}
 
+	if len(failed) > 0 {
+		return renewedCerts, logger.Error("unable to renew certificates", golog.Strings("domains", failed))
+	}
 	return renewedCerts, nil
 }
 
-// GetCertificate gets all certificates in the store.
+// renewCertificate gets a new certificate for the same names as an existing certificate
+func (c *coyote) renewCertificate(cert *store.Certificate) ([]*store.Certificate, error) {
+	if len(cert.CSR) > 0 {
+		// renew using the same request, as the key is held elsewhere
+		req, err := cryptutil.ParseCertificateRequest(cert.CSR)
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+		newCert, err := c.issueCertificateFromCSR(c.primaryProfile(cert), req, false)
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+		return []*store.Certificate{newCert}, nil
+	}
+
+	// renew in place rather than grouping the names again, as imported certificates aren't grouped
+	// by registered domain
+	newCert, err := c.issueCertificate(c.primaryProfile(cert), cert.Domain, cert.AlternativeNames)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	return []*store.Certificate{newCert}, nil
+}
+
+// recordRenewalError saves the time and error of a failed renewal against the certificate
+func (c *coyote) recordRenewalError(domain string, renewErr error) {
+	_, err := c.updateCertificate(domain, func(cert *store.Certificate) error {
+		if cert.LastIndex == 0 {
+			return logger.Error("no certificate found for domain", golog.String("domain", domain))
+		}
+		cert.LastRenewalAttempt = time.Now()
+		cert.LastRenewalError = renewErr.Error()
+		return nil
+	})
+	if err != nil {
+		logger.Errorex("unable to record renewal error", err, golog.String("domain", domain))
+	}
+}
+
+// SetReuseKey sets whether the certificate for the domain keeps its private key on renewal.
+func (c *coyote) SetReuseKey(domain string, reuse bool) error {
+	_, err := c.updateCertificate(domain, func(cert *store.Certificate) error {
+		if cert.LastIndex == 0 {
+			return logger.Error("no certificate found for domain", golog.String("domain", domain))
+		}
+		if cert.ReuseKey == reuse {
+			return errUnchanged
+		}
+		cert.ReuseKey = reuse
+		return nil
+	})
+	return err
+}
+
+// GetCertificates gets all certificates in the store.
 func (c *coyote) GetCertificates() ([]*store.Certificate, error) {
 	return c.config.Store.GetCertificates()
 }
 
+// GetCertificate gets the certificate for the domain, or nil if there isn't one.
+func (c *coyote) GetCertificate(domain string) (*store.Certificate, error) {
+	return c.config.Store.GetCertificate(domain)
+}
+
+// replaceCertificate replaces a stored certificate with a newly issued one, keeping the settings and
+// renewal history of the stored certificate
+func replaceCertificate(cert *store.Certificate, issued *store.Certificate) {
+	existing := *cert
+	*cert = *issued
+	cert.LastIndex = existing.LastIndex
+	cert.ReuseKey = existing.ReuseKey
+	if existing.LastIndex != 0 {
+		setRenewalDetails(cert, &existing)
+	}
+}
+
+// setRenewalDetails updates the renewal history of a new certificate replacing an existing one.  It
+// only counts as a renewal if it has the same names; a certificate issued to add names keeps the
+// history of the one it replaces.
+func setRenewalDetails(cert *store.Certificate, existing *store.Certificate) {
+	if existing == nil {
+		return
+	}
+	cert.LastRenewalError = ""
+	cert.LastRenewalAttempt = existing.LastRenewalAttempt
+	cert.RenewalCount = existing.RenewalCount
+	if existing.Thumbprint != cert.Thumbprint && sameNames(cert, existing) {
+		cert.LastRenewalAttempt = cert.Issued
+		cert.RenewalCount++
+	}
+}
+
+// sameNames returns true if both certificates are for the same domain and alternative names
+func sameNames(a *store.Certificate, b *store.Certificate) bool {
+	return a.Domain == b.Domain &&
+		containsAll(a.AlternativeNames, b.AlternativeNames) &&
+		containsAll(b.AlternativeNames, a.AlternativeNames)
+}
+
+// containsAll returns true if all of the values are in the list
+func containsAll(list []string, values []string) bool {
+	set := make(map[string]struct{})
+	for _, v := range list {
+		set[v] = struct{}{}
+	}
+	for _, v := range values {
+		if _, ok := set[v]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
 // uniqueStrings returns the unique strings in all of the lists
 func uniqueStrings(src ...[]string) []string {
 	set := make(map[string]struct{})
@@ -0,0 +1,200 @@
+package coyote
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"testing"
+)
+
+func TestNewCertificateFallsBackToNextCA(t *testing.T) {
+	primary := newFakeCA(t, "primary")
+	primary.err = errors.New("CA unavailable")
+	fallback := newFakeCA(t, "fallback")
+	c, st := newTestCoyote(t, &Config{FallbackCAs: []string{"fallback"}}, primary, fallback)
+
+	certs, err := c.NewCertificate([]string{"example.com", "www.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 {
+		t.Fatalf("got %d certificates, want 1", len(certs))
+	}
+	if len(primary.requests) != 1 || len(fallback.requests) != 1 {
+		t.Fatalf("got %d requests to primary and %d to fallback, want 1 each", len(primary.requests), len(fallback.requests))
+	}
+
+	cert, err := st.GetCertificate("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert == nil {
+		t.Fatal("certificate wasn't stored")
+	}
+	if cert.CA != "fallback" || cert.PrimaryCA != DefaultCAName {
+		t.Errorf("got CA %q and primary CA %q, want fallback and %s", cert.CA, cert.PrimaryCA, DefaultCAName)
+	}
+	if cert.Issuer != "fallback" {
+		t.Errorf("got issuer %q, want fallback", cert.Issuer)
+	}
+
+	// challenges are removed from the store once the CA has finished with them
+	if len(fallback.challenges) != 2 {
+		t.Errorf("got challenges for %v, want example.com and www.example.com", fallback.challenges)
+	}
+	challenges, err := st.GetChallenges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(challenges) != 0 {
+		t.Errorf("got %d challenges left in store, want none", len(challenges))
+	}
+
+	// renewal starts with the CA the certificate was requested from
+	primary.err = nil
+	if _, err := c.renewCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	renewed, err := st.GetCertificate("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.CA != DefaultCAName || renewed.PrimaryCA != DefaultCAName {
+		t.Errorf("got CA %q and primary CA %q after renewal, want %s", renewed.CA, renewed.PrimaryCA, DefaultCAName)
+	}
+}
+
+func TestNewCertificateFailsIfAllCAsFail(t *testing.T) {
+	primary := newFakeCA(t, "primary")
+	primary.err = errors.New("CA unavailable")
+	fallback := newFakeCA(t, "fallback")
+	fallback.err = errors.New("rate limited")
+	c, st := newTestCoyote(t, &Config{FallbackCAs: []string{"fallback"}}, primary, fallback)
+
+	if _, err := c.NewCertificate([]string{"example.com"}); err == nil {
+		t.Fatal("expected error")
+	}
+	cert, err := st.GetCertificate("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert != nil {
+		t.Error("expected no certificate to be stored")
+	}
+}
+
+func TestNewCertificateFromCSRFallsBackToNextCA(t *testing.T) {
+	primary := newFakeCA(t, "primary")
+	primary.err = errors.New("CA unavailable")
+	fallback := newFakeCA(t, "fallback")
+	c, st := newTestCoyote(t, &Config{FallbackCAs: []string{"fallback"}}, primary, fallback)
+
+	csr := newTestCSR(t, "example.com", "api.example.com")
+	cert, err := c.NewCertificateFromCSR("", csr, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.CA != "fallback" || cert.PrimaryCA != DefaultCAName {
+		t.Errorf("got CA %q and primary CA %q, want fallback and %s", cert.CA, cert.PrimaryCA, DefaultCAName)
+	}
+	if len(cert.PrivateKey) != 0 || len(cert.CSR) == 0 {
+		t.Error("expected certificate to be stored with the CSR and no private key")
+	}
+	stored, err := st.GetCertificate("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored == nil || stored.Thumbprint != cert.Thumbprint {
+		t.Error("certificate wasn't stored")
+	}
+}
+
+func TestNewCertificateFromCSRDoesNotReplaceKeyedCertificate(t *testing.T) {
+	ca := newFakeCA(t, "primary")
+	c, st := newTestCoyote(t, &Config{}, ca)
+
+	keyed, err := c.NewCertificate([]string{"example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr := newTestCSR(t, "example.com")
+
+	if _, err := c.NewCertificateFromCSR("", csr, false); err != errKeyedCertificate {
+		t.Fatalf("got error %v, want %v", err, errKeyedCertificate)
+	}
+	if len(ca.requests) != 1 {
+		t.Errorf("got %d requests to CA, want no request for the CSR", len(ca.requests))
+	}
+	stored, err := st.GetCertificate("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Thumbprint != keyed[0].Thumbprint || len(stored.PrivateKey) == 0 {
+		t.Error("keyed certificate was replaced")
+	}
+
+	cert, err := c.NewCertificateFromCSR("", csr, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.PrivateKey) != 0 || cert.Thumbprint == keyed[0].Thumbprint {
+		t.Error("expected keyed certificate to be replaced with overwrite")
+	}
+}
+
+// newTestCSR creates a DER encoded certificate request for the names, with the first as the subject
+func newTestCSR(t *testing.T, names ...string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: names[0]},
+		DNSNames: names,
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csr
+}
+
+func TestRenewalCountOnlyCountsRenewals(t *testing.T) {
+	ca := newFakeCA(t, "primary")
+	c, st := newTestCoyote(t, &Config{}, ca)
+
+	if _, err := c.NewCertificate([]string{"example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := st.GetCertificate("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.renewCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+	cert, err = st.GetCertificate("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.RenewalCount != 1 || cert.LastRenewalAttempt.IsZero() {
+		t.Fatalf("got renewal count %d, want 1 after renewal", cert.RenewalCount)
+	}
+
+	// adding a name issues a new certificate, but isn't a renewal
+	if _, err := c.NewCertificate([]string{"www.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	cert, err = st.GetCertificate("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.AlternativeNames) != 1 {
+		t.Fatalf("got alternative names %v, want www.example.com", cert.AlternativeNames)
+	}
+	if cert.RenewalCount != 1 {
+		t.Errorf("got renewal count %d, want 1 after adding a name", cert.RenewalCount)
+	}
+}
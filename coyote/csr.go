@@ -0,0 +1,148 @@
+package coyote
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"time"
+
+	"github.com/stugotech/coyote/acmelib"
+	"github.com/stugotech/coyote/cryptutil"
+	"github.com/stugotech/coyote/store"
+	"github.com/stugotech/golog"
+)
+
+// errKeyedCertificate is returned when a certificate for a user-supplied request would replace a
+// certificate with a private key
+var errKeyedCertificate = errors.New("domain already has a certificate with a private key; use overwrite to replace it")
+
+// NewCertificateFromCSR creates a certificate for the names in a PEM or DER encoded certificate
+// request using the named CA, or the default CA if caName is empty.  The certificate is stored
+// without a private key.  If the domain has a certificate with a private key, it is only replaced if
+// overwrite is set, as its key and names would be lost.
+func (c *coyote) NewCertificateFromCSR(caName string, csr []byte, overwrite bool) (*store.Certificate, error) {
+	profile, err := c.caProfile(caName)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	req, err := cryptutil.ParseCertificateRequest(csr)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	return c.issueCertificateFromCSR(profile, req, overwrite)
+}
+
+// issueCertificateFromCSR creates a certificate for the request from the first CA that succeeds.
+func (c *coyote) issueCertificateFromCSR(primary *CAProfile, req *x509.CertificateRequest, overwrite bool) (*store.Certificate, error) {
+	names, err := csrNames(req)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+
+	// check before asking a CA for a certificate which couldn't be saved
+	if !overwrite {
+		existing, err := c.config.Store.GetCertificate(names[0])
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+		if err := checkKeylessReplace(existing); err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Info("create new certificate from CSR",
+		golog.Strings("domains", names),
+		golog.String("ca", primary.Name),
+	)
+
+	for _, profile := range c.caChain(primary) {
+		var ca *caClient
+		ca, err = c.getClient(profile)
+		if err == nil {
+			var cert *store.Certificate
+			cert, err = c.createCertificateFromCSR(ca, primary, req, names, overwrite)
+			if err == nil {
+				return cert, nil
+			}
+		}
+		if err == errKeyedCertificate {
+			return nil, err
+		}
+		logger.Errorex("unable to get certificate from CA", err,
+			golog.String("domain", names[0]),
+			golog.String("ca", profile.Name),
+		)
+	}
+
+	return nil, logger.Errorex("unable to get certificate from any CA", err, golog.String("domain", names[0]))
+}
+
+// createCertificateFromCSR creates a certificate for the request, authorizing the names as needed,
+// then saves it in the store.
+func (c *coyote) createCertificateFromCSR(ca *caClient, primary *CAProfile, req *x509.CertificateRequest, names []string, overwrite bool) (*store.Certificate, error) {
+	cert, err := ca.client.CreateCertificateFromCSR(context.Background(), req.Raw, &acmelib.CertificateOptions{
+		PreferredChain: ca.profile.PreferredChain,
+		Solver:         c,
+	})
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+
+	storeCert := &store.Certificate{
+		Domain:           names[0],
+		AlternativeNames: names[1:],
+		CertificateChain: cert.CertificatesPEM(),
+		CSR:              pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: req.Raw}),
+		CA:               ca.profile.Name,
+		PrimaryCA:        primary.Name,
+		DirectoryURI:     ca.profile.DirectoryURI,
+		AccountEmail:     ca.profile.ContactEmail,
+		Issued:           time.Now(),
+	}
+	storeCert.SetLeafDetails(cert.Certificates[0])
+
+	// the names are fixed by the request, so a concurrent change is simply replaced unless it gave
+	// the domain a private key
+	return c.updateCertificate(storeCert.Domain, func(cert *store.Certificate) error {
+		if !overwrite {
+			if err := checkKeylessReplace(cert); err != nil {
+				return err
+			}
+		}
+		replaceCertificate(cert, storeCert)
+		return nil
+	})
+}
+
+// checkKeylessReplace returns errKeyedCertificate if a certificate has a private key, which would be
+// lost if it were replaced with a certificate for a user-supplied request
+func checkKeylessReplace(existing *store.Certificate) error {
+	if existing != nil && len(existing.PrivateKey) > 0 {
+		logger.Error("domain already has a certificate with a private key", golog.String("domain", existing.Domain))
+		return errKeyedCertificate
+	}
+	return nil
+}
+
+// csrNames gets the names to authorize for a certificate request, with the subject name first.
+func csrNames(req *x509.CertificateRequest) ([]string, error) {
+	if len(req.IPAddresses) > 0 || len(req.EmailAddresses) > 0 || len(req.URIs) > 0 {
+		return nil, logger.Error("certificate request may only contain DNS names")
+	}
+
+	var names []string
+	if req.Subject.CommonName != "" {
+		names = append(names, req.Subject.CommonName)
+	}
+	for _, name := range req.DNSNames {
+		if name != req.Subject.CommonName {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, logger.Error("certificate request has no names")
+	}
+	return names, nil
+}
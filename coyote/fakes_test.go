@@ -0,0 +1,192 @@
+package coyote
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stugotech/coyote/acmelib"
+	coyotestore "github.com/stugotech/coyote/store"
+	"github.com/stugotech/coyote/store/memkv"
+)
+
+// fakeCA is an ACME client which issues certificates from an in-memory CA, presenting a challenge
+// for each name it is asked for
+type fakeCA struct {
+	name string
+	// err is returned instead of a certificate, if set
+	err    error
+	key    *ecdsa.PrivateKey
+	cert   *x509.Certificate
+	serial int64
+
+	// requests are the names of each certificate requested
+	requests [][]string
+	// challenges are the names that challenges were presented for
+	challenges []string
+}
+
+func newFakeCA(t *testing.T, name string) *fakeCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeCA{name: name, key: key, cert: cert, serial: 1}
+}
+
+// issue signs a certificate for the names and public key
+func (f *fakeCA) issue(names []string, pub crypto.PublicKey, options *acmelib.CertificateOptions) ([][]byte, []*x509.Certificate, error) {
+	f.requests = append(f.requests, names)
+	if f.err != nil {
+		return nil, nil, f.err
+	}
+	for _, name := range names {
+		challenge := &acmelib.HTTPAuthChallenge{
+			Path:     "/.well-known/acme-challenge/" + f.name + "-" + name,
+			Response: "response-" + name,
+		}
+		if err := options.Solver.PresentChallenge(challenge); err != nil {
+			return nil, nil, err
+		}
+		f.challenges = append(f.challenges, name)
+		options.Solver.CleanUpChallenge(challenge)
+	}
+
+	f.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(f.serial),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, f.cert, pub, f.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return [][]byte{der, f.cert.Raw}, []*x509.Certificate{leaf, f.cert}, nil
+}
+
+func (f *fakeCA) RegisterAccount(ctx context.Context, email string, acceptTOS bool) (*acmelib.Account, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeCA) UseAccount(ctx context.Context, account *acmelib.Account) (*acmelib.Account, error) {
+	return account, nil
+}
+
+func (f *fakeCA) CreateCertificate(ctx context.Context, domain string, san []string, options *acmelib.CertificateOptions) (*acmelib.CertificateBundle, error) {
+	key := options.Key
+	if key == nil {
+		var err error
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key.(*ecdsa.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+	der, certs, err := f.issue(append([]string{domain}, san...), key.Public(), options)
+	if err != nil {
+		return nil, err
+	}
+	return &acmelib.CertificateBundle{
+		CertificatesRaw: der,
+		Certificates:    certs,
+		PrivateKey:      keyBytes,
+		PrivateKeyType:  "EC",
+	}, nil
+}
+
+func (f *fakeCA) CreateCertificateFromCSR(ctx context.Context, csr []byte, options *acmelib.CertificateOptions) (*acmelib.CertificateBundle, error) {
+	req, err := x509.ParseCertificateRequest(csr)
+	if err != nil {
+		return nil, err
+	}
+	der, certs, err := f.issue(req.DNSNames, req.PublicKey, options)
+	if err != nil {
+		return nil, err
+	}
+	return &acmelib.CertificateBundle{CertificatesRaw: der, Certificates: certs}, nil
+}
+
+func (f *fakeCA) BeginAuthorize(ctx context.Context, domain string) (*acmelib.HTTPAuthChallenge, error) {
+	return nil, nil
+}
+
+func (f *fakeCA) GetChallenge(ctx context.Context, challengeURI string) (*acmelib.HTTPAuthChallenge, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeCA) CompleteAuthorize(ctx context.Context, challenge acmelib.AuthChallenge) error {
+	return nil
+}
+
+func (f *fakeCA) CompleteAuthorizeURI(ctx context.Context, challengeURI string) error {
+	return nil
+}
+
+// newTestCoyote creates a coyote with an in-memory store which uses the fake CAs for the default
+// profile and for CA profiles of the same names
+func newTestCoyote(t *testing.T, config *Config, defaultCA *fakeCA, cas ...*fakeCA) (*coyote, coyotestore.Store) {
+	st, err := coyotestore.NewLibKVStore(memkv.New(), "coyote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Store = st
+	config.SecretKey = strings.Repeat("ab", 32)
+	if config.DirectoyURI == "" {
+		config.DirectoyURI = "https://" + defaultCA.name + "/directory"
+	}
+	for _, ca := range cas {
+		config.CAs = append(config.CAs, &CAProfile{Name: ca.name, DirectoryURI: "https://" + ca.name + "/directory"})
+	}
+	coy, err := NewCoyote(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := coy.(*coyote)
+
+	// the clients are cached by profile name, so that no accounts are created
+	c.clients[DefaultCAName] = &caClient{profile: c.defaultProfile(), client: defaultCA}
+	for _, ca := range cas {
+		profile, err := c.caProfile(ca.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.clients[ca.name] = &caClient{profile: profile, client: ca}
+	}
+	return c, st
+}
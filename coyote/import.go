@@ -0,0 +1,92 @@
+package coyote
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+
+	"github.com/stugotech/coyote/acmelib"
+	"github.com/stugotech/coyote/store"
+	"github.com/stugotech/golog"
+)
+
+// ImportCertificate stores an existing PEM encoded certificate chain, leaf first, and its private key
+// so that coyote takes over renewing it from the named CA, or the default CA if caName is empty.  If
+// a certificate already exists for the domain it is only replaced if overwrite is set; otherwise nil
+// is returned.
+func (c *coyote) ImportCertificate(caName string, chainPEM []byte, keyPEM []byte, overwrite bool) (*store.Certificate, error) {
+	profile, err := c.caProfile(caName)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	if len(keyPEM) == 0 {
+		return nil, logger.Error("a private key is required to import a certificate")
+	}
+	// check that the key belongs to the leaf certificate
+	if _, err := tls.X509KeyPair(chainPEM, keyPEM); err != nil {
+		return nil, logger.Errorex("private key does not match certificate", err)
+	}
+	bundle, err := acmelib.NewCertificateBundleFromPEM(chainPEM, keyPEM)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	leaf := bundle.Certificates[0]
+	names, err := certificateNames(leaf)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+
+	storeCert := &store.Certificate{
+		Domain:           names[0],
+		AlternativeNames: names[1:],
+		CertificateChain: bundle.CertificatesPEM(),
+		PrivateKey:       bundle.PrivateKeyPEM(),
+		// the issuing CA is unknown, but renewals are requested from the primary CA
+		PrimaryCA: profile.Name,
+		Issued:    leaf.NotBefore,
+	}
+	storeCert.SetLeafDetails(leaf)
+
+	logger.Info("import certificate",
+		golog.Strings("domains", names),
+		golog.String("thumbprint", storeCert.Thumbprint),
+	)
+
+	exists := false
+	cert, err := c.updateCertificate(storeCert.Domain, func(cert *store.Certificate) error {
+		if cert.LastIndex != 0 && !overwrite {
+			exists = true
+			return errUnchanged
+		}
+		lastIndex, reuseKey, renewalCount := cert.LastIndex, cert.ReuseKey, cert.RenewalCount
+		*cert = *storeCert
+		cert.LastIndex = lastIndex
+		cert.ReuseKey = reuseKey
+		cert.RenewalCount = renewalCount
+		return nil
+	})
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	if exists {
+		logger.Info("certificate already exists, not importing", golog.String("domain", storeCert.Domain))
+		return nil, nil
+	}
+	return cert, nil
+}
+
+// certificateNames gets the DNS names of a certificate, with the subject name first
+func certificateNames(leaf *x509.Certificate) ([]string, error) {
+	var names []string
+	if leaf.Subject.CommonName != "" {
+		names = append(names, leaf.Subject.CommonName)
+	}
+	for _, name := range leaf.DNSNames {
+		if name != leaf.Subject.CommonName {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, logger.Error("certificate has no names")
+	}
+	return names, nil
+}
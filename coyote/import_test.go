@@ -0,0 +1,86 @@
+package coyote
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestImportedCertificateIsRenewedInPlace(t *testing.T) {
+	ca := newFakeCA(t, "primary")
+	c, st := newTestCoyote(t, &Config{}, ca)
+
+	chainPEM, keyPEM := newTestCertificatePEM(t, "www.example.com", "api.example.com")
+	imported, err := c.ImportCertificate("", chainPEM, keyPEM, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.Domain != "www.example.com" {
+		t.Fatalf("got domain %q, want www.example.com", imported.Domain)
+	}
+
+	renewed, err := c.RenewExpiringCertificates(365 * 24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renewed) != 1 {
+		t.Fatalf("got %d renewed certificates, want 1", len(renewed))
+	}
+	want := [][]string{{"www.example.com", "api.example.com"}}
+	if !reflect.DeepEqual(ca.requests, want) {
+		t.Errorf("got requests for %v, want %v", ca.requests, want)
+	}
+
+	cert, err := st.GetCertificate("www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Thumbprint == imported.Thumbprint || cert.Issuer != "primary" {
+		t.Error("imported certificate wasn't renewed")
+	}
+	if apex, err := st.GetCertificate("example.com"); err != nil || apex != nil {
+		t.Errorf("got certificate %v and error %v for example.com, want neither", apex, err)
+	}
+
+	// the renewed certificate isn't due for renewal again
+	renewed, err = c.RenewExpiringCertificates(24 * time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renewed) != 0 || len(ca.requests) != 1 {
+		t.Errorf("got %d renewed certificates and %d requests, want no more", len(renewed), len(ca.requests))
+	}
+}
+
+// newTestCertificatePEM creates a self-signed certificate for the names, with the first as the
+// subject, and returns it and its private key PEM encoded
+func newTestCertificatePEM(t *testing.T, names ...string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(7 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
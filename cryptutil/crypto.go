@@ -3,6 +3,7 @@ package cryptutil
 import (
 	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
@@ -10,6 +11,8 @@ import (
 
 	"crypto/sha1"
 	"encoding/hex"
+	"encoding/pem"
+	"fmt"
 
 	"github.com/stugotech/golog"
 )
@@ -41,6 +44,39 @@ func ParsePrivateKeyFromDER(der []byte) (crypto.Signer, error) {
 	return nil, logger.Error("failed to parse private key")
 }
 
+// ParseCertificatesFromPEM parses all of the certificates in a PEM bundle.
+func ParseCertificatesFromPEM(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, logger.Errorex("error decoding certificate", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, logger.Error("no certificate data found")
+	}
+	return certs, nil
+}
+
+// ParsePrivateKeyFromPEM parses the first PEM block in the given data as a private key.
+func ParsePrivateKeyFromPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, logger.Error("no PEM data found for private key")
+	}
+	return ParsePrivateKeyFromDER(block.Bytes)
+}
+
 // CreateKey creates a new encryption key and returns as a crypto.Signer and DER encoded form.
 func CreateKey() (crypto.Signer, []byte, error) {
 	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
@@ -54,6 +90,38 @@ func CreateKey() (crypto.Signer, []byte, error) {
 	return key, der, nil
 }
 
+// ParseCertificateRequest parses a PEM or DER encoded certificate request and checks its signature.
+func ParseCertificateRequest(data []byte) (*x509.CertificateRequest, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, logger.Error("PEM block is not a certificate request", golog.String("type", block.Type))
+		}
+		data = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(data)
+	if err != nil {
+		return nil, logger.Errorex("failed to parse certificate request", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, logger.Errorex("invalid certificate request signature", err)
+	}
+	return csr, nil
+}
+
+// KeyType describes the type and size of a public key, e.g. "ECDSA P-256" or "RSA 2048".
+func KeyType(pub crypto.PublicKey) string {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + pub.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return "unknown"
+	}
+}
+
 // Thumbprint gets the string thumbprint for a certificate.
 func Thumbprint(der []byte) string {
 	thumbprint := sha1.Sum(der)
@@ -0,0 +1,63 @@
+package cryptutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/stugotech/golog"
+	"golang.org/x/crypto/ocsp"
+)
+
+// OCSP statuses returned by GetOCSPStatus
+const (
+	OCSPGood    = "good"
+	OCSPRevoked = "revoked"
+	OCSPUnknown = "unknown"
+)
+
+// GetOCSPStatus asks the certificate's OCSP responder whether it has been revoked.
+func GetOCSPStatus(ctx context.Context, leaf *x509.Certificate, issuer *x509.Certificate) (string, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return "", logger.Error("certificate has no OCSP server")
+	}
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return "", logger.Errorex("unable to create OCSP request", err)
+	}
+
+	httpReq, err := http.NewRequest("POST", leaf.OCSPServer[0], bytes.NewReader(req))
+	if err != nil {
+		return "", logger.Errore(err)
+	}
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+
+	httpResp, err := http.DefaultClient.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return "", logger.Errorex("OCSP request failed", err, golog.String("server", leaf.OCSPServer[0]))
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return "", logger.Error("OCSP server returned error", golog.Int("status", httpResp.StatusCode))
+	}
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return "", logger.Errore(err)
+	}
+	resp, err := ocsp.ParseResponseForCert(body, leaf, issuer)
+	if err != nil {
+		return "", logger.Errorex("unable to parse OCSP response", err)
+	}
+
+	switch resp.Status {
+	case ocsp.Good:
+		return OCSPGood, nil
+	case ocsp.Revoked:
+		return OCSPRevoked, nil
+	default:
+		return OCSPUnknown, nil
+	}
+}
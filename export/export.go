@@ -0,0 +1,242 @@
+package export
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/stugotech/coyote/acmelib"
+	"github.com/stugotech/coyote/store"
+	"github.com/stugotech/golog"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+var logger = golog.NewPackageLogger()
+
+// Export formats
+const (
+	// FormatPEM writes PEM encoded fullchain, leaf, chain and key files
+	FormatPEM = "pem"
+	// FormatDER writes DER encoded leaf, chain and PKCS#8 key files, with one file per issuer
+	FormatDER = "der"
+	// FormatPKCS12 writes a PKCS#12 bundle using modern encryption
+	FormatPKCS12 = "pkcs12"
+	// FormatJKSCompatible writes a PKCS#12 bundle using the legacy encryption that older Java
+	// keytool and keystore implementations can read
+	FormatJKSCompatible = "jks-compatible"
+)
+
+// Default file permissions
+const (
+	DefaultFileMode os.FileMode = 0644
+	DefaultKeyMode  os.FileMode = 0600
+)
+
+// FileNames are the names of the files written for a certificate.  Empty names use the default for
+// the format.
+type FileNames struct {
+	FullChain string
+	Leaf      string
+	Chain     string
+	Key       string
+	Bundle    string
+}
+
+// Options describes how to export a certificate
+type Options struct {
+	Dir    string
+	Format string
+	Names  FileNames
+	// FileMode is the permissions of files which don't contain the private key
+	FileMode os.FileMode
+	// KeyMode is the permissions of the key file and PKCS#12 bundles
+	KeyMode os.FileMode
+	// Passphrase protects PKCS#12 bundles
+	Passphrase string
+}
+
+// DefaultFileNames gets the default names of the files written for the given format
+func DefaultFileNames(format string) FileNames {
+	switch format {
+	case FormatDER:
+		return FileNames{Leaf: "cert.der", Chain: "chain.der", Key: "privkey.der"}
+	case FormatPKCS12, FormatJKSCompatible:
+		return FileNames{Bundle: "certificate.p12"}
+	default:
+		return FileNames{FullChain: "fullchain.pem", Leaf: "cert.pem", Chain: "chain.pem", Key: "privkey.pem"}
+	}
+}
+
+// Certificate writes the certificate to files in the given format, and returns the paths of the
+// files written
+func Certificate(cert *store.Certificate, options *Options) ([]string, error) {
+	bundle, err := acmelib.NewCertificateBundleFromPEM(cert.CertificateChain, cert.PrivateKey)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	files, err := encode(bundle, options)
+	if err != nil {
+		return nil, logger.Errorex("unable to export certificate", err, golog.String("domain", cert.Domain))
+	}
+	if len(bundle.PrivateKey) == 0 {
+		logger.Info("certificate has no private key, so key file was not written", golog.String("domain", cert.Domain))
+	}
+
+	if err := os.MkdirAll(options.Dir, 0755); err != nil {
+		return nil, logger.Errore(err)
+	}
+	var paths []string
+	for _, f := range files {
+		path := filepath.Join(options.Dir, f.name)
+		if err := WriteFile(path, f.data, f.mode); err != nil {
+			return paths, logger.Errore(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// WriteFile writes data to a temporary file and renames it over path, so that readers never see a
+// partly written file
+func WriteFile(path string, data []byte, mode os.FileMode) error {
+	tmp, err := StageFile(path, data, mode)
+	if err != nil {
+		return logger.Errore(err)
+	}
+	defer os.Remove(tmp)
+
+	if err := os.Rename(tmp, path); err != nil {
+		return logger.Errorex("unable to write file", err, golog.String("path", path))
+	}
+	return nil
+}
+
+// StageFile writes data to a temporary file alongside path and returns its name, so that several
+// files can be written before any of them is renamed into place.  The caller removes the temporary
+// file if it isn't renamed.
+func StageFile(path string, data []byte, mode os.FileMode) (string, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return "", logger.Errore(err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", logger.Errorex("unable to write file", err, golog.String("path", path))
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", logger.Errore(err)
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		os.Remove(tmp.Name())
+		return "", logger.Errore(err)
+	}
+	return tmp.Name(), nil
+}
+
+// file is the contents of a file to write
+type file struct {
+	name string
+	data []byte
+	mode os.FileMode
+}
+
+// encode encodes the bundle in the files for the export format
+func encode(bundle *acmelib.CertificateBundle, options *Options) ([]*file, error) {
+	names := fileNames(options)
+	fileMode := options.FileMode
+	if fileMode == 0 {
+		fileMode = DefaultFileMode
+	}
+	keyMode := options.KeyMode
+	if keyMode == 0 {
+		keyMode = DefaultKeyMode
+	}
+
+	var files []*file
+	switch options.Format {
+	case FormatPEM, "":
+		files = append(files,
+			&file{names.FullChain, bundle.CertificatesPEM(), fileMode},
+			&file{names.Leaf, bundle.LeafPEM(), fileMode},
+			&file{names.Chain, bundle.ChainPEM(), fileMode},
+		)
+		if len(bundle.PrivateKey) > 0 {
+			files = append(files, &file{names.Key, bundle.PrivateKeyPEM(), keyMode})
+		}
+
+	case FormatDER:
+		files = append(files, &file{names.Leaf, bundle.CertificatesRaw[0], fileMode})
+		for i, der := range bundle.CertificatesRaw[1:] {
+			files = append(files, &file{indexedName(names.Chain, i+1), der, fileMode})
+		}
+		if len(bundle.PrivateKey) > 0 {
+			key, err := bundle.Signer()
+			if err != nil {
+				return nil, logger.Errore(err)
+			}
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				return nil, logger.Errore(err)
+			}
+			files = append(files, &file{names.Key, der, keyMode})
+		}
+
+	case FormatPKCS12, FormatJKSCompatible:
+		if options.Passphrase == "" {
+			return nil, logger.Error("a passphrase is required for PKCS#12 bundles")
+		}
+		key, err := bundle.Signer()
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+		if key == nil {
+			return nil, logger.Error("a PKCS#12 bundle can't be created without the private key")
+		}
+		encoder := pkcs12.Modern
+		if options.Format == FormatJKSCompatible {
+			encoder = pkcs12.LegacyDES
+		}
+		data, err := encoder.Encode(key, bundle.Certificates[0], bundle.Certificates[1:], options.Passphrase)
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+		files = append(files, &file{names.Bundle, data, keyMode})
+
+	default:
+		return nil, logger.Error("unknown export format", golog.String("format", options.Format))
+	}
+	return files, nil
+}
+
+// fileNames gets the file names from the options, using the format defaults for any not given
+func fileNames(options *Options) FileNames {
+	names := options.Names
+	defaults := DefaultFileNames(options.Format)
+	if names.FullChain == "" {
+		names.FullChain = defaults.FullChain
+	}
+	if names.Leaf == "" {
+		names.Leaf = defaults.Leaf
+	}
+	if names.Chain == "" {
+		names.Chain = defaults.Chain
+	}
+	if names.Key == "" {
+		names.Key = defaults.Key
+	}
+	if names.Bundle == "" {
+		names.Bundle = defaults.Bundle
+	}
+	return names
+}
+
+// indexedName inserts an index before the extension of a file name, e.g. chain-1.der
+func indexedName(name string, i int) string {
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), i, ext)
+}
@@ -0,0 +1,225 @@
+package export
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stugotech/coyote/store"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestCertificateFormats(t *testing.T) {
+	cert, leaf, issuer := newTestCertificate(t, "example.com")
+
+	tests := []struct {
+		format string
+		// files are the names of the files expected, and their permissions
+		files map[string]os.FileMode
+	}{
+		{FormatPEM, map[string]os.FileMode{"fullchain.pem": 0644, "cert.pem": 0644, "chain.pem": 0644, "privkey.pem": 0600}},
+		{FormatDER, map[string]os.FileMode{"cert.der": 0644, "chain-1.der": 0644, "privkey.der": 0600}},
+		{FormatPKCS12, map[string]os.FileMode{"certificate.p12": 0600}},
+		{FormatJKSCompatible, map[string]os.FileMode{"certificate.p12": 0600}},
+	}
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "export")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		paths, err := Certificate(cert, &Options{Dir: dir, Format: test.format, Passphrase: "secret"})
+		if err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		if len(paths) != len(test.files) {
+			t.Errorf("%s: got files %v, want %d", test.format, paths, len(test.files))
+		}
+		for name, mode := range test.files {
+			info, err := os.Stat(filepath.Join(dir, name))
+			if err != nil {
+				t.Errorf("%s: %v", test.format, err)
+				continue
+			}
+			if info.Mode().Perm() != mode {
+				t.Errorf("%s: got %s mode %v, want %v", test.format, name, info.Mode().Perm(), mode)
+			}
+		}
+
+		switch test.format {
+		case FormatPEM:
+			fullchain := readFile(t, dir, "fullchain.pem")
+			if !bytes.Equal(fullchain, cert.CertificateChain) {
+				t.Errorf("%s: got full chain which differs from the certificate's", test.format)
+			}
+		case FormatDER:
+			if !bytes.Equal(readFile(t, dir, "cert.der"), leaf.Raw) || !bytes.Equal(readFile(t, dir, "chain-1.der"), issuer.Raw) {
+				t.Errorf("%s: got certificates which differ from the chain", test.format)
+			}
+			if _, err := x509.ParsePKCS8PrivateKey(readFile(t, dir, "privkey.der")); err != nil {
+				t.Errorf("%s: unable to parse key: %v", test.format, err)
+			}
+		default:
+			key, got, ca, err := pkcs12.DecodeChain(readFile(t, dir, "certificate.p12"), "secret")
+			if err != nil {
+				t.Errorf("%s: %v", test.format, err)
+				continue
+			}
+			if key == nil || !got.Equal(leaf) || len(ca) != 1 || !ca[0].Equal(issuer) {
+				t.Errorf("%s: got bundle which differs from the certificate", test.format)
+			}
+		}
+	}
+}
+
+func TestCertificateErrors(t *testing.T) {
+	cert, _, _ := newTestCertificate(t, "example.com")
+	keyless := *cert
+	keyless.PrivateKey = nil
+
+	tests := []struct {
+		name    string
+		cert    *store.Certificate
+		options Options
+	}{
+		{"unknown format", cert, Options{Format: "jks"}},
+		{"no passphrase", cert, Options{Format: FormatPKCS12}},
+		{"no key", &keyless, Options{Format: FormatPKCS12, Passphrase: "secret"}},
+	}
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "export")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		test.options.Dir = dir
+		if _, err := Certificate(test.cert, &test.options); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
+
+func TestCertificateWithoutKey(t *testing.T) {
+	cert, _, _ := newTestCertificate(t, "example.com")
+	cert.PrivateKey = nil
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	names := FileNames{FullChain: "example.com.pem"}
+	paths, err := Certificate(cert, &Options{Dir: dir, Names: names})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 3 {
+		t.Errorf("got files %v, want the certificates without a key", paths)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "example.com.pem")); err != nil {
+		t.Errorf("full chain wasn't written with the given name: %v", err)
+	}
+}
+
+func TestWriteFileReplaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "file")
+
+	for _, data := range []string{"first", "second"} {
+		if err := WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if got := readFile(t, dir, "file"); string(got) != data {
+			t.Errorf("got %q, want %q", got, data)
+		}
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got %d files, want the temporary files removed", len(files))
+	}
+}
+
+// readFile reads a file from the directory
+func readFile(t *testing.T, dir string, name string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// newTestCertificate creates a certificate for the domain issued by a test CA
+func newTestCertificate(t *testing.T, domain string) (*store.Certificate, *x509.Certificate, *x509.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})...)
+	return &store.Certificate{
+		Domain:           domain,
+		CertificateChain: chain,
+		PrivateKey:       pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}, leaf, issuer
+}
@@ -0,0 +1,136 @@
+package sds
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	secretservice "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	xds "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"github.com/stugotech/coyote/store"
+	"github.com/stugotech/golog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+var logger = golog.NewPackageLogger()
+
+// Server describes an Envoy Secret Discovery Service server
+type Server interface {
+	Listen() error
+}
+
+type sdsServer struct {
+	store     store.Store
+	listen    string
+	tlsConfig *tls.Config
+}
+
+// NewServer creates a server which serves the certificates in the store to Envoy as TLS secrets
+// named after the certificate domain.  The listen address is either host:port or unix:<path>.  If
+// tlsConfig is nil, the server doesn't use TLS, which should only be used with a unix socket.
+func NewServer(st store.Store, listen string, tlsConfig *tls.Config) Server {
+	logger.Info("creating new SDS server", golog.String("listen", listen))
+	return &sdsServer{
+		store:     st,
+		listen:    listen,
+		tlsConfig: tlsConfig,
+	}
+}
+
+// Listen serves secrets until the watch on the store fails, pushing changes in the store to
+// connected Envoys as they happen
+func (s *sdsServer) Listen() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	secrets := cache.NewLinearCache(resource.SecretType)
+	events, err := s.store.WatchCertificates(ctx)
+	if err != nil {
+		return logger.Errore(err)
+	}
+
+	var options []grpc.ServerOption
+	if s.tlsConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(s.tlsConfig)))
+	}
+	grpcServer := grpc.NewServer(options...)
+	secretservice.RegisterSecretDiscoveryServiceServer(grpcServer, xds.NewServer(ctx, secrets, nil))
+
+	network, address := "tcp", s.listen
+	if strings.HasPrefix(s.listen, "unix:") {
+		network, address = "unix", strings.TrimPrefix(s.listen, "unix:")
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return logger.Errore(err)
+	}
+
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- s.watch(events, secrets)
+		grpcServer.Stop()
+	}()
+
+	logger.Info("SDS server listening", golog.String("interface", s.listen))
+	if err := grpcServer.Serve(listener); err != nil {
+		return logger.Errore(err)
+	}
+	return logger.Errore(<-watchErr)
+}
+
+// watch updates the secrets as certificates change in the store, until the watch ends
+func (s *sdsServer) watch(events <-chan *store.CertificateEvent, secrets *cache.LinearCache) error {
+	for event := range events {
+		switch event.Type {
+		case store.CertificateUpdated:
+			if len(event.Certificate.PrivateKey) == 0 {
+				// the key is held by whoever supplied the CSR, so the certificate can't be served; remove
+				// any secret for an earlier certificate so that its key isn't served in its place
+				logger.Info("not serving certificate without private key", golog.String("domain", event.Domain))
+				if err := secrets.DeleteResource(event.Domain); err != nil {
+					logger.Errorex("unable to remove secret", err, golog.String("domain", event.Domain))
+				}
+				continue
+			}
+			logger.Info("updating secret",
+				golog.String("domain", event.Domain),
+				golog.String("thumbprint", event.Certificate.Thumbprint),
+			)
+			if err := secrets.UpdateResource(event.Domain, newSecret(event.Certificate)); err != nil {
+				logger.Errorex("unable to update secret", err, golog.String("domain", event.Domain))
+			}
+		case store.CertificateDeleted:
+			logger.Info("removing secret", golog.String("domain", event.Domain))
+			if err := secrets.DeleteResource(event.Domain); err != nil {
+				logger.Errorex("unable to remove secret", err, golog.String("domain", event.Domain))
+			}
+		}
+	}
+	return logger.Error("watch on store ended unexpectedly")
+}
+
+// newSecret creates the Envoy TLS certificate secret for a certificate
+func newSecret(cert *store.Certificate) *tlsv3.Secret {
+	return &tlsv3.Secret{
+		Name: cert.Domain,
+		Type: &tlsv3.Secret_TlsCertificate{
+			TlsCertificate: &tlsv3.TlsCertificate{
+				CertificateChain: inlineBytes(cert.CertificateChain),
+				PrivateKey:       inlineBytes(cert.PrivateKey),
+			},
+		},
+	}
+}
+
+// inlineBytes creates an Envoy data source containing the data
+func inlineBytes(data []byte) *corev3.DataSource {
+	return &corev3.DataSource{
+		Specifier: &corev3.DataSource_InlineBytes{InlineBytes: data},
+	}
+}
@@ -0,0 +1,174 @@
+package sds
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	secretservice "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/stugotech/coyote/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// watchStore sends the events it is given to the watch
+type watchStore struct {
+	store.Store
+	events chan *store.CertificateEvent
+}
+
+func (s *watchStore) WatchCertificates(ctx context.Context) (<-chan *store.CertificateEvent, error) {
+	return s.events, nil
+}
+
+func TestServerStreamsSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "sds.sock")
+
+	st := &watchStore{events: make(chan *store.CertificateEvent)}
+	done := make(chan error, 1)
+	go func() { done <- NewServer(st, "unix:"+socket, nil).Listen() }()
+
+	cert := &store.Certificate{
+		Domain:           "example.com",
+		CertificateChain: []byte("chain"),
+		PrivateKey:       []byte("key"),
+		Thumbprint:       "a",
+	}
+	st.events <- &store.CertificateEvent{Type: store.CertificateUpdated, Domain: cert.Domain, Certificate: cert}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, "unix:"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	stream, err := secretservice.NewSecretDiscoveryServiceClient(conn).StreamSecrets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := &discoveryv3.DiscoveryRequest{
+		Node:          &corev3.Node{Id: "envoy"},
+		TypeUrl:       resource.SecretType,
+		ResourceNames: []string{"example.com"},
+	}
+	if err := stream.Send(request); err != nil {
+		t.Fatal(err)
+	}
+	secrets := receiveSecrets(t, stream)
+	if len(secrets) != 1 {
+		t.Fatalf("got %d secrets, want 1", len(secrets))
+	}
+	tlsCert := secrets[0].GetTlsCertificate()
+	if secrets[0].Name != "example.com" || !bytes.Equal(tlsCert.GetCertificateChain().GetInlineBytes(), cert.CertificateChain) ||
+		!bytes.Equal(tlsCert.GetPrivateKey().GetInlineBytes(), cert.PrivateKey) {
+		t.Errorf("got secret %v, want the certificate", secrets[0])
+	}
+
+	// the server stops when the watch on the store ends
+	close(st.events)
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("expected error when the watch ends")
+		}
+	case <-ctx.Done():
+		t.Fatal("server didn't stop when the watch ended")
+	}
+}
+
+func TestWatchUpdatesSecrets(t *testing.T) {
+	withKey := func(domain string, thumbprint string) *store.Certificate {
+		return &store.Certificate{Domain: domain, CertificateChain: []byte(thumbprint), PrivateKey: []byte("key"), Thumbprint: thumbprint}
+	}
+	tests := []struct {
+		name  string
+		event *store.CertificateEvent
+		// want is the thumbprint of the secret served for each domain
+		want map[string]string
+	}{
+		{
+			"new certificate",
+			&store.CertificateEvent{Type: store.CertificateUpdated, Domain: "example.com", Certificate: withKey("example.com", "a")},
+			map[string]string{"example.com": "a"},
+		},
+		{
+			"another certificate",
+			&store.CertificateEvent{Type: store.CertificateUpdated, Domain: "example.org", Certificate: withKey("example.org", "b")},
+			map[string]string{"example.com": "a", "example.org": "b"},
+		},
+		{
+			"renewed certificate",
+			&store.CertificateEvent{Type: store.CertificateUpdated, Domain: "example.com", Certificate: withKey("example.com", "c")},
+			map[string]string{"example.com": "c", "example.org": "b"},
+		},
+		{
+			"certificate without key",
+			&store.CertificateEvent{Type: store.CertificateUpdated, Domain: "example.com", Certificate: &store.Certificate{Domain: "example.com", CertificateChain: []byte("d"), Thumbprint: "d"}},
+			map[string]string{"example.org": "b"},
+		},
+		{
+			"deleted certificate",
+			&store.CertificateEvent{Type: store.CertificateDeleted, Domain: "example.org"},
+			map[string]string{},
+		},
+	}
+
+	secrets := cache.NewLinearCache(resource.SecretType)
+	events := make(chan *store.CertificateEvent)
+	done := make(chan error, 1)
+	go func() { done <- (&sdsServer{}).watch(events, secrets) }()
+
+	for _, test := range tests {
+		events <- test.event
+		// an unbuffered send only waits for the event to be received, so send another which has no
+		// effect to wait for it to be handled
+		events <- &store.CertificateEvent{Type: store.CertificateDeleted, Domain: "unknown.example.com"}
+
+		got := make(map[string]string)
+		for name, res := range secrets.GetResources() {
+			got[name] = string(res.(*tlsv3.Secret).GetTlsCertificate().GetCertificateChain().GetInlineBytes())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got secrets %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	close(events)
+	if err := <-done; err == nil {
+		t.Error("expected error when the watch ends")
+	}
+}
+
+// receiveSecrets receives the next response from the stream and decodes its secrets
+func receiveSecrets(t *testing.T, stream secretservice.SecretDiscoveryService_StreamSecretsClient) []*tlsv3.Secret {
+	response, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var secrets []*tlsv3.Secret
+	for _, any := range response.Resources {
+		secret := &tlsv3.Secret{}
+		if err := any.UnmarshalTo(secret); err != nil {
+			t.Fatal(err)
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets
+}
@@ -0,0 +1,166 @@
+package secret
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/stugotech/golog"
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	saltLength  = 16
+	kdfArgon2id = "argon2id"
+)
+
+// Limits on the key derivation parameters read from sealed values, so that a corrupt or hostile
+// record can't make opening it use unbounded memory or time.  Memory is in KiB, and is capped at
+// four times the default.
+const (
+	MaxKDFTime    = 10
+	MaxKDFMemory  = 256 * 1024
+	MaxKDFThreads = 16
+)
+
+// maxCachedKeys limits how many keys derived for parameters other than the box's own are kept, as
+// the parameters come from sealed values in the shared store
+const maxCachedKeys = 8
+
+// KDFParams describes how a seal key was derived from a passphrase.  The parameters are recorded
+// alongside each sealed value so that they can be raised later without breaking old values.
+type KDFParams struct {
+	Name    string
+	Salt    []byte
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// DefaultKDFParams are the key derivation parameters used for newly sealed values.  Salt is
+// ignored and replaced with the salt given to NewBoxFromPassphrase.
+var DefaultKDFParams = KDFParams{
+	Name:    kdfArgon2id,
+	Time:    1,
+	Memory:  64 * 1024,
+	Threads: 4,
+}
+
+// passphraseBox is an implementation of Box which derives its key from a passphrase.
+type passphraseBox struct {
+	passphrase []byte
+	params     KDFParams
+	key        *[keyLength]byte
+
+	mutex sync.Mutex
+	keys  map[string]*[keyLength]byte
+}
+
+// NewSalt generates a new random salt for use with NewBoxFromPassphrase.
+func NewSalt() ([]byte, error) {
+	salt := make([]byte, saltLength)
+	_, err := io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return nil, logger.Errorex("unable to generate random string", err)
+	}
+	return salt, nil
+}
+
+// NewBoxFromPassphrase creates a secret box with a key derived from the given passphrase and salt.
+// Values are sealed using DefaultKDFParams; values sealed with other parameters can still be opened.
+func NewBoxFromPassphrase(passphrase string, salt []byte) (Box, error) {
+	if passphrase == "" {
+		return nil, logger.Error("must specify passphrase")
+	}
+	if len(salt) < saltLength {
+		return nil, logger.Error("salt too short", golog.Int("length", len(salt)))
+	}
+
+	params := DefaultKDFParams
+	params.Salt = salt
+
+	b := &passphraseBox{
+		passphrase: []byte(passphrase),
+		params:     params,
+		keys:       make(map[string]*[keyLength]byte),
+	}
+
+	key, err := b.deriveKey(&params)
+	if err != nil {
+		return nil, err
+	}
+	b.key = key
+	return b, nil
+}
+
+// Seal encrypts a value
+func (b *passphraseBox) Seal(value []byte) ([]byte, error) {
+	sealed, err := sealBytes(value, b.key)
+	if err != nil {
+		return nil, err
+	}
+	return sealedValueToJSON(sealed, &b.params)
+}
+
+// Open decrypts a value
+func (b *passphraseBox) Open(value []byte) ([]byte, error) {
+	e, err := sealedValueFromJSON(value)
+	if err != nil {
+		return nil, err
+	}
+	if e.KDF == nil {
+		return nil, logger.Error("value was not sealed with a passphrase")
+	}
+	key, err := b.deriveKey(e.KDF)
+	if err != nil {
+		return nil, err
+	}
+	return openBytes(&e.Value, key)
+}
+
+// deriveKey derives the key for the given parameters, caching the result.
+func (b *passphraseBox) deriveKey(params *KDFParams) (*[keyLength]byte, error) {
+	if params.Name != kdfArgon2id {
+		return nil, logger.Error("unsupported key derivation function", golog.String("kdf", params.Name))
+	}
+	if params.Time == 0 || params.Memory == 0 || params.Threads == 0 {
+		return nil, logger.Error("invalid key derivation parameters")
+	}
+	if params.Time > MaxKDFTime || params.Memory > MaxKDFMemory || params.Threads > MaxKDFThreads {
+		return nil, logger.Error("key derivation parameters exceed limits",
+			golog.Int("time", int(params.Time)),
+			golog.Int("memory", int(params.Memory)),
+			golog.Int("threads", int(params.Threads)),
+		)
+	}
+
+	id := kdfID(params)
+	if b.key != nil && id == kdfID(&b.params) {
+		return b.key, nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if key, ok := b.keys[id]; ok {
+		return key, nil
+	}
+
+	bytes := argon2.IDKey(b.passphrase, params.Salt, params.Time, params.Memory, params.Threads, keyLength)
+	key, err := decodeKey(bytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.keys) >= maxCachedKeys {
+		// values sealed with other parameters are rare, so start again rather than track usage
+		b.keys = make(map[string]*[keyLength]byte)
+	}
+	b.keys[id] = key
+	return key, nil
+}
+
+// kdfID identifies the key derived with the parameters
+func kdfID(params *KDFParams) string {
+	return fmt.Sprintf("%s:%x:%d:%d:%d", params.Name, params.Salt, params.Time, params.Memory, params.Threads)
+}
@@ -0,0 +1,143 @@
+package secret
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPassphraseBoxSealAndOpen(t *testing.T) {
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	box, err := NewBoxFromPassphrase("correct horse", salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := box.Seal([]byte("secret value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err := box.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, []byte("secret value")) {
+		t.Errorf("got %q, want the sealed value", opened)
+	}
+
+	wrong, err := NewBoxFromPassphrase("battery staple", salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrong.Open(sealed); err == nil {
+		t.Error("expected error opening with the wrong passphrase")
+	}
+
+	keyBox, err := NewBoxFromKeyString(mustNewKeyString(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keySealed, err := keyBox.Seal([]byte("secret value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := box.Open(keySealed); err == nil {
+		t.Error("expected error opening a value sealed without a passphrase")
+	}
+}
+
+func TestPassphraseBoxOpensOtherParameters(t *testing.T) {
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	box, err := NewBoxFromPassphrase("correct horse", salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := box.(*passphraseBox)
+
+	tests := []struct {
+		name   string
+		params KDFParams
+		ok     bool
+	}{
+		{"lower memory", KDFParams{Name: kdfArgon2id, Time: 1, Memory: 8 * 1024, Threads: 1}, true},
+		{"maximum", KDFParams{Name: kdfArgon2id, Time: 1, Memory: 8 * 1024, Threads: MaxKDFThreads}, true},
+		{"unknown kdf", KDFParams{Name: "scrypt", Time: 1, Memory: 8 * 1024, Threads: 1}, false},
+		{"zero time", KDFParams{Name: kdfArgon2id, Time: 0, Memory: 8 * 1024, Threads: 1}, false},
+		{"too much time", KDFParams{Name: kdfArgon2id, Time: MaxKDFTime + 1, Memory: 8 * 1024, Threads: 1}, false},
+		{"too much memory", KDFParams{Name: kdfArgon2id, Time: 1, Memory: MaxKDFMemory + 1, Threads: 1}, false},
+		{"too many threads", KDFParams{Name: kdfArgon2id, Time: 1, Memory: 8 * 1024, Threads: MaxKDFThreads + 1}, false},
+	}
+	for _, test := range tests {
+		params := test.params
+		params.Salt = salt
+		// parameters which can't be opened are sealed with any key
+		key, err := decodeKey(make([]byte, keyLength))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.ok {
+			other := &passphraseBox{passphrase: []byte("correct horse"), keys: make(map[string]*[keyLength]byte)}
+			if key, err = other.deriveKey(&params); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sealed, err := sealBytes([]byte("secret value"), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := sealedValueToJSON(sealed, &params)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opened, err := b.Open(value)
+		if test.ok && (err != nil || !bytes.Equal(opened, []byte("secret value"))) {
+			t.Errorf("%s: got error %v, want the value opened", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+	if len(b.keys) > maxCachedKeys {
+		t.Errorf("got %d cached keys, want at most %d", len(b.keys), maxCachedKeys)
+	}
+}
+
+func TestPassphraseBoxKeyCacheIsBounded(t *testing.T) {
+	salt, err := NewSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	box, err := NewBoxFromPassphrase("correct horse", salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := box.(*passphraseBox)
+	for i := 0; i < maxCachedKeys*2; i++ {
+		params := KDFParams{Name: kdfArgon2id, Salt: salt, Time: 1, Memory: uint32(8*1024 + i), Threads: 1}
+		if _, err := b.deriveKey(&params); err != nil {
+			t.Fatal(err)
+		}
+		if len(b.keys) > maxCachedKeys {
+			t.Fatalf("got %d cached keys, want at most %d", len(b.keys), maxCachedKeys)
+		}
+	}
+	// the box's own key isn't derived again
+	params := b.params
+	if key, err := b.deriveKey(&params); err != nil || key != b.key {
+		t.Errorf("got key %p and error %v, want the box's key", key, err)
+	}
+}
+
+func mustNewKeyString(t *testing.T) string {
+	key, err := NewKeyString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
@@ -42,6 +42,7 @@ type sealedBytes struct {
 type sealedValue struct {
 	Encryption string
 	Value      sealedBytes
+	KDF        *KDFParams `json:",omitempty"`
 }
 
 // NewKeyString generates a new seal key in string format.
@@ -78,20 +79,11 @@ func NewBox(bytes *[keyLength]byte) (Box, error) {
 
 // Seal encrypts a value
 func (b *box) Seal(value []byte) ([]byte, error) {
-	var nonce [nonceLength]byte
-	_, err := io.ReadFull(rand.Reader, nonce[:])
+	sealed, err := sealBytes(value, b.key)
 	if err != nil {
-		return nil, logger.Errorex("unable to generate random string", err)
-	}
-	var encrypted []byte
-	encrypted = secretbox.Seal(encrypted[:0], value, &nonce, b.key)
-
-	sealed := &sealedBytes{
-		Val:   encrypted,
-		Nonce: nonce[:],
+		return nil, err
 	}
-
-	return sealedValueToJSON(sealed)
+	return sealedValueToJSON(sealed, nil)
 }
 
 // Open decrypts a value
@@ -100,14 +92,34 @@ func (b *box) Open(value []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	return openBytes(&e.Value, b.key)
+}
 
+// sealBytes encrypts a value with the given key.
+func sealBytes(value []byte, key *[keyLength]byte) (*sealedBytes, error) {
+	var nonce [nonceLength]byte
+	_, err := io.ReadFull(rand.Reader, nonce[:])
+	if err != nil {
+		return nil, logger.Errorex("unable to generate random string", err)
+	}
+	var encrypted []byte
+	encrypted = secretbox.Seal(encrypted[:0], value, &nonce, key)
+
+	return &sealedBytes{
+		Val:   encrypted,
+		Nonce: nonce[:],
+	}, nil
+}
+
+// openBytes decrypts a sealed value with the given key.
+func openBytes(e *sealedBytes, key *[keyLength]byte) ([]byte, error) {
 	nonce, err := decodeNonce(e.Nonce)
 	if err != nil {
 		return nil, err
 	}
 	var decrypted []byte
 	var ok bool
-	decrypted, ok = secretbox.Open(decrypted[:0], e.Val, nonce, b.key)
+	decrypted, ok = secretbox.Open(decrypted[:0], e.Val, nonce, key)
 	if !ok {
 		return nil, logger.Error("unable to decrypt message")
 	}
@@ -115,24 +127,28 @@ func (b *box) Open(value []byte) ([]byte, error) {
 }
 
 // sealedValueToJSON converts a sealed value to a JSON representation.
-func sealedValueToJSON(b *sealedBytes) ([]byte, error) {
+func sealedValueToJSON(b *sealedBytes, kdf *KDFParams) ([]byte, error) {
 	data := &sealedValue{
 		Encryption: encryptionSecretBox,
 		Value:      *b,
+		KDF:        kdf,
 	}
 	return json.Marshal(&data)
 }
 
 // sealedValueFromJSON converts a JSON representation to a sealed value.
-func sealedValueFromJSON(bytes []byte) (*sealedBytes, error) {
+func sealedValueFromJSON(bytes []byte) (*sealedValue, error) {
 	var v *sealedValue
 	if err := json.Unmarshal(bytes, &v); err != nil {
 		return nil, err
 	}
+	if v == nil {
+		return nil, logger.Error("no sealed value found")
+	}
 	if v.Encryption != encryptionSecretBox {
 		return nil, logger.Error("unsupported encryption type", golog.String("type", v.Encryption))
 	}
-	return &v.Value, nil
+	return v, nil
 }
 
 func decodeNonce(bytes []byte) (*[nonceLength]byte, error) {
@@ -103,13 +103,16 @@ func challengeHandler(s *serverInfo, response http.ResponseWriter, request *http
 		http.NotFound(response, request)
 		return
 	}
+	if challenge == nil {
+		logger.Debug("no challenge found", golog.String("key", key))
+		http.NotFound(response, request)
+		return
+	}
 
+	// the challenge may be requested several times by the CA's validation servers, so it is left in
+	// the store until coyote removes it after authorization has completed
+	response.Header().Set("Content-Type", "text/plain")
 	response.Write([]byte(challenge.Value))
-
-	if err = s.store.DeleteChallenge(key); err != nil {
-		logger.Error("error deleting key", golog.String("key", key))
-		logger.Errore(err)
-	}
 }
 
 func (s *serverInfo) makeHandler(fn serverInfoHandler) http.HandlerFunc {
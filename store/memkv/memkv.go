@@ -0,0 +1,153 @@
+// Package memkv provides an in-memory libkv store for tests
+package memkv
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/libkv/store"
+)
+
+// Store is an in-memory libkv store with the same atomic semantics as the real backends.  Keys don't
+// expire; if NoTTL is set, writes with a TTL fail with ErrCallNotSupported, as on boltdb.
+type Store struct {
+	NoTTL bool
+
+	mutex sync.Mutex
+	index uint64
+	pairs map[string]*store.KVPair
+}
+
+// New creates an empty store
+func New() *Store {
+	return &Store{pairs: make(map[string]*store.KVPair)}
+}
+
+// Put writes a value
+func (m *Store) Put(key string, value []byte, options *store.WriteOptions) error {
+	if m.NoTTL && options != nil && options.TTL != 0 {
+		return store.ErrCallNotSupported
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.index++
+	m.pairs[key] = &store.KVPair{Key: key, Value: value, LastIndex: m.index}
+	return nil
+}
+
+// Get reads a value
+func (m *Store) Get(key string) (*store.KVPair, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	kv, ok := m.pairs[key]
+	if !ok {
+		return nil, store.ErrKeyNotFound
+	}
+	return kv, nil
+}
+
+// Delete removes a value
+func (m *Store) Delete(key string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.pairs[key]; !ok {
+		return store.ErrKeyNotFound
+	}
+	delete(m.pairs, key)
+	return nil
+}
+
+// Exists returns true if the key has a value
+func (m *Store) Exists(key string) (bool, error) {
+	_, err := m.Get(key)
+	return err == nil, nil
+}
+
+// Watch isn't supported
+func (m *Store) Watch(key string, stopCh <-chan struct{}) (<-chan *store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// WatchTree isn't supported
+func (m *Store) WatchTree(directory string, stopCh <-chan struct{}) (<-chan []*store.KVPair, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// NewLock isn't supported
+func (m *Store) NewLock(key string, options *store.LockOptions) (store.Locker, error) {
+	return nil, store.ErrCallNotSupported
+}
+
+// List reads the values under a directory
+func (m *Store) List(directory string) ([]*store.KVPair, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	var kvs []*store.KVPair
+	for key, kv := range m.pairs {
+		if strings.HasPrefix(key, directory+"/") {
+			kvs = append(kvs, kv)
+		}
+	}
+	if len(kvs) == 0 {
+		return nil, store.ErrKeyNotFound
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	return kvs, nil
+}
+
+// DeleteTree removes the values under a directory
+func (m *Store) DeleteTree(directory string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for key := range m.pairs {
+		if strings.HasPrefix(key, directory+"/") {
+			delete(m.pairs, key)
+		}
+	}
+	return nil
+}
+
+// AtomicPut writes a value if it hasn't changed since previous was read, or creates it if previous
+// is nil
+func (m *Store) AtomicPut(key string, value []byte, previous *store.KVPair, options *store.WriteOptions) (bool, *store.KVPair, error) {
+	if m.NoTTL && options != nil && options.TTL != 0 {
+		return false, nil, store.ErrCallNotSupported
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	current, ok := m.pairs[key]
+	switch {
+	case previous == nil && ok:
+		return false, nil, store.ErrKeyExists
+	case previous != nil && !ok:
+		return false, nil, store.ErrKeyNotFound
+	case previous != nil && previous.LastIndex != current.LastIndex:
+		return false, nil, store.ErrKeyModified
+	}
+	m.index++
+	kv := &store.KVPair{Key: key, Value: value, LastIndex: m.index}
+	m.pairs[key] = kv
+	return true, kv, nil
+}
+
+// AtomicDelete removes a value if it hasn't changed since previous was read
+func (m *Store) AtomicDelete(key string, previous *store.KVPair) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if previous == nil {
+		return false, store.ErrPreviousNotSpecified
+	}
+	current, ok := m.pairs[key]
+	if !ok {
+		return false, store.ErrKeyNotFound
+	}
+	if previous.LastIndex != current.LastIndex {
+		return false, store.ErrKeyModified
+	}
+	delete(m.pairs, key)
+	return true, nil
+}
+
+// Close does nothing
+func (m *Store) Close() {}
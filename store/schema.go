@@ -0,0 +1,271 @@
+package store
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/stugotech/coyote/cryptutil"
+	"github.com/stugotech/golog"
+)
+
+// SchemaVersion is the version of the store schema written by this version of coyote.
+const SchemaVersion = 2
+
+// Migration upgrades the store schema from Version-1 to Version.
+type Migration struct {
+	Version     int
+	Description string
+	Apply       func(s Store) error
+	// UpgradeRecord changes the data of a record written at Version-1 into its shape at Version, so
+	// that records which haven't been migrated yet can still be read.  value is what the record is
+	// being decoded into.  Optional.
+	UpgradeRecord func(data map[string]json.RawMessage, value interface{}) error
+}
+
+// record is the versioned envelope that accounts and certificates are stored in.
+type record struct {
+	SchemaVersion int
+	Data          json.RawMessage
+}
+
+// Backup describes the contents of a store at a point in time.
+type Backup struct {
+	SchemaVersion int
+	SealSalt      []byte
+	Accounts      []*Account
+	Certificates  []*Certificate
+}
+
+var migrations = make(map[int]*Migration)
+
+func init() {
+	RegisterMigration(&Migration{
+		Version:     1,
+		Description: "store accounts and certificates in versioned records",
+		Apply:       rewriteRecords,
+	})
+	RegisterMigration(&Migration{
+		Version:       2,
+		Description:   "record the issuer, serial number, validity and key type of certificates",
+		Apply:         rewriteRecords,
+		UpgradeRecord: setCertificateRecordDetails,
+	})
+}
+
+// RegisterMigration registers a migration to be run by Migrate.
+func RegisterMigration(m *Migration) {
+	if _, ok := migrations[m.Version]; ok {
+		panic("migration already registered for schema version")
+	}
+	migrations[m.Version] = m
+}
+
+// PendingMigrations gets the migrations that need to be applied to bring the store up to date.
+func PendingMigrations(s Store) ([]*Migration, error) {
+	version, err := s.GetSchemaVersion()
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	if version > SchemaVersion {
+		return nil, logger.Error("store schema is newer than this version of coyote",
+			golog.Int("storeVersion", version),
+			golog.Int("version", SchemaVersion),
+		)
+	}
+
+	var pending []*Migration
+	for _, m := range migrations {
+		if m.Version > version && m.Version <= SchemaVersion {
+			pending = append(pending, m)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+
+	for i, m := range pending {
+		if m.Version != version+i+1 {
+			return nil, logger.Error("missing migration for schema version", golog.Int("version", version+i+1))
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies the pending migrations in order, updating the schema version after each one.  If
+// dryRun is set, the pending migrations are returned without being applied.
+func Migrate(s Store, dryRun bool) ([]*Migration, error) {
+	pending, err := PendingMigrations(s)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	if dryRun {
+		return pending, nil
+	}
+
+	for i, m := range pending {
+		logger.Info("applying migration",
+			golog.Int("version", m.Version),
+			golog.String("description", m.Description),
+		)
+		if err := m.Apply(s); err != nil {
+			return pending[:i], logger.Errorex("migration failed", err, golog.Int("version", m.Version))
+		}
+		if err := s.PutSchemaVersion(m.Version); err != nil {
+			return pending[:i], logger.Errore(err)
+		}
+	}
+	return pending, nil
+}
+
+// NewBackup reads all accounts and certificates from the store.
+func NewBackup(s Store) (*Backup, error) {
+	version, err := s.GetSchemaVersion()
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	salt, err := s.GetSealSalt()
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	accounts, err := s.GetAccounts()
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	certs, err := s.GetCertificates()
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	return &Backup{
+		SchemaVersion: version,
+		SealSalt:      salt,
+		Accounts:      accounts,
+		Certificates:  certs,
+	}, nil
+}
+
+// WriteBackup writes the accounts and certificates in the store to w as JSON.  Certificate private
+// keys are written in plain text; use coyote.ExportStore for a sealed backup.
+func WriteBackup(s Store, w io.Writer) error {
+	backup, err := NewBackup(s)
+	if err != nil {
+		return logger.Errore(err)
+	}
+	if err := json.NewEncoder(w).Encode(backup); err != nil {
+		return logger.Errore(err)
+	}
+	return nil
+}
+
+// rewriteRecords reads and writes back every account and certificate, so that they are stored in
+// the current record format.
+func rewriteRecords(s Store) error {
+	accounts, err := s.GetAccounts()
+	if err != nil {
+		return logger.Errore(err)
+	}
+	for _, account := range accounts {
+		if err := s.PutAccount(account); err != nil {
+			return logger.Errore(err)
+		}
+	}
+
+	certs, err := s.GetCertificates()
+	if err != nil {
+		return logger.Errore(err)
+	}
+	for _, cert := range certs {
+		if err := s.PutCertificate(cert); err != nil {
+			return logger.Errore(err)
+		}
+	}
+	return nil
+}
+
+// setCertificateRecordDetails fills in the details of the leaf certificate in certificate records
+// written before they were recorded.  Records whose chain can't be parsed are left as they are.
+func setCertificateRecordDetails(data map[string]json.RawMessage, value interface{}) error {
+	if _, ok := value.(*Certificate); !ok {
+		return nil
+	}
+	bytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var cert Certificate
+	if err := json.Unmarshal(bytes, &cert); err != nil {
+		return err
+	}
+	if cert.Issuer != "" || len(cert.CertificateChain) == 0 {
+		return nil
+	}
+
+	chain, err := cryptutil.ParseCertificatesFromPEM(cert.CertificateChain)
+	if err != nil {
+		logger.Errorex("unable to read details of certificate", err, golog.String("domain", cert.Domain))
+		return nil
+	}
+	cert.SetLeafDetails(chain[0])
+
+	bytes, err = json.Marshal(&cert)
+	if err != nil {
+		return err
+	}
+	var upgraded map[string]json.RawMessage
+	if err := json.Unmarshal(bytes, &upgraded); err != nil {
+		return err
+	}
+	for key, value := range upgraded {
+		data[key] = value
+	}
+	return nil
+}
+
+// encodeRecord encodes a value in a versioned record.
+func encodeRecord(value interface{}) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&record{
+		SchemaVersion: SchemaVersion,
+		Data:          data,
+	})
+}
+
+// decodeRecord decodes a versioned record into value.  Values written before records were versioned
+// are decoded as version 0.  Records written at an older version are upgraded by the UpgradeRecord
+// hook of each migration since then.
+func decodeRecord(bytes []byte, value interface{}) error {
+	var r record
+	if err := json.Unmarshal(bytes, &r); err != nil {
+		return err
+	}
+	if r.SchemaVersion == 0 || len(r.Data) == 0 {
+		r.SchemaVersion = 0
+		r.Data = bytes
+	}
+	if r.SchemaVersion > SchemaVersion {
+		return logger.Error("record was written by a newer version of coyote", golog.Int("version", r.SchemaVersion))
+	}
+	if r.SchemaVersion == SchemaVersion {
+		return json.Unmarshal(r.Data, value)
+	}
+
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(r.Data, &data); err != nil {
+		return err
+	}
+	for version := r.SchemaVersion + 1; version <= SchemaVersion; version++ {
+		m, ok := migrations[version]
+		if !ok || m.UpgradeRecord == nil {
+			continue
+		}
+		if err := m.UpgradeRecord(data, value); err != nil {
+			return logger.Errorex("unable to upgrade record", err, golog.Int("version", version))
+		}
+	}
+	upgraded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(upgraded, value)
+}
@@ -0,0 +1,187 @@
+package store
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGetCertificateUpgradesOldRecords(t *testing.T) {
+	chain := newTestCertificatePEM(t, "example.com")
+	unversioned, err := json.Marshal(&Certificate{Domain: "example.com", CertificateChain: chain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	versioned, err := json.Marshal(&record{SchemaVersion: 1, Data: unversioned})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := encodeRecord(&Certificate{Domain: "example.com", CertificateChain: chain, Issuer: "Stored Issuer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer, err := json.Marshal(&record{SchemaVersion: SchemaVersion + 1, Data: unversioned})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		value  []byte
+		issuer string
+		ok     bool
+	}{
+		{"unversioned", unversioned, "Test Issuer", true},
+		{"version 1", versioned, "Test Issuer", true},
+		{"current", current, "Stored Issuer", true},
+		{"newer", newer, "", false},
+	}
+	for _, test := range tests {
+		st, kv := newTestStore(t)
+		if err := kv.Put("coyote/certificates/example.com", test.value, nil); err != nil {
+			t.Fatal(err)
+		}
+		cert, err := st.GetCertificate("example.com")
+		if !test.ok {
+			if err == nil {
+				t.Errorf("%s: expected error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if cert.Domain != "example.com" || cert.Issuer != test.issuer {
+			t.Errorf("%s: got domain %q and issuer %q, want issuer %q", test.name, cert.Domain, cert.Issuer, test.issuer)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	st, kv := newTestStore(t)
+	chain := newTestCertificatePEM(t, "example.com")
+	data, err := json.Marshal(&Certificate{Domain: "example.com", Thumbprint: "a", PrivateKey: []byte("key"), CertificateChain: chain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Put("coyote/certificates/example.com", data, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := Migrate(st, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != SchemaVersion {
+		t.Fatalf("got %d pending migrations, want %d", len(pending), SchemaVersion)
+	}
+	if version, err := st.GetSchemaVersion(); err != nil || version != 0 {
+		t.Fatalf("got version %d and error %v after a dry run, want 0", version, err)
+	}
+
+	applied, err := Migrate(st, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != SchemaVersion {
+		t.Errorf("got %d applied migrations, want %d", len(applied), SchemaVersion)
+	}
+	if version, err := st.GetSchemaVersion(); err != nil || version != SchemaVersion {
+		t.Errorf("got version %d and error %v, want %d", version, err, SchemaVersion)
+	}
+	kvPair, err := kv.Get("coyote/certificates/example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r record
+	if err := json.Unmarshal(kvPair.Value, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.SchemaVersion != SchemaVersion {
+		t.Errorf("got record version %d, want the certificate rewritten at %d", r.SchemaVersion, SchemaVersion)
+	}
+
+	if pending, err := PendingMigrations(st); err != nil || len(pending) != 0 {
+		t.Errorf("got %d pending migrations and error %v, want none", len(pending), err)
+	}
+}
+
+func TestPendingMigrationsRefusesNewerStore(t *testing.T) {
+	st, kv := newTestStore(t)
+	if err := kv.Put("coyote/metadata/schema-version", []byte(strconv.Itoa(SchemaVersion+1)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PendingMigrations(st); err == nil {
+		t.Error("expected error for a store newer than this version")
+	}
+}
+
+func TestWriteBackup(t *testing.T) {
+	st, _ := newTestStore(t)
+	if err := st.PutSchemaVersion(SchemaVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.PutSealSalt([]byte("salt")); err != nil {
+		t.Fatal(err)
+	}
+	account := &Account{URI: "https://acme.example.com/acct/1", Directory: "https://acme.example.com/dir", Email: "a@example.com", Key: []byte("key")}
+	if err := st.PutAccount(account); err != nil {
+		t.Fatal(err)
+	}
+	cert := &Certificate{Domain: "example.com", Thumbprint: "a", PrivateKey: []byte("key"), CertificateChain: []byte("chain")}
+	if err := st.PutCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteBackup(st, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var backup Backup
+	if err := json.Unmarshal(buf.Bytes(), &backup); err != nil {
+		t.Fatal(err)
+	}
+	if backup.SchemaVersion != SchemaVersion || string(backup.SealSalt) != "salt" {
+		t.Errorf("got version %d and salt %q, want the store's", backup.SchemaVersion, backup.SealSalt)
+	}
+	if len(backup.Accounts) != 1 || backup.Accounts[0].Email != account.Email {
+		t.Errorf("got accounts %+v, want the stored account", backup.Accounts)
+	}
+	if len(backup.Certificates) != 1 || string(backup.Certificates[0].PrivateKey) != "key" {
+		t.Errorf("got certificates %+v, want the stored certificate with its key", backup.Certificates)
+	}
+}
+
+// newTestCertificatePEM creates a PEM-encoded certificate for the domain issued by "Test Issuer"
+func newTestCertificatePEM(t *testing.T, domain string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	issuer := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test Issuer"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
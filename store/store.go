@@ -1,8 +1,13 @@
 package store
 
 import (
+	"context"
+	"crypto/x509"
 	"encoding/json"
+	"fmt"
+	"net/url"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"github.com/docker/libkv"
@@ -11,6 +16,7 @@ import (
 	"github.com/docker/libkv/store/consul"
 	"github.com/docker/libkv/store/etcd"
 	"github.com/docker/libkv/store/zookeeper"
+	"github.com/stugotech/coyote/cryptutil"
 	"github.com/stugotech/goconfig"
 	"github.com/stugotech/golog"
 )
@@ -26,23 +32,38 @@ const (
 
 // Store allows data to be retrieved from a data store
 type Store interface {
-	GetAccount(email string) (*Account, error)
+	GetAccount(directory string, email string) (*Account, error)
+	GetAccounts() ([]*Account, error)
 	GetCertificate(domain string) (*Certificate, error)
 	GetCertificates() ([]*Certificate, error)
 	GetChallenge(key string) (*Challenge, error)
+	GetChallenges() ([]*Challenge, error)
+	GetSealSalt() ([]byte, error)
+	GetSchemaVersion() (int, error)
 
 	PutAccount(account *Account) error
 	PutCertificate(cert *Certificate) error
 	PutChallenge(challenge *Challenge) error
+	PutSealSalt(salt []byte) error
+	PutSchemaVersion(version int) error
 
-	DeleteChallenge(key string) error
+	DeleteChallenge(challenge *Challenge) error
+
+	WatchCertificates(ctx context.Context) (<-chan *CertificateEvent, error)
 }
 
 // Account represents a user account on an ACME directory
 type Account struct {
-	URI   string
-	Email string
-	Key   []byte
+	URI string
+	// Directory is the URI of the ACME directory the account belongs to
+	Directory string
+	Email     string
+	Key       []byte
+	// LastIndex is the store index the account was read at, or zero for a new account
+	LastIndex uint64 `json:"-"`
+	// LegacyIndex is the store index of the record without a directory that the account was read
+	// from, which is removed once the account has been saved under its directory
+	LegacyIndex uint64 `json:"-"`
 }
 
 // Certificate represents a certificate used on a server
@@ -51,14 +72,100 @@ type Certificate struct {
 	AlternativeNames []string
 	Expires          time.Time
 	CertificateChain []byte
-	PrivateKey       []byte
-	Thumbprint       string
+	// PrivateKey is empty if the certificate was requested with a user-supplied CSR
+	PrivateKey []byte
+	// CSR is the PEM-encoded user-supplied certificate request, used again on renewal
+	CSR        []byte
+	Thumbprint string
+	// ReuseKey keeps the same private key when the certificate is renewed
+	ReuseKey bool
+	// Issuer is the common name of the certificate's issuer
+	Issuer string
+	// SerialNumber is the hex-encoded serial number of the certificate
+	SerialNumber string
+	// NotBefore is the time the certificate is valid from
+	NotBefore time.Time
+	// KeyType describes the certificate's public key, e.g. "ECDSA P-256"
+	KeyType string
+	// Issued is the time coyote received the certificate
+	Issued time.Time
+	// LastRenewalAttempt is the time the certificate was last renewed or failed to renew
+	LastRenewalAttempt time.Time
+	// LastRenewalError is the error from the last renewal attempt, if it failed
+	LastRenewalError string
+	// RenewalCount is the number of times the certificate has been renewed
+	RenewalCount int
+	// CA is the name of the CA profile the certificate was issued with
+	CA string
+	// PrimaryCA is the name of the CA profile the certificate was requested from, which differs
+	// from CA if a fallback CA issued the certificate
+	PrimaryCA string
+	// DirectoryURI is the URI of the ACME directory which issued the certificate
+	DirectoryURI string
+	// AccountEmail is the email of the account which requested the certificate
+	AccountEmail string
+	// LastIndex is the store index the certificate was read at, or zero for a new certificate
+	LastIndex uint64 `json:"-"`
+}
+
+// SetLeafDetails copies the details of the leaf certificate of the chain to the stored certificate
+func (c *Certificate) SetLeafDetails(leaf *x509.Certificate) {
+	c.Expires = leaf.NotAfter
+	c.NotBefore = leaf.NotBefore
+	c.Thumbprint = cryptutil.Thumbprint(leaf.Raw)
+	c.SerialNumber = fmt.Sprintf("%x", leaf.SerialNumber)
+	c.KeyType = cryptutil.KeyType(leaf.PublicKey)
+	c.Issuer = leaf.Issuer.CommonName
+	if c.Issuer == "" {
+		c.Issuer = leaf.Issuer.String()
+	}
+}
+
+// ConflictError is returned when a record has been changed in the store since it was read
+type ConflictError struct {
+	Key string
+}
+
+// Error gets the error message
+func (e *ConflictError) Error() string {
+	return "record was modified concurrently: " + e.Key
+}
+
+// IsConflict returns true if the error is a ConflictError
+func IsConflict(err error) bool {
+	_, ok := err.(*ConflictError)
+	return ok
+}
+
+// CertificateEventType describes the kind of change made to a certificate
+type CertificateEventType string
+
+// Certificate event types
+const (
+	CertificateUpdated CertificateEventType = "updated"
+	CertificateDeleted CertificateEventType = "deleted"
+)
+
+// CertificateEvent describes a change to a certificate in the store
+type CertificateEvent struct {
+	Type        CertificateEventType
+	Domain      string
+	Certificate *Certificate
 }
 
 // Challenge represents an ACME challenge
 type Challenge struct {
-	Key   string
-	Value string
+	Key     string
+	Value   string
+	Expires time.Time
+	// LastIndex is the store index the challenge was read at.  If it is set, PutChallenge only writes
+	// the challenge if it hasn't changed since.
+	LastIndex uint64 `json:"-"`
+}
+
+// Expired returns true if the challenge has an expiry time which has passed
+func (c *Challenge) Expired(now time.Time) bool {
+	return !c.Expires.IsZero() && now.After(c.Expires)
 }
 
 // libkvStore implements the Store interface using Docker's libkv package
@@ -71,6 +178,9 @@ const (
 	accountsPath     = "accounts"
 	certificatesPath = "certificates"
 	challengesPath   = "challenges"
+	metadataPath     = "metadata"
+	sealSaltKey      = "seal-salt"
+	schemaVersionKey = "schema-version"
 )
 
 // NewStoreFromConfig creates a new store based on the provided config
@@ -106,9 +216,31 @@ func NewLibKVStore(store store.Store, prefix string) (Store, error) {
 	}, nil
 }
 
-// GetAccount gets the account for the specified email address
-func (s *libkvStore) GetAccount(email string) (*Account, error) {
-	kv, err := s.store.Get(s.path(accountsPath, email))
+// GetAccount gets the account for the specified directory and email address.  Accounts stored by
+// older versions without a directory are returned if they belong to a server on the same host as
+// the directory, with a LastIndex of zero; saving them moves them under the directory.
+func (s *libkvStore) GetAccount(directory string, email string) (*Account, error) {
+	account, err := s.getAccount(s.path(accountsPath, accountKey(directory, email)))
+	if err != nil || account != nil || directory == "" {
+		return account, err
+	}
+
+	account, err = s.getAccount(s.path(accountsPath, accountKey("", email)))
+	if err != nil || account == nil {
+		return nil, err
+	}
+	if !sameHost(account.URI, directory) {
+		return nil, nil
+	}
+	account.Directory = directory
+	account.LegacyIndex = account.LastIndex
+	account.LastIndex = 0
+	return account, nil
+}
+
+// getAccount gets the account stored at the given path
+func (s *libkvStore) getAccount(path string) (*Account, error) {
+	kv, err := s.store.Get(path)
 	if err == store.ErrKeyNotFound {
 		return nil, nil
 	}
@@ -117,14 +249,42 @@ func (s *libkvStore) GetAccount(email string) (*Account, error) {
 	}
 
 	var account Account
-	err = json.Unmarshal(kv.Value, &account)
+	err = decodeRecord(kv.Value, &account)
 	if err != nil {
 		return nil, logger.Errore(err)
 	}
+	account.LastIndex = kv.LastIndex
 
 	return &account, nil
 }
 
+// GetAccounts gets all the accounts in the store
+func (s *libkvStore) GetAccounts() ([]*Account, error) {
+	kvs, err := s.store.List(s.path(accountsPath))
+	if err == store.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+
+	var accounts []*Account
+
+	for _, kv := range kvs {
+		var account Account
+
+		err = decodeRecord(kv.Value, &account)
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+		account.LastIndex = kv.LastIndex
+
+		accounts = append(accounts, &account)
+	}
+
+	return accounts, nil
+}
+
 // GetCertificate gets the certificate for the specified subject domain
 func (s *libkvStore) GetCertificate(domain string) (*Certificate, error) {
 	kv, err := s.store.Get(s.path(certificatesPath, domain))
@@ -136,10 +296,11 @@ func (s *libkvStore) GetCertificate(domain string) (*Certificate, error) {
 	}
 
 	var cert Certificate
-	err = json.Unmarshal(kv.Value, &cert)
+	err = decodeRecord(kv.Value, &cert)
 	if err != nil {
 		return nil, logger.Errore(err)
 	}
+	cert.LastIndex = kv.LastIndex
 
 	return &cert, nil
 }
@@ -147,6 +308,9 @@ func (s *libkvStore) GetCertificate(domain string) (*Certificate, error) {
 // GetCertificates gets all the certificates in the store
 func (s *libkvStore) GetCertificates() ([]*Certificate, error) {
 	kvs, err := s.store.List(s.path(certificatesPath))
+	if err == store.ErrKeyNotFound {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, logger.Errore(err)
 	}
@@ -156,10 +320,11 @@ func (s *libkvStore) GetCertificates() ([]*Certificate, error) {
 	for _, kv := range kvs {
 		var cert Certificate
 
-		err = json.Unmarshal(kv.Value, &cert)
+		err = decodeRecord(kv.Value, &cert)
 		if err != nil {
 			return nil, logger.Errore(err)
 		}
+		cert.LastIndex = kv.LastIndex
 
 		certs = append(certs, &cert)
 	}
@@ -176,10 +341,59 @@ func (s *libkvStore) GetChallenge(key string) (*Challenge, error) {
 	if err != nil {
 		return nil, logger.Errorex("error retrieving challenge", err)
 	}
-	return &Challenge{
-		Key:   key,
-		Value: string(kv.Value),
-	}, nil
+	challenge := decodeChallenge(key, kv.Value)
+	challenge.LastIndex = kv.LastIndex
+	if challenge.Expired(time.Now()) {
+		return nil, nil
+	}
+	return challenge, nil
+}
+
+// GetChallenges gets all challenges in the store, including expired ones
+func (s *libkvStore) GetChallenges() ([]*Challenge, error) {
+	kvs, err := s.store.List(s.path(challengesPath))
+	if err == store.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, logger.Errorex("error listing challenges", err)
+	}
+
+	var challenges []*Challenge
+	for _, kv := range kvs {
+		challenge := decodeChallenge(filepath.Base(kv.Key), kv.Value)
+		challenge.LastIndex = kv.LastIndex
+		challenges = append(challenges, challenge)
+	}
+	return challenges, nil
+}
+
+// GetSealSalt gets the salt used to derive the seal key from a passphrase
+func (s *libkvStore) GetSealSalt() ([]byte, error) {
+	kv, err := s.store.Get(s.path(metadataPath, sealSaltKey))
+	if err == store.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, logger.Errorex("error retrieving seal salt", err)
+	}
+	return kv.Value, nil
+}
+
+// GetSchemaVersion gets the version of the schema that the store has been migrated to
+func (s *libkvStore) GetSchemaVersion() (int, error) {
+	kv, err := s.store.Get(s.path(metadataPath, schemaVersionKey))
+	if err == store.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, logger.Errorex("error retrieving schema version", err)
+	}
+	version, err := strconv.Atoi(string(kv.Value))
+	if err != nil {
+		return 0, logger.Errorex("invalid schema version in store", err)
+	}
+	return version, nil
 }
 
 // PutAccount saves an account in the store
@@ -193,16 +407,24 @@ func (s *libkvStore) PutAccount(account *Account) error {
 	if len(account.Key) == 0 {
 		return logger.Error("must specify key for account")
 	}
-	bytes, err := json.Marshal(account)
+	bytes, err := encodeRecord(account)
 	if err != nil {
 		return logger.Errore(err)
 	}
 
-	err = s.store.Put(s.path(accountsPath, account.Email), bytes, nil)
+	account.LastIndex, err = s.atomicPut(s.path(accountsPath, accountKey(account.Directory, account.Email)), bytes, account.LastIndex)
 	if err != nil {
-		return logger.Errore(err)
+		return err
 	}
 
+	if account.LegacyIndex != 0 {
+		// the account has been moved under its directory, so the old record is no longer needed
+		err = s.atomicDelete(s.path(accountsPath, accountKey("", account.Email)), account.LegacyIndex)
+		if err != nil && !IsConflict(err) {
+			logger.Errorex("unable to remove account stored without directory", err, golog.String("email", account.Email))
+		}
+		account.LegacyIndex = 0
+	}
 	return nil
 }
 
@@ -214,23 +436,19 @@ func (s *libkvStore) PutCertificate(cert *Certificate) error {
 	if cert.Thumbprint == "" {
 		return logger.Error("must set certificate thumbprint")
 	}
-	if len(cert.PrivateKey) == 0 {
-		return logger.Error("must set certificate private key")
+	if len(cert.PrivateKey) == 0 && len(cert.CSR) == 0 {
+		return logger.Error("must set certificate private key or CSR")
 	}
 	if len(cert.CertificateChain) == 0 {
 		return logger.Error("must set certificate bundle")
 	}
-	bytes, err := json.Marshal(cert)
-	if err != nil {
-		return logger.Errore(err)
-	}
-
-	err = s.store.Put(s.path(certificatesPath, cert.Domain), bytes, nil)
+	bytes, err := encodeRecord(cert)
 	if err != nil {
 		return logger.Errore(err)
 	}
 
-	return nil
+	cert.LastIndex, err = s.atomicPut(s.path(certificatesPath, cert.Domain), bytes, cert.LastIndex)
+	return err
 }
 
 // PutChallenge saves a challenge in the store
@@ -246,29 +464,271 @@ func (s *libkvStore) PutChallenge(challenge *Challenge) error {
 	if challenge.Value == "" {
 		return logger.Error("must specify value for challenge")
 	}
-	err := s.store.Put(s.path(challengesPath, challenge.Key), []byte(challenge.Value), nil)
+	bytes, err := json.Marshal(challenge)
+	if err != nil {
+		return logger.Errore(err)
+	}
+
+	var options *store.WriteOptions
+	if !challenge.Expires.IsZero() {
+		ttl := time.Until(challenge.Expires)
+		if ttl <= 0 {
+			return logger.Error("challenge has already expired", golog.String("key", challenge.Key))
+		}
+		options = &store.WriteOptions{TTL: ttl}
+	}
+
+	key := s.path(challengesPath, challenge.Key)
+	err = s.putChallenge(key, bytes, challenge.LastIndex, options)
+	if err == store.ErrCallNotSupported && options != nil {
+		// backend can't expire keys, rely on the expiry time in the record instead
+		err = s.putChallenge(key, bytes, challenge.LastIndex, nil)
+	}
+	if IsConflict(err) {
+		return err
+	}
 	if err != nil {
 		return logger.Errorex("error saving challenge in store", err)
 	}
 	return nil
 }
 
-// DeleteChallenge deletes a challenge from the store
-func (s *libkvStore) DeleteChallenge(key string) error {
-	logger.Debug("trying to remove challenge from store", golog.String("key", key))
+// putChallenge writes a challenge, only replacing the one read at lastIndex if it is set.  The
+// backend's errors are returned as they are, apart from conflicts.
+func (s *libkvStore) putChallenge(key string, value []byte, lastIndex uint64, options *store.WriteOptions) error {
+	if lastIndex == 0 {
+		return s.store.Put(key, value, options)
+	}
+	_, _, err := s.store.AtomicPut(key, value, &store.KVPair{Key: key, LastIndex: lastIndex}, options)
+	if err == store.ErrKeyModified || err == store.ErrKeyNotFound {
+		logger.Debug("conflicting write to store", golog.String("key", key))
+		return &ConflictError{Key: key}
+	}
+	return err
+}
+
+// PutSealSalt saves the salt used to derive the seal key from a passphrase.  The salt can only be
+// created once; a ConflictError is returned if the store already has one, e.g. because another node
+// created it at the same time.
+func (s *libkvStore) PutSealSalt(salt []byte) error {
+	if len(salt) == 0 {
+		return logger.Error("must specify salt")
+	}
+	_, err := s.atomicPut(s.path(metadataPath, sealSaltKey), salt, 0)
+	if err != nil && !IsConflict(err) {
+		return logger.Errorex("error saving seal salt in store", err)
+	}
+	return err
+}
 
-	if key == "" {
+// PutSchemaVersion saves the version of the schema that the store has been migrated to.  A
+// ConflictError is returned if the version is changed by another node while it is being saved.
+func (s *libkvStore) PutSchemaVersion(version int) error {
+	key := s.path(metadataPath, schemaVersionKey)
+	var lastIndex uint64
+	kv, err := s.store.Get(key)
+	if err != nil && err != store.ErrKeyNotFound {
+		return logger.Errorex("error retrieving schema version", err)
+	}
+	if err == nil {
+		lastIndex = kv.LastIndex
+	}
+
+	_, err = s.atomicPut(key, []byte(strconv.Itoa(version)), lastIndex)
+	if err != nil && !IsConflict(err) {
+		return logger.Errorex("error saving schema version in store", err)
+	}
+	return err
+}
+
+// DeleteChallenge deletes a challenge read from the store, unless it has been changed since it was
+// read, in which case a ConflictError is returned
+func (s *libkvStore) DeleteChallenge(challenge *Challenge) error {
+	logger.Debug("trying to remove challenge from store", golog.String("key", challenge.Key))
+
+	if challenge.Key == "" {
 		return logger.Error("must specify key")
 	}
 
-	err := s.store.Delete(key)
+	err := s.atomicDelete(s.path(challengesPath, challenge.Key), challenge.LastIndex)
+	if err != nil && !IsConflict(err) {
+		return logger.Errorex("error while trying to remove challenge from store", err, golog.String("key", challenge.Key))
+	}
+	return err
+}
+
+// atomicPut saves a value only if it hasn't been changed since it was read at lastIndex, or only if
+// it doesn't exist when lastIndex is zero.  Returns the new index of the value.
+func (s *libkvStore) atomicPut(key string, value []byte, lastIndex uint64) (uint64, error) {
+	var previous *store.KVPair
+	if lastIndex != 0 {
+		previous = &store.KVPair{Key: key, LastIndex: lastIndex}
+	}
+
+	_, kv, err := s.store.AtomicPut(key, value, previous, nil)
+	if err == store.ErrKeyModified || err == store.ErrKeyExists {
+		logger.Debug("conflicting write to store", golog.String("key", key))
+		return lastIndex, &ConflictError{Key: key}
+	}
 	if err != nil {
-		return logger.Errorex("error while trying to remove challenge from store", err, golog.String("key", key))
+		return lastIndex, logger.Errore(err)
 	}
+	return kv.LastIndex, nil
+}
 
+// atomicDelete deletes a value only if it hasn't been changed since it was read at lastIndex.  A
+// value which has already been deleted is ignored.
+func (s *libkvStore) atomicDelete(key string, lastIndex uint64) error {
+	if lastIndex == 0 {
+		return logger.Error("must specify index of value to delete", golog.String("key", key))
+	}
+
+	_, err := s.store.AtomicDelete(key, &store.KVPair{Key: key, LastIndex: lastIndex})
+	if err == store.ErrKeyNotFound {
+		return nil
+	}
+	if err == store.ErrKeyModified {
+		logger.Debug("conflicting delete from store", golog.String("key", key))
+		return &ConflictError{Key: key}
+	}
+	if err != nil {
+		return logger.Errore(err)
+	}
 	return nil
 }
 
+// accountKey gets the key that an account is stored under.  Accounts without a directory were
+// stored by older versions and are keyed by email only.
+func accountKey(directory string, email string) string {
+	if directory == "" {
+		return email
+	}
+	return url.QueryEscape(directory) + "," + url.QueryEscape(email)
+}
+
+// sameHost returns true if both URIs have the same host
+func sameHost(a string, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return ua.Host != "" && ua.Host == ub.Host
+}
+
+// decodeChallenge decodes a stored challenge.  Challenges stored by older versions contain just the
+// raw response value.
+func decodeChallenge(key string, value []byte) *Challenge {
+	var challenge Challenge
+	if err := json.Unmarshal(value, &challenge); err != nil || challenge.Value == "" {
+		return &Challenge{Key: key, Value: string(value)}
+	}
+	challenge.Key = key
+	return &challenge
+}
+
+// DeleteExpiredChallenges removes challenges which have expired by `now` from the store.  Challenges
+// without an expiry time were written by older versions and may still be in use, so they are given
+// an expiry of `legacyTTL` from now and removed by a later run once that has passed.
+func DeleteExpiredChallenges(s Store, now time.Time, legacyTTL time.Duration) ([]*Challenge, error) {
+	challenges, err := s.GetChallenges()
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+
+	var deleted []*Challenge
+	for _, challenge := range challenges {
+		if challenge.Expires.IsZero() {
+			// written at the index it was read, so that a challenge rewritten since is left alone
+			challenge.Expires = now.Add(legacyTTL)
+			if err := s.PutChallenge(challenge); err != nil && !IsConflict(err) {
+				return deleted, logger.Errore(err)
+			}
+			continue
+		}
+		if !challenge.Expired(now) {
+			continue
+		}
+		err := s.DeleteChallenge(challenge)
+		if IsConflict(err) {
+			// rewritten since it was read, e.g. by a new authorization
+			continue
+		}
+		if err != nil {
+			return deleted, logger.Errore(err)
+		}
+		deleted = append(deleted, challenge)
+	}
+	return deleted, nil
+}
+
+// WatchCertificates watches the store for changes to certificates.  An update event is sent for
+// every existing certificate when the watch starts.  The channel is closed when the context is
+// cancelled or the watch fails.
+func (s *libkvStore) WatchCertificates(ctx context.Context) (<-chan *CertificateEvent, error) {
+	kvsCh, err := s.store.WatchTree(s.path(certificatesPath), ctx.Done())
+	if err == store.ErrCallNotSupported {
+		return nil, logger.Error("store backend can't watch for changes; use etcd, consul or zookeeper")
+	}
+	if err != nil {
+		return nil, logger.Errorex("unable to watch certificates", err)
+	}
+
+	events := make(chan *CertificateEvent)
+
+	go func() {
+		defer close(events)
+		thumbprints := make(map[string]string)
+
+		for kvs := range kvsCh {
+			current := make(map[string]string)
+
+			for _, kv := range kvs {
+				var cert Certificate
+				if err := decodeRecord(kv.Value, &cert); err != nil {
+					logger.Errorex("unable to decode certificate", err, golog.String("key", kv.Key))
+					continue
+				}
+				cert.LastIndex = kv.LastIndex
+				current[cert.Domain] = cert.Thumbprint
+
+				if thumbprints[cert.Domain] == cert.Thumbprint {
+					continue
+				}
+				if !sendEvent(ctx, events, &CertificateEvent{Type: CertificateUpdated, Domain: cert.Domain, Certificate: &cert}) {
+					return
+				}
+			}
+
+			for domain := range thumbprints {
+				if _, ok := current[domain]; ok {
+					continue
+				}
+				if !sendEvent(ctx, events, &CertificateEvent{Type: CertificateDeleted, Domain: domain}) {
+					return
+				}
+			}
+
+			thumbprints = current
+		}
+	}()
+
+	return events, nil
+}
+
+// sendEvent sends an event unless the context is cancelled first
+func sendEvent(ctx context.Context, events chan<- *CertificateEvent, event *CertificateEvent) bool {
+	select {
+	case events <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
 // path constructs a path from the given components
 func (s *libkvStore) path(components ...string) string {
 	components = append([]string{s.prefix}, components...)
@@ -0,0 +1,244 @@
+package store
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/docker/libkv/store"
+	"github.com/stugotech/coyote/store/memkv"
+)
+
+// newTestStore creates a store backed by an in-memory libkv store
+func newTestStore(t *testing.T) (Store, *memkv.Store) {
+	kv := memkv.New()
+	st, err := NewLibKVStore(kv, "coyote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st, kv
+}
+
+// racingStore runs a function after reading the challenges, as another node might
+type racingStore struct {
+	Store
+	race func()
+}
+
+func (s *racingStore) GetChallenges() ([]*Challenge, error) {
+	challenges, err := s.Store.GetChallenges()
+	s.race()
+	return challenges, err
+}
+
+func TestDeleteExpiredChallenges(t *testing.T) {
+	st, kv := newTestStore(t)
+	now := time.Now()
+	legacy := []byte("legacy-response")
+	if err := kv.Put("coyote/challenges/legacy", legacy, nil); err != nil {
+		t.Fatal(err)
+	}
+	putExpiredChallenge(t, kv, "expired", "a", now)
+	if err := st.PutChallenge(&Challenge{Key: "current", Value: "b", Expires: now.Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := DeleteExpiredChallenges(st, now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0].Key != "expired" {
+		t.Fatalf("got %d deleted challenges, want just the expired one", len(deleted))
+	}
+	for key, want := range map[string]bool{"expired": false, "current": true, "legacy": true} {
+		challenge, err := st.GetChallenge(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (challenge != nil) != want {
+			t.Errorf("%s: got challenge %v, want exists %v", key, challenge, want)
+		}
+	}
+
+	// the legacy challenge is given an expiry, and removed once it has passed
+	challenge, err := st.GetChallenge("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge.Value != "legacy-response" || !challenge.Expires.Equal(now.Add(time.Hour)) {
+		t.Errorf("got legacy challenge %+v, want an expiry an hour from now", challenge)
+	}
+	deleted, err = DeleteExpiredChallenges(st, now.Add(2*time.Hour), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 2 {
+		t.Errorf("got %d deleted challenges, want the legacy and current ones", len(deleted))
+	}
+}
+
+func TestDeleteExpiredChallengesLeavesRewrittenChallenges(t *testing.T) {
+	st, kv := newTestStore(t)
+	now := time.Now()
+	if err := kv.Put("coyote/challenges/legacy", []byte("old"), nil); err != nil {
+		t.Fatal(err)
+	}
+	putExpiredChallenge(t, kv, "expired", "old", now)
+
+	// a new authorization writes both challenges again after they have been read
+	racing := &racingStore{Store: st, race: func() {
+		for _, key := range []string{"legacy", "expired"} {
+			err := st.PutChallenge(&Challenge{Key: key, Value: "new", Expires: now.Add(time.Minute)})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}}
+	deleted, err := DeleteExpiredChallenges(racing, now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 0 {
+		t.Errorf("got %d deleted challenges, want none", len(deleted))
+	}
+	for _, key := range []string{"legacy", "expired"} {
+		challenge, err := st.GetChallenge(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if challenge == nil || challenge.Value != "new" || !challenge.Expires.Equal(now.Add(time.Minute)) {
+			t.Errorf("%s: got challenge %+v, want the rewritten challenge", key, challenge)
+		}
+	}
+}
+
+// putExpiredChallenge writes a challenge which expired before now, which PutChallenge refuses to do
+func putExpiredChallenge(t *testing.T, kv *memkv.Store, key string, value string, now time.Time) {
+	data, err := json.Marshal(&Challenge{Key: key, Value: value, Expires: now.Add(-time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Put("coyote/challenges/"+key, data, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPutChallengeWithoutTTLSupport(t *testing.T) {
+	st, kv := newTestStore(t)
+	kv.NoTTL = true
+	expires := time.Now().Add(time.Minute)
+	if err := st.PutChallenge(&Challenge{Key: "token", Value: "response", Expires: expires}); err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := st.GetChallenge("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if challenge == nil || challenge.Value != "response" || !challenge.Expires.Equal(expires) {
+		t.Errorf("got challenge %+v, want it stored with its expiry", challenge)
+	}
+}
+
+func TestPutCertificateConflicts(t *testing.T) {
+	st, _ := newTestStore(t)
+	cert := &Certificate{Domain: "example.com", Thumbprint: "a", PrivateKey: []byte("key"), CertificateChain: []byte("chain")}
+	if err := st.PutCertificate(cert); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new certificate can't replace one which another node has created
+	other := &Certificate{Domain: "example.com", Thumbprint: "b", PrivateKey: []byte("key"), CertificateChain: []byte("chain")}
+	if err := st.PutCertificate(other); !IsConflict(err) {
+		t.Errorf("got error %v creating an existing certificate, want a conflict", err)
+	}
+
+	read, err := st.GetCertificate("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.LastIndex != cert.LastIndex {
+		t.Errorf("got index %d, want the index %d the certificate was written at", read.LastIndex, cert.LastIndex)
+	}
+	read.Thumbprint = "c"
+	if err := st.PutCertificate(read); err != nil {
+		t.Fatal(err)
+	}
+
+	// the certificate has changed since the first write
+	cert.Thumbprint = "d"
+	if err := st.PutCertificate(cert); !IsConflict(err) {
+		t.Errorf("got error %v writing a stale certificate, want a conflict", err)
+	}
+	read, err = st.GetCertificate("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Thumbprint != "c" {
+		t.Errorf("got thumbprint %q, want the last successful write", read.Thumbprint)
+	}
+}
+
+func TestPutAccountConflicts(t *testing.T) {
+	st, _ := newTestStore(t)
+	account := &Account{URI: "https://acme.example.com/acct/1", Directory: "https://acme.example.com/dir", Email: "a@example.com", Key: []byte("key")}
+	if err := st.PutAccount(account); err != nil {
+		t.Fatal(err)
+	}
+	other := *account
+	other.LastIndex = 0
+	if err := st.PutAccount(&other); !IsConflict(err) {
+		t.Errorf("got error %v creating an existing account, want a conflict", err)
+	}
+	if err := st.PutAccount(account); err != nil {
+		t.Errorf("got error %v updating the account at the index it was written", err)
+	}
+}
+
+func TestGetAccountMovesLegacyAccount(t *testing.T) {
+	st, kv := newTestStore(t)
+	data, err := json.Marshal(&Account{URI: "https://acme.example.com/acct/1", Email: "a@example.com", Key: []byte("key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.Put("coyote/accounts/a@example.com", data, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	account, err := st.GetAccount("https://other.example.com/dir", "a@example.com")
+	if err != nil || account != nil {
+		t.Errorf("got account %v and error %v for a directory on another host, want none", account, err)
+	}
+	account, err = st.GetAccount("https://acme.example.com/dir", "a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account == nil || account.LastIndex != 0 || account.LegacyIndex == 0 {
+		t.Fatalf("got account %+v, want the legacy account to be created under the directory", account)
+	}
+	if err := st.PutAccount(account); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kv.Get("coyote/accounts/a@example.com"); err != store.ErrKeyNotFound {
+		t.Errorf("got error %v reading the legacy account, want it removed", err)
+	}
+	if account, err := st.GetAccount("https://acme.example.com/dir", "a@example.com"); err != nil || account == nil || account.LegacyIndex != 0 {
+		t.Errorf("got account %+v and error %v, want the account stored under the directory", account, err)
+	}
+}
+
+func TestPutSealSaltOnlyOnce(t *testing.T) {
+	st, _ := newTestStore(t)
+	if err := st.PutSealSalt([]byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.PutSealSalt([]byte("second")); !IsConflict(err) {
+		t.Errorf("got error %v replacing the salt, want a conflict", err)
+	}
+	salt, err := st.GetSealSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(salt) != "first" {
+		t.Errorf("got salt %q, want the first one", salt)
+	}
+}
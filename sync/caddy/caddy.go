@@ -0,0 +1,241 @@
+package caddy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/stugotech/coyote/sync"
+	"github.com/stugotech/golog"
+)
+
+var logger = golog.NewPackageLogger()
+
+// DefaultAdminURL is the default address of the Caddy admin API
+const DefaultAdminURL = "http://localhost:2019"
+
+// idPrefix is the prefix of the @id given to each certificate loaded by coyote, so that it can be
+// found and replaced through the admin API
+const idPrefix = "coyote-"
+
+// loadPEMPath is the config path of the certificates loaded from PEM data
+const loadPEMPath = "/config/apps/tls/certificates/load_pem"
+
+// loadedCertificate is a certificate in the load_pem list of the Caddy TLS app
+type loadedCertificate struct {
+	ID          string   `json:"@id,omitempty"`
+	Certificate string   `json:"certificate"`
+	Key         string   `json:"key"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// client is an implementation of the Client interface for the Caddy admin API
+type client struct {
+	adminURL string
+	http     *http.Client
+}
+
+// NewClient creates a client which loads certificates into Caddy through its admin API
+func NewClient(adminURL string) sync.Client {
+	if adminURL == "" {
+		adminURL = DefaultAdminURL
+	}
+	return &client{
+		adminURL: strings.TrimRight(adminURL, "/"),
+		http:     &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// GetHosts returns the hosts whose certificates were loaded by the client
+func (c *client) GetHosts() ([]*sync.Host, error) {
+	var certs []*loadedCertificate
+	found, err := c.get(loadPEMPath, &certs)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	if !found {
+		return nil, nil
+	}
+	var hosts []*sync.Host
+	for _, cert := range certs {
+		if !strings.HasPrefix(cert.ID, idPrefix) {
+			// not loaded by coyote
+			continue
+		}
+		hosts = append(hosts, &sync.Host{
+			Domain:         strings.TrimPrefix(cert.ID, idPrefix),
+			CertificatePEM: cert.Certificate,
+			PrivateKeyPEM:  cert.Key,
+		})
+	}
+	return hosts, nil
+}
+
+// GetHost returns a single host, or nil if it hasn't been loaded
+func (c *client) GetHost(domain string) (*sync.Host, error) {
+	var cert loadedCertificate
+	found, err := c.get(idPath(domain), &cert)
+	if err != nil {
+		// get has logged the error, and marked it if it is transient
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	return &sync.Host{
+		Domain:         domain,
+		CertificatePEM: cert.Certificate,
+		PrivateKeyPEM:  cert.Key,
+	}, nil
+}
+
+// PutHost loads the certificate for a host into Caddy, replacing the one loaded before if it has
+// changed
+func (c *client) PutHost(host *sync.Host) error {
+	existing, err := c.GetHost(host.Domain)
+	if err != nil {
+		return err
+	}
+	cert := &loadedCertificate{
+		ID:          idPrefix + host.Domain,
+		Certificate: host.CertificatePEM,
+		Key:         host.PrivateKeyPEM,
+		Tags:        []string{"coyote"},
+	}
+
+	if existing != nil {
+		existingThumbprint, _ := existing.Thumbprint()
+		thumbprint, err := host.Thumbprint()
+		if err != nil {
+			return logger.Errore(err)
+		}
+		if existingThumbprint == thumbprint && existing.PrivateKeyPEM == host.PrivateKeyPEM {
+			logger.Debug("Caddy certificate is up to date", golog.String("domain", host.Domain))
+			return nil
+		}
+		if err := c.send("PATCH", idPath(host.Domain), cert); err != nil {
+			// the error is returned as it is, so that transient failures are retried
+			logger.Errorex("unable to replace certificate in Caddy", err, golog.String("domain", host.Domain))
+			return err
+		}
+		logger.Info("replaced Caddy certificate", golog.String("domain", host.Domain))
+		return nil
+	}
+
+	// append to the loaded certificates, or create the list if the TLS app has none yet
+	err = c.send("POST", loadPEMPath, cert)
+	if err != nil && !sync.IsTransient(err) {
+		logger.Debug("unable to append certificate, loading whole config", golog.String("domain", host.Domain))
+		err = c.loadWithCertificate(cert)
+	}
+	if err != nil {
+		logger.Errorex("unable to load certificate into Caddy", err, golog.String("domain", host.Domain))
+		return err
+	}
+	logger.Info("loaded Caddy certificate", golog.String("domain", host.Domain))
+	return nil
+}
+
+// loadWithCertificate reads the whole config, adds the certificate to it and loads it again, which
+// creates any missing parts of the TLS app
+func (c *client) loadWithCertificate(cert *loadedCertificate) error {
+	config := make(map[string]interface{})
+	if _, err := c.get("/config/", &config); err != nil {
+		return err
+	}
+	if config == nil {
+		config = make(map[string]interface{})
+	}
+	certificates := childMap(childMap(childMap(config, "apps"), "tls"), "certificates")
+	loaded, _ := certificates["load_pem"].([]interface{})
+	certificates["load_pem"] = append(loaded, cert)
+	return c.send("POST", "/load", config)
+}
+
+// get reads a config value into value, returning false if the path doesn't exist
+func (c *client) get(path string, value interface{}) (bool, error) {
+	resp, err := c.http.Get(c.adminURL + path)
+	if err != nil {
+		// Caddy may be restarting
+		logger.Errorex("unable to reach Caddy admin API", err, golog.String("path", path))
+		return false, &sync.TransientError{Err: err}
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, logger.Errore(err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, requestError("GET", path, resp, body)
+	}
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 || string(body) == "null" {
+		return false, nil
+	}
+	if err := json.Unmarshal(body, value); err != nil {
+		return false, logger.Errore(err)
+	}
+	return true, nil
+}
+
+// send sends a value to the admin API
+func (c *client) send(method string, path string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return logger.Errore(err)
+	}
+	req, err := http.NewRequest(method, c.adminURL+path, bytes.NewReader(data))
+	if err != nil {
+		return logger.Errore(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		// Caddy may be restarting
+		logger.Errorex("unable to reach Caddy admin API", err, golog.String("path", path))
+		return &sync.TransientError{Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return requestError(method, path, resp, body)
+	}
+	return nil
+}
+
+// requestError gets the error for a failed admin API request; server errors and rate limiting are
+// transient, so are marked to be retried
+func requestError(method string, path string, resp *http.Response, body []byte) error {
+	err := logger.Error("Caddy admin API request failed",
+		golog.String("method", method),
+		golog.String("path", path),
+		golog.String("status", resp.Status),
+		golog.String("response", strings.TrimSpace(string(body))),
+	)
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return &sync.TransientError{Err: err}
+	}
+	return err
+}
+
+// idPath gets the admin API path of the certificate loaded for a domain
+func idPath(domain string) string {
+	return "/id/" + url.PathEscape(idPrefix+domain)
+}
+
+// childMap gets the map stored under key, creating it if required
+func childMap(parent map[string]interface{}, key string) map[string]interface{} {
+	child, ok := parent[key].(map[string]interface{})
+	if !ok {
+		child = make(map[string]interface{})
+		parent[key] = child
+	}
+	return child
+}
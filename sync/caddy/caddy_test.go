@@ -0,0 +1,243 @@
+package caddy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/stugotech/coyote/sync"
+)
+
+// fakeAdmin is a Caddy admin API which keeps the loaded certificates in memory
+type fakeAdmin struct {
+	mu gosync.Mutex
+	// tls is false until the TLS app has been loaded
+	tls    bool
+	loaded []*loadedCertificate
+	// requests are the method and path of each request
+	requests []string
+	// status makes every request fail with the status, if set
+	status int
+}
+
+func (a *fakeAdmin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.requests = append(a.requests, r.Method+" "+r.URL.Path)
+	if a.status != 0 {
+		http.Error(w, `{"error":"failed"}`, a.status)
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/config/":
+		w.Write([]byte("null"))
+	case r.Method == "POST" && r.URL.Path == "/load":
+		var config struct {
+			Apps struct {
+				TLS struct {
+					Certificates struct {
+						LoadPEM []*loadedCertificate `json:"load_pem"`
+					} `json:"certificates"`
+				} `json:"tls"`
+			} `json:"apps"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		a.tls = true
+		a.loaded = config.Apps.TLS.Certificates.LoadPEM
+	case r.URL.Path == loadPEMPath:
+		if !a.tls {
+			http.Error(w, `{"error":"invalid traversal path"}`, http.StatusBadRequest)
+			return
+		}
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode(a.loaded)
+			return
+		}
+		var cert loadedCertificate
+		json.NewDecoder(r.Body).Decode(&cert)
+		a.loaded = append(a.loaded, &cert)
+	case strings.HasPrefix(r.URL.Path, "/id/"):
+		i := a.find(strings.TrimPrefix(r.URL.Path, "/id/"))
+		if i < 0 {
+			http.Error(w, `{"error":"unknown object ID"}`, http.StatusNotFound)
+			return
+		}
+		if r.Method == "GET" {
+			json.NewEncoder(w).Encode(a.loaded[i])
+			return
+		}
+		var cert loadedCertificate
+		json.NewDecoder(r.Body).Decode(&cert)
+		a.loaded[i] = &cert
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// find gets the index of the certificate with the escaped ID, or -1
+func (a *fakeAdmin) find(escaped string) int {
+	id, _ := url.PathUnescape(escaped)
+	for i, cert := range a.loaded {
+		if cert.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// takeRequests returns the requests made since it was last called
+func (a *fakeAdmin) takeRequests() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	requests := a.requests
+	a.requests = nil
+	return requests
+}
+
+func TestPutHost(t *testing.T) {
+	admin := &fakeAdmin{}
+	server := httptest.NewServer(admin)
+	defer server.Close()
+	external := NewClient(server.URL)
+
+	// the TLS app doesn't exist yet, so the whole config is loaded
+	host := newTestHost(t, "*.example.com")
+	if err := external.PutHost(host); err != nil {
+		t.Fatal(err)
+	}
+	assertRequests(t, admin, "GET /id/coyote-*.example.com", "POST "+loadPEMPath, "GET /config/", "POST /load")
+	assertHost(t, external, host)
+	admin.takeRequests()
+
+	// an unchanged host isn't sent again
+	if err := external.PutHost(host); err != nil {
+		t.Fatal(err)
+	}
+	assertRequests(t, admin, "GET /id/coyote-*.example.com")
+
+	// a new certificate replaces the loaded one
+	host = newTestHost(t, "*.example.com")
+	if err := external.PutHost(host); err != nil {
+		t.Fatal(err)
+	}
+	assertRequests(t, admin, "GET /id/coyote-*.example.com", "PATCH /id/coyote-*.example.com")
+	assertHost(t, external, host)
+
+	// a new host is appended, and only hosts loaded by coyote are returned
+	other := newTestHost(t, "example.org")
+	if err := external.PutHost(other); err != nil {
+		t.Fatal(err)
+	}
+	admin.loaded = append(admin.loaded, &loadedCertificate{Certificate: "manual"})
+	hosts, err := external.GetHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 {
+		t.Errorf("got %d hosts, want the 2 loaded by coyote", len(hosts))
+	}
+}
+
+func TestPutHostErrors(t *testing.T) {
+	tests := []struct {
+		status    int
+		transient bool
+	}{
+		{http.StatusBadRequest, false},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusServiceUnavailable, true},
+	}
+	for _, test := range tests {
+		admin := &fakeAdmin{status: test.status}
+		server := httptest.NewServer(admin)
+		external := NewClient(server.URL)
+		err := external.PutHost(newTestHost(t, "example.com"))
+		if err == nil || sync.IsTransient(err) != test.transient {
+			t.Errorf("%d: got error %v, want transient %v", test.status, err, test.transient)
+		}
+		server.Close()
+	}
+
+	// a new certificate is sent to Caddy after it has been read
+	admin := &fakeAdmin{tls: true}
+	server := httptest.NewServer(admin)
+	defer server.Close()
+	external := NewClient(server.URL)
+	if err := external.PutHost(newTestHost(t, "example.com")); err != nil {
+		t.Fatal(err)
+	}
+	admin.status = http.StatusBadGateway
+	if err := external.PutHost(newTestHost(t, "example.com")); err == nil || !sync.IsTransient(err) {
+		t.Errorf("got error %v replacing a certificate, want a transient error", err)
+	}
+
+	// Caddy isn't running
+	server.Close()
+	if _, err := external.GetHost("example.com"); !sync.IsTransient(err) {
+		t.Errorf("got error %v with Caddy stopped, want a transient error", err)
+	}
+}
+
+// assertRequests checks the requests made to the admin API since they were last checked
+func assertRequests(t *testing.T, admin *fakeAdmin, want ...string) {
+	got := admin.takeRequests()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got requests %q, want %q", got, want)
+	}
+}
+
+// assertHost checks that the client returns the host
+func assertHost(t *testing.T, external sync.Client, want *sync.Host) {
+	host, err := external.GetHost(want.Domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host == nil || host.CertificatePEM != want.CertificatePEM || host.PrivateKeyPEM != want.PrivateKeyPEM {
+		t.Errorf("got host %+v, want %+v", host, want)
+	}
+}
+
+// newTestHost creates a host with a new self-signed certificate for the domain
+func newTestHost(t *testing.T, domain string) *sync.Host {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &sync.Host{
+		Domain:         domain,
+		CertificatePEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		PrivateKeyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
@@ -0,0 +1,281 @@
+package directory
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/stugotech/coyote/export"
+	"github.com/stugotech/coyote/sync"
+	"github.com/stugotech/golog"
+)
+
+var logger = golog.NewPackageLogger()
+
+// Default file layout
+const (
+	DefaultCertPath = "{{.Domain}}/fullchain.pem"
+	DefaultKeyPath  = "{{.Domain}}/privkey.pem"
+)
+
+// Config describes where certificates are written and how the server using them is reloaded
+type Config struct {
+	// Dir is the directory that the paths are relative to
+	Dir string `mapstructure:"dir"`
+	// CertPath and KeyPath are templates for the paths of the certificate chain and private key
+	// files of each host, e.g. "{{.Domain}}/fullchain.pem".  Both must use the domain.
+	CertPath string `mapstructure:"cert-path"`
+	KeyPath  string `mapstructure:"key-path"`
+	// FileMode and KeyMode are the permissions of the certificate and key files
+	FileMode os.FileMode `mapstructure:"file-mode"`
+	KeyMode  os.FileMode `mapstructure:"key-mode"`
+	// ReloadCommand is run with the shell after a batch of hosts has been written, if any changed
+	ReloadCommand string `mapstructure:"reload-command"`
+}
+
+// pathData is the data passed to the path templates
+type pathData struct {
+	Domain string
+}
+
+// client is an implementation of the Client interface which writes hosts to files
+type client struct {
+	config   *Config
+	certPath *template.Template
+	keyPath  *template.Template
+	changed  bool
+}
+
+// NewClient creates a client which writes hosts to files in a directory
+func NewClient(config *Config) (sync.Client, error) {
+	c := &client{config: config}
+	if c.config.CertPath == "" {
+		c.config.CertPath = DefaultCertPath
+	}
+	if c.config.KeyPath == "" {
+		c.config.KeyPath = DefaultKeyPath
+	}
+	if c.config.FileMode == 0 {
+		c.config.FileMode = export.DefaultFileMode
+	}
+	if c.config.KeyMode == 0 {
+		c.config.KeyMode = export.DefaultKeyMode
+	}
+
+	var err error
+	if c.certPath, err = parsePathTemplate("cert", c.config.CertPath); err != nil {
+		return nil, logger.Errore(err)
+	}
+	if c.keyPath, err = parsePathTemplate("key", c.config.KeyPath); err != nil {
+		return nil, logger.Errore(err)
+	}
+	return c, nil
+}
+
+// GetHosts returns all hosts with a certificate file
+func (c *client) GetHosts() ([]*sync.Host, error) {
+	domains, err := c.findDomains()
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	var hosts []*sync.Host
+	for _, domain := range domains {
+		host, err := c.GetHost(domain)
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+		if host != nil {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, nil
+}
+
+// GetHost returns a single host, or nil if it has no certificate file
+func (c *client) GetHost(domain string) (*sync.Host, error) {
+	certPath, keyPath, err := c.paths(domain)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	cert, err := ioutil.ReadFile(certPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	key, err := ioutil.ReadFile(keyPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, logger.Errore(err)
+	}
+	return &sync.Host{
+		Domain:         domain,
+		CertificatePEM: string(cert),
+		PrivateKeyPEM:  string(key),
+	}, nil
+}
+
+// PutHost writes the certificate and key files for a host, unless they are already up to date
+func (c *client) PutHost(host *sync.Host) error {
+	existing, err := c.GetHost(host.Domain)
+	if err != nil {
+		return logger.Errore(err)
+	}
+	if existing != nil && existing.CertificatePEM == host.CertificatePEM && existing.PrivateKeyPEM == host.PrivateKeyPEM {
+		logger.Debug("certificate files are up to date", golog.String("domain", host.Domain))
+		return nil
+	}
+
+	certPath, keyPath, err := c.paths(host.Domain)
+	if err != nil {
+		return logger.Errore(err)
+	}
+	// write both files before replacing either, so that a failed write leaves the old pair in place
+	keyTmp, err := c.stageFile(keyPath, []byte(host.PrivateKeyPEM), c.config.KeyMode)
+	if err != nil {
+		return logger.Errore(err)
+	}
+	defer os.Remove(keyTmp)
+	certTmp, err := c.stageFile(certPath, []byte(host.CertificatePEM), c.config.FileMode)
+	if err != nil {
+		return logger.Errore(err)
+	}
+	defer os.Remove(certTmp)
+
+	if err := os.Rename(certTmp, certPath); err != nil {
+		return logger.Errorex("unable to write file", err, golog.String("path", certPath))
+	}
+	if err := os.Rename(keyTmp, keyPath); err != nil {
+		return logger.Errorex("unable to write file", err, golog.String("path", keyPath))
+	}
+
+	logger.Info("wrote certificate files",
+		golog.String("domain", host.Domain),
+		golog.String("cert", certPath),
+		golog.String("key", keyPath),
+	)
+	c.changed = true
+	return nil
+}
+
+// Commit runs the reload command if any hosts have been written since it last succeeded
+func (c *client) Commit() error {
+	if !c.changed || c.config.ReloadCommand == "" {
+		c.changed = false
+		return nil
+	}
+
+	logger.Info("running reload command", golog.String("command", c.config.ReloadCommand))
+	output, err := exec.Command("/bin/sh", "-c", c.config.ReloadCommand).CombinedOutput()
+	if err != nil {
+		// leave changed set so that the next batch retries the reload
+		return logger.Errorex("reload command failed", err,
+			golog.String("command", c.config.ReloadCommand),
+			golog.String("output", strings.TrimSpace(string(output))),
+		)
+	}
+	c.changed = false
+	return nil
+}
+
+// stageFile writes a temporary file alongside a file in the directory, creating its parent
+// directories if required, and returns its name
+func (c *client) stageFile(path string, data []byte, mode os.FileMode) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", logger.Errore(err)
+	}
+	return export.StageFile(path, data, mode)
+}
+
+// paths gets the paths of the certificate and key files for a domain
+func (c *client) paths(domain string) (string, string, error) {
+	certPath, err := c.path(c.certPath, domain)
+	if err != nil {
+		return "", "", err
+	}
+	keyPath, err := c.path(c.keyPath, domain)
+	if err != nil {
+		return "", "", err
+	}
+	return certPath, keyPath, nil
+}
+
+// path renders a path template for a domain
+func (c *client) path(t *template.Template, domain string) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, &pathData{Domain: domain}); err != nil {
+		return "", logger.Errore(err)
+	}
+	return filepath.Join(c.config.Dir, buf.String()), nil
+}
+
+// findDomains finds the domains which have certificate files, by matching the certificate path
+// template with the domain replaced by a wildcard
+func (c *client) findDomains() ([]string, error) {
+	const marker = "\x00"
+	rendered, err := c.path(c.certPath, marker)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(rendered, marker)
+	if len(parts) < 2 {
+		return nil, logger.Error("certificate path template must include the domain", golog.String("template", c.config.CertPath))
+	}
+	patterns := make([]string, len(parts))
+	for i, part := range parts {
+		patterns[i] = escapeGlob(part)
+	}
+	matches, err := filepath.Glob(strings.Join(patterns, "*"))
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+
+	prefix, suffix := parts[0], parts[1]
+	var domains []string
+	for _, match := range matches {
+		domain := strings.TrimPrefix(match, prefix)
+		if i := strings.Index(domain, suffix); suffix != "" && i >= 0 {
+			domain = domain[:i]
+		}
+		// check the domain gives the same path, in case the template uses it more than once
+		if path, err := c.path(c.certPath, domain); err == nil && path == match {
+			domains = append(domains, domain)
+		}
+	}
+	return domains, nil
+}
+
+// parsePathTemplate parses a path template and checks that it depends on the domain
+func parsePathTemplate(name string, text string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, logger.Errorex("invalid path template", err, golog.String("template", text))
+	}
+	var a, b bytes.Buffer
+	if err := t.Execute(&a, &pathData{Domain: "a"}); err != nil {
+		return nil, logger.Errorex("invalid path template", err, golog.String("template", text))
+	}
+	if err := t.Execute(&b, &pathData{Domain: "b"}); err != nil {
+		return nil, logger.Errorex("invalid path template", err, golog.String("template", text))
+	}
+	if a.String() == b.String() {
+		return nil, logger.Error("path template must use {{.Domain}}", golog.String("template", text))
+	}
+	return t, nil
+}
+
+// escapeGlob escapes the characters in s which have a special meaning in a glob pattern
+func escapeGlob(s string) string {
+	var buf strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			buf.WriteRune('\\')
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
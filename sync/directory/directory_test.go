@@ -0,0 +1,153 @@
+package directory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stugotech/coyote/sync"
+)
+
+func TestPutAndGetHosts(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	external, err := NewClient(&Config{Dir: dir, CertPath: "certs/{{.Domain}}.crt", KeyPath: "keys/{{.Domain}}.key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, domain := range []string{"example.com", "*.example.org"} {
+		host := &sync.Host{Domain: domain, CertificatePEM: "cert " + domain, PrivateKeyPEM: "key " + domain}
+		if err := external.PutHost(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, mode := range map[string]os.FileMode{"certs/example.com.crt": 0644, "keys/example.com.key": 0600} {
+		info, err := os.Stat(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("%s: got mode %v, want %v", path, info.Mode().Perm(), mode)
+		}
+	}
+
+	hosts, err := external.GetHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 {
+		t.Fatalf("got %d hosts, want 2", len(hosts))
+	}
+	for _, host := range hosts {
+		if host.CertificatePEM != "cert "+host.Domain || host.PrivateKeyPEM != "key "+host.Domain {
+			t.Errorf("%s: got host %+v, want the files that were put", host.Domain, host)
+		}
+	}
+	if host, err := external.GetHost("missing.example.com"); err != nil || host != nil {
+		t.Errorf("got host %+v and error %v, want none", host, err)
+	}
+}
+
+func TestPutHostKeepsOldPairOnFailure(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	external, err := NewClient(&Config{Dir: dir, CertPath: "certs/{{.Domain}}.crt", KeyPath: "keys/{{.Domain}}.key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := external.PutHost(&sync.Host{Domain: "example.com", CertificatePEM: "old cert", PrivateKeyPEM: "old key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the key can't be written, so the certificate mustn't be replaced either
+	if err := os.RemoveAll(filepath.Join(dir, "keys")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "keys"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := external.PutHost(&sync.Host{Domain: "example.com", CertificatePEM: "new cert", PrivateKeyPEM: "new key"}); err == nil {
+		t.Fatal("expected error writing the key")
+	}
+	cert, err := ioutil.ReadFile(filepath.Join(dir, "certs/example.com.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(cert) != "old cert" {
+		t.Errorf("got certificate %q, want the old one", cert)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(dir, "certs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("got %d files, want the temporary files removed", len(files))
+	}
+}
+
+func TestCommitRunsReloadCommandOnce(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	counter := filepath.Join(dir, "reloads")
+	external, err := NewClient(&Config{Dir: dir, ReloadCommand: "echo >> " + counter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	committer := external.(sync.Committer)
+
+	host := &sync.Host{Domain: "example.com", CertificatePEM: "cert", PrivateKeyPEM: "key"}
+	for i := 0; i < 2; i++ {
+		// the second put doesn't change anything, so doesn't reload
+		if err := external.PutHost(host); err != nil {
+			t.Fatal(err)
+		}
+		if err := committer.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := ioutil.ReadFile(counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "\n" {
+		t.Errorf("got %d reloads, want 1", len(data))
+	}
+
+	// a failed reload is retried by the next commit
+	failing, err := NewClient(&Config{Dir: dir, ReloadCommand: "exit 1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := failing.PutHost(&sync.Host{Domain: "example.org", CertificatePEM: "cert", PrivateKeyPEM: "key"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := failing.(sync.Committer).Commit(); err == nil {
+			t.Errorf("commit %d: expected error from the reload command", i+1)
+		}
+	}
+}
+
+func TestNewClientRejectsPathsWithoutDomain(t *testing.T) {
+	for _, config := range []*Config{
+		{CertPath: "fullchain.pem"},
+		{KeyPath: "privkey.pem"},
+		{CertPath: "{{.Missing}}/fullchain.pem"},
+		{CertPath: "{{.Domain"},
+	} {
+		if _, err := NewClient(config); err == nil {
+			t.Errorf("%+v: expected error", config)
+		}
+	}
+}
+
+// newTestDir creates a temporary directory for the files
+func newTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
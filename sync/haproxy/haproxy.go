@@ -0,0 +1,382 @@
+package haproxy
+
+import (
+	"bufio"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/stugotech/coyote/export"
+	"github.com/stugotech/coyote/sync"
+	"github.com/stugotech/golog"
+)
+
+var logger = golog.NewPackageLogger()
+
+// socketTimeout limits how long a runtime API command can take
+const socketTimeout = 30 * time.Second
+
+// Config describes where HAProxy reads certificates from and how to reach its runtime API
+type Config struct {
+	// Dir is the directory that the combined certificate and key files are written to
+	Dir string `mapstructure:"dir"`
+	// CrtList is the path of the crt-list file which lists the certificate files, as given to the
+	// crt-list option of the bind line
+	CrtList string `mapstructure:"crt-list"`
+	// Socket is the address of the runtime API (stats socket), either the path of a unix socket or
+	// host:port.  If empty, the files are written but HAProxy is not updated until it is reloaded.
+	Socket string `mapstructure:"socket"`
+}
+
+// client is an implementation of the Client interface for HAProxy
+type client struct {
+	config *Config
+}
+
+// NewClient creates a client which writes certificates for HAProxy and updates it over the runtime
+// API without a reload
+func NewClient(config *Config) sync.Client {
+	return &client{config: config}
+}
+
+// GetHosts returns the hosts in the crt-list
+func (c *client) GetHosts() ([]*sync.Host, error) {
+	entries, err := c.readCrtList()
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	var hosts []*sync.Host
+	for _, entry := range entries {
+		host, err := readHost(entry.domain, entry.path)
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+		if host != nil {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, nil
+}
+
+// GetHost returns a single host from the certificate file the crt-list has for the domain, or nil if
+// the crt-list doesn't have the domain or the file doesn't exist
+func (c *client) GetHost(domain string) (*sync.Host, error) {
+	entry, err := c.findEntry(domain)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	return readHost(domain, entry.path)
+}
+
+// PutHost writes the combined certificate file for a host and updates HAProxy over the runtime API.
+// The file that the crt-list has for the domain is replaced; if it has none, a new file is written
+// and added to the crt-list.  The file is only replaced once the running HAProxy has committed the
+// certificate, so that a failed update is still seen as out of date.
+func (c *client) PutHost(host *sync.Host) error {
+	entry, err := c.findEntry(host.Domain)
+	if err != nil {
+		return logger.Errore(err)
+	}
+	path := c.certPath(host.Domain)
+	if entry != nil {
+		path = entry.path
+	}
+	combined := combinedPEM(host)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return logger.Errore(err)
+	}
+	tmp, err := export.StageFile(path, []byte(combined), export.DefaultKeyMode)
+	if err != nil {
+		return logger.Errore(err)
+	}
+	defer os.Remove(tmp)
+
+	if c.config.Socket != "" {
+		if err := c.updateRuntime(host.Domain, path, combined); err != nil {
+			// the error is returned as it is, so that socket failures are retried
+			logger.Errorex("unable to update HAProxy over runtime API", err, golog.String("domain", host.Domain))
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return logger.Errorex("unable to write file", err, golog.String("path", path))
+	}
+	if entry == nil {
+		if err := c.addToCrtList(host.Domain, path); err != nil {
+			return logger.Errore(err)
+		}
+	}
+	logger.Info("wrote HAProxy certificate", golog.String("domain", host.Domain), golog.String("path", path))
+	return nil
+}
+
+// updateRuntime replaces the certificate in the running HAProxy, creating it first if it isn't
+// loaded and adding it to the crt-list if it isn't listed.  A failed transaction is aborted, so that
+// the runtime is left as it was.
+func (c *client) updateRuntime(domain string, path string, combined string) error {
+	loaded, err := c.isLoaded(path)
+	if err != nil {
+		return err
+	}
+	if !loaded {
+		if _, err := c.command("new ssl cert "+path, "New empty certificate store"); err != nil {
+			return err
+		}
+	}
+	if _, err := c.command(payload("set ssl cert "+path, combined), "Transaction"); err != nil {
+		c.abort(path)
+		return err
+	}
+	if _, err := c.command("commit ssl cert "+path, "Success"); err != nil {
+		c.abort(path)
+		return err
+	}
+
+	listed, err := c.isListed(path)
+	if err != nil {
+		return err
+	}
+	if !listed {
+		if _, err := c.command(payload("add ssl crt-list "+c.config.CrtList, crtListLine(domain, path)), "Success"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// abort aborts the runtime transaction of a certificate file, if there is one
+func (c *client) abort(path string) {
+	if _, err := c.command("abort ssl cert "+path, ""); err != nil {
+		logger.Errorex("unable to abort HAProxy transaction", err, golog.String("path", path))
+	}
+}
+
+// isLoaded returns true if the certificate file is loaded in the running HAProxy
+func (c *client) isLoaded(path string) (bool, error) {
+	output, err := c.command("show ssl cert", "")
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimPrefix(strings.TrimSpace(line), "*") == path {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isListed returns true if the certificate file is in the crt-list of the running HAProxy
+func (c *client) isListed(path string) (bool, error) {
+	output, err := c.command("show ssl crt-list "+c.config.CrtList, "")
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if entry := parseCrtListLine(line); entry != nil && entry.path == path {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// command runs a runtime API command, and checks that the output contains the expected text.  A
+// failure to talk to the runtime API is returned as a sync.TransientError.
+func (c *client) command(cmd string, expect string) (string, error) {
+	network := "unix"
+	if !strings.HasPrefix(c.config.Socket, "/") && strings.Contains(c.config.Socket, ":") {
+		network = "tcp"
+	}
+	conn, err := net.DialTimeout(network, c.config.Socket, socketTimeout)
+	if err != nil {
+		return "", &sync.TransientError{Err: logger.Errore(err)}
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(socketTimeout))
+
+	if !strings.HasSuffix(cmd, "\n") {
+		cmd += "\n"
+	}
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		return "", &sync.TransientError{Err: logger.Errore(err)}
+	}
+	// HAProxy closes the connection after answering a single command
+	output, err := ioutil.ReadAll(bufio.NewReader(conn))
+	if err != nil {
+		return "", &sync.TransientError{Err: logger.Errore(err)}
+	}
+
+	name := strings.SplitN(cmd, " <<", 2)[0]
+	logger.Debug("ran HAProxy runtime command", golog.String("command", strings.TrimSpace(name)))
+	if expect != "" && !strings.Contains(string(output), expect) {
+		return "", logger.Error("HAProxy runtime command failed",
+			golog.String("command", strings.TrimSpace(name)),
+			golog.String("output", strings.TrimSpace(string(output))),
+		)
+	}
+	return string(output), nil
+}
+
+// payload gets a runtime API command which is followed by lines of data; the data ends at the first
+// empty line
+func payload(cmd string, data string) string {
+	return cmd + " <<\n" + strings.TrimRight(data, "\n") + "\n\n"
+}
+
+// crtListEntry is a certificate file and the domain it is used for
+type crtListEntry struct {
+	path   string
+	domain string
+}
+
+// readCrtList reads the entries in the crt-list file
+func (c *client) readCrtList() ([]*crtListEntry, error) {
+	data, err := ioutil.ReadFile(c.config.CrtList)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	var entries []*crtListEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		if entry := parseCrtListLine(line); entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// parseCrtListLine parses a crt-list line, which is the certificate file followed by optional SSL
+// options in brackets and then the SNI filters; the first positive filter is the domain.  Nil is
+// returned for empty lines and comments.
+func parseCrtListLine(line string) *crtListEntry {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return nil
+	}
+	entry := &crtListEntry{path: fields[0]}
+
+	rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), fields[0]))
+	if strings.HasPrefix(rest, "[") {
+		// the options may contain spaces, so skip up to the closing bracket
+		if i := strings.Index(rest, "]"); i >= 0 {
+			rest = rest[i+1:]
+		} else {
+			rest = ""
+		}
+	}
+	for _, f := range strings.Fields(rest) {
+		if !strings.HasPrefix(f, "!") {
+			entry.domain = f
+			break
+		}
+	}
+	if entry.domain == "" {
+		entry.domain = domainFromFileName(filepath.Base(entry.path))
+	}
+	return entry
+}
+
+// findEntry gets the crt-list entry for a domain, or nil if the crt-list doesn't have it
+func (c *client) findEntry(domain string) (*crtListEntry, error) {
+	entries, err := c.readCrtList()
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	for _, entry := range entries {
+		if entry.domain == domain {
+			return entry, nil
+		}
+	}
+	return nil, nil
+}
+
+// addToCrtList adds the certificate file to the crt-list if it isn't already listed
+func (c *client) addToCrtList(domain string, path string) error {
+	entries, err := c.readCrtList()
+	if err != nil {
+		return logger.Errore(err)
+	}
+	for _, entry := range entries {
+		if entry.path == path {
+			return nil
+		}
+	}
+
+	data, err := ioutil.ReadFile(c.config.CrtList)
+	if err != nil && !os.IsNotExist(err) {
+		return logger.Errore(err)
+	}
+	if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+		data = append(data, '\n')
+	}
+	data = append(data, crtListLine(domain, path)+"\n"...)
+	if err := export.WriteFile(c.config.CrtList, data, export.DefaultFileMode); err != nil {
+		return logger.Errore(err)
+	}
+	return nil
+}
+
+// certPath gets the path of the combined certificate file for a domain
+func (c *client) certPath(domain string) string {
+	return filepath.Join(c.config.Dir, fileName(domain))
+}
+
+// crtListLine gets the crt-list line which uses the certificate file for the domain
+func crtListLine(domain string, path string) string {
+	return fmt.Sprintf("%s %s", path, domain)
+}
+
+// fileName gets the name of the combined certificate file for a domain; the wildcard label is
+// renamed so that the file name doesn't need quoting
+func fileName(domain string) string {
+	return strings.Replace(domain, "*", "_wildcard", 1) + ".pem"
+}
+
+// domainFromFileName reverses fileName
+func domainFromFileName(name string) string {
+	return strings.Replace(strings.TrimSuffix(name, ".pem"), "_wildcard", "*", 1)
+}
+
+// combinedPEM gets the certificate chain followed by the private key, as HAProxy expects
+func combinedPEM(host *sync.Host) string {
+	chain := strings.TrimRight(host.CertificatePEM, "\n") + "\n"
+	return chain + host.PrivateKeyPEM
+}
+
+// readHost reads a combined certificate file, or returns nil if it doesn't exist
+func readHost(domain string, path string) (*sync.Host, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+
+	host := &sync.Host{Domain: domain}
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		encoded := string(pem.EncodeToMemory(block))
+		if block.Type == "CERTIFICATE" {
+			host.CertificatePEM += encoded
+		} else if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			host.PrivateKeyPEM += encoded
+		}
+	}
+	return host, nil
+}
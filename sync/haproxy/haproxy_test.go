@@ -0,0 +1,343 @@
+package haproxy
+
+import (
+	"bufio"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	gosync "sync"
+	"testing"
+
+	"github.com/stugotech/coyote/sync"
+)
+
+func TestParseCrtListLine(t *testing.T) {
+	tests := []struct {
+		line   string
+		path   string
+		domain string
+	}{
+		{"", "", ""},
+		{"   ", "", ""},
+		{"# comment", "", ""},
+		{"/etc/haproxy/certs/example.com.pem", "/etc/haproxy/certs/example.com.pem", "example.com"},
+		{"/certs/_wildcard.example.com.pem", "/certs/_wildcard.example.com.pem", "*.example.com"},
+		{"/certs/a.pem www.example.com", "/certs/a.pem", "www.example.com"},
+		{"/certs/a.pem [alpn h2] www.example.com", "/certs/a.pem", "www.example.com"},
+		{"/certs/a.pem [alpn h2 ssl-min-ver TLSv1.2] example.com", "/certs/a.pem", "example.com"},
+		{"  /certs/a.pem   [ alpn h2,http/1.1 ]   example.com other.example.com", "/certs/a.pem", "example.com"},
+		{"/certs/a.pem [ocsp-update on] !www.example.com *.example.com", "/certs/a.pem", "*.example.com"},
+		{"/certs/example.com.pem [alpn h2 ssl-min-ver TLSv1.2]", "/certs/example.com.pem", "example.com"},
+		{"/certs/example.com.pem !www.example.com", "/certs/example.com.pem", "example.com"},
+	}
+	for _, test := range tests {
+		entry := parseCrtListLine(test.line)
+		if test.path == "" {
+			if entry != nil {
+				t.Errorf("%q: got entry %+v, want none", test.line, entry)
+			}
+			continue
+		}
+		if entry == nil {
+			t.Errorf("%q: got no entry", test.line)
+			continue
+		}
+		if entry.path != test.path || entry.domain != test.domain {
+			t.Errorf("%q: got path %q and domain %q, want %q and %q", test.line, entry.path, entry.domain, test.path, test.domain)
+		}
+	}
+}
+
+// fakeRuntime is a runtime API on a unix socket which keeps the certificates and crt-list in memory
+type fakeRuntime struct {
+	listener net.Listener
+	crtList  string
+	mu       gosync.Mutex
+	// commands are the names of the commands run, without their arguments
+	commands []string
+	// loaded are the certificate files, and listed the crt-list lines
+	loaded map[string]string
+	listed []string
+	// fail makes the command fail
+	fail string
+	// pending is the certificate data of the open transaction, by path
+	pending map[string]string
+}
+
+func newFakeRuntime(t *testing.T, dir string, crtList string) *fakeRuntime {
+	listener, err := net.Listen("unix", filepath.Join(dir, "admin.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRuntime{
+		listener: listener,
+		crtList:  crtList,
+		loaded:   make(map[string]string),
+		pending:  make(map[string]string),
+	}
+	go r.serve()
+	return r
+}
+
+func (r *fakeRuntime) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		reader := bufio.NewReader(conn)
+		line, _ := reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+		var data string
+		if strings.HasSuffix(line, " <<") {
+			line = strings.TrimSuffix(line, " <<")
+			for {
+				l, err := reader.ReadString('\n')
+				if err != nil || l == "\n" {
+					break
+				}
+				data += l
+			}
+		}
+		conn.Write([]byte(r.run(line, data)))
+		conn.Close()
+	}
+}
+
+// run runs a command and returns its output, as HAProxy 2.x does
+func (r *fakeRuntime) run(line string, data string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fields := strings.Fields(line)
+	name := strings.Join(fields[:len(fields)-1], " ")
+	arg := fields[len(fields)-1]
+	if line == "show ssl cert" {
+		name, arg = line, ""
+	}
+	r.commands = append(r.commands, name)
+	if name == r.fail {
+		return "Can't do that!\n"
+	}
+
+	switch name {
+	case "show ssl cert":
+		out := "# filename\n"
+		for path := range r.loaded {
+			out += path + "\n"
+		}
+		return out
+	case "show ssl crt-list":
+		return "# " + arg + "\n" + strings.Join(r.listed, "\n") + "\n"
+	case "new ssl cert":
+		r.loaded[arg] = ""
+		return "New empty certificate store '" + arg + "'!\n"
+	case "set ssl cert":
+		r.pending[arg] = data
+		return "Transaction created for certificate " + arg + "!\n"
+	case "commit ssl cert":
+		r.loaded[arg] = r.pending[arg]
+		delete(r.pending, arg)
+		return "Committing " + arg + "\nSuccess!\n"
+	case "abort ssl cert":
+		delete(r.pending, arg)
+		return "Transaction aborted for certificate '" + arg + "'!\n"
+	case "add ssl crt-list":
+		if arg != r.crtList {
+			return "crt-list '" + arg + "' does not exist!\n"
+		}
+		r.listed = append(r.listed, strings.TrimSpace(data))
+		return "Inserting certificate '" + strings.Fields(data)[0] + "' in crt-list '" + arg + "'.\nSuccess!\n"
+	}
+	return "Unknown command\n"
+}
+
+// takeCommands returns the commands run since it was last called
+func (r *fakeRuntime) takeCommands() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	commands := r.commands
+	r.commands = nil
+	return commands
+}
+
+func TestPutHostUpdatesRuntime(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	crtList := filepath.Join(dir, "crt-list")
+	runtime := newFakeRuntime(t, dir, crtList)
+	defer runtime.listener.Close()
+	external := NewClient(&Config{Dir: filepath.Join(dir, "certs"), CrtList: crtList, Socket: runtime.listener.Addr().String()})
+
+	host := newTestHost("example.com", "first")
+	if err := external.PutHost(host); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"show ssl cert", "new ssl cert", "set ssl cert", "commit ssl cert", "show ssl crt-list", "add ssl crt-list"}
+	if got := runtime.takeCommands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got commands %v, want %v", got, want)
+	}
+	path := filepath.Join(dir, "certs", "example.com.pem")
+	if runtime.loaded[path] != combinedPEM(host) {
+		t.Errorf("got runtime certificate %q, want the host", runtime.loaded[path])
+	}
+	assertHost(t, external, host)
+
+	// a loaded certificate is replaced without being added again
+	host = newTestHost("example.com", "second")
+	if err := external.PutHost(host); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"show ssl cert", "set ssl cert", "commit ssl cert", "show ssl crt-list"}
+	if got := runtime.takeCommands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got commands %v, want %v", got, want)
+	}
+	assertHost(t, external, host)
+	if lines := readLines(t, crtList); len(lines) != 1 {
+		t.Errorf("got crt-list %v, want one line", lines)
+	}
+}
+
+func TestPutHostRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		fail     string
+		commands []string
+	}{
+		{"new ssl cert", []string{"show ssl cert", "new ssl cert"}},
+		{"set ssl cert", []string{"show ssl cert", "new ssl cert", "set ssl cert", "abort ssl cert"}},
+		{"commit ssl cert", []string{"show ssl cert", "new ssl cert", "set ssl cert", "commit ssl cert", "abort ssl cert"}},
+		{"add ssl crt-list", []string{"show ssl cert", "new ssl cert", "set ssl cert", "commit ssl cert", "show ssl crt-list", "add ssl crt-list"}},
+	}
+	for _, test := range tests {
+		dir := newTestDir(t)
+		defer os.RemoveAll(dir)
+		crtList := filepath.Join(dir, "crt-list")
+		runtime := newFakeRuntime(t, dir, crtList)
+		defer runtime.listener.Close()
+		runtime.fail = test.fail
+		external := NewClient(&Config{Dir: filepath.Join(dir, "certs"), CrtList: crtList, Socket: runtime.listener.Addr().String()})
+
+		err := external.PutHost(newTestHost("example.com", "first"))
+		if err == nil || sync.IsTransient(err) {
+			t.Errorf("%s: got error %v, want a failed command", test.fail, err)
+		}
+		if got := runtime.takeCommands(); !reflect.DeepEqual(got, test.commands) {
+			t.Errorf("%s: got commands %v, want %v", test.fail, got, test.commands)
+		}
+		// the host isn't written, so it is still seen as out of date
+		if host, err := external.GetHost("example.com"); err != nil || host != nil {
+			t.Errorf("%s: got host %+v and error %v, want none", test.fail, host, err)
+		}
+	}
+}
+
+func TestPutHostSocketFailureIsTransient(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	external := NewClient(&Config{Dir: dir, CrtList: filepath.Join(dir, "crt-list"), Socket: filepath.Join(dir, "missing.sock")})
+	if err := external.PutHost(newTestHost("example.com", "first")); !sync.IsTransient(err) {
+		t.Errorf("got error %v, want a transient error", err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("got %d files, want none written", len(files))
+	}
+}
+
+func TestPutHostUsesCrtListEntry(t *testing.T) {
+	dir := newTestDir(t)
+	defer os.RemoveAll(dir)
+	crtList := filepath.Join(dir, "crt-list")
+	existing := filepath.Join(dir, "site.pem")
+	lines := []string{
+		"# managed by hand",
+		existing + " [alpn h2] www.example.com",
+	}
+	if err := ioutil.WriteFile(crtList, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(existing, []byte(combinedPEM(newTestHost("www.example.com", "old"))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	external := NewClient(&Config{Dir: filepath.Join(dir, "certs"), CrtList: crtList})
+
+	host, err := external.GetHost("www.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host == nil {
+		t.Fatal("got no host, want the one in the crt-list")
+	}
+
+	// the listed file is replaced and the crt-list is left alone
+	host = newTestHost("www.example.com", "new")
+	if err := external.PutHost(host); err != nil {
+		t.Fatal(err)
+	}
+	assertHost(t, external, host)
+	if got := readLines(t, crtList); !reflect.DeepEqual(got, lines) {
+		t.Errorf("got crt-list %v, want %v", got, lines)
+	}
+
+	// a new domain is written to the directory and added
+	other := newTestHost("*.example.org", "new")
+	if err := external.PutHost(other); err != nil {
+		t.Fatal(err)
+	}
+	want := append(lines, filepath.Join(dir, "certs", "_wildcard.example.org.pem")+" *.example.org")
+	if got := readLines(t, crtList); !reflect.DeepEqual(got, want) {
+		t.Errorf("got crt-list %v, want %v", got, want)
+	}
+	hosts, err := external.GetHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 {
+		t.Errorf("got %d hosts, want 2", len(hosts))
+	}
+}
+
+// assertHost checks that the client returns the host
+func assertHost(t *testing.T, external sync.Client, want *sync.Host) {
+	host, err := external.GetHost(want.Domain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host == nil || host.CertificatePEM != want.CertificatePEM || host.PrivateKeyPEM != want.PrivateKeyPEM {
+		t.Errorf("got host %+v, want %+v", host, want)
+	}
+}
+
+// readLines reads the lines of a file
+func readLines(t *testing.T, path string) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+// newTestDir creates a temporary directory; unix socket paths are limited in length, so it is
+// kept short
+func newTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "hap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// newTestHost creates a host with PEM blocks which tell it apart by the label
+func newTestHost(domain string, label string) *sync.Host {
+	return &sync.Host{
+		Domain:         domain,
+		CertificatePEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte(domain + " " + label)})),
+		PrivateKeyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: []byte(label)})),
+	}
+}
@@ -0,0 +1,320 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/stugotech/coyote/sync"
+	"github.com/stugotech/golog"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+var logger = golog.NewPackageLogger()
+
+// Labels and annotations set on the secrets written by coyote
+const (
+	ManagedByLabel    = "app.kubernetes.io/managed-by"
+	ManagedByValue    = "coyote"
+	ThumbprintLabel   = "coyote.stugotech.com/thumbprint"
+	DomainAnnotation  = "coyote.stugotech.com/domain"
+	ExpiresAnnotation = "coyote.stugotech.com/expires"
+)
+
+// requestTimeout limits how long each request to the Kubernetes API can take
+const requestTimeout = 30 * time.Second
+
+// Config describes where secrets are written
+type Config struct {
+	// Namespaces are the namespaces which each secret is written to
+	Namespaces []string `mapstructure:"namespaces"`
+	// NamePrefix is added to the start of each secret's name
+	NamePrefix string `mapstructure:"name-prefix"`
+	// Cleanup deletes the secrets of certificates which have been deleted from the store
+	Cleanup bool `mapstructure:"cleanup"`
+}
+
+// client is an implementation of the Client interface which writes kubernetes.io/tls secrets
+type client struct {
+	config    *Config
+	clientset kubernetes.Interface
+}
+
+// NewClient creates a client which writes secrets using the given clientset
+func NewClient(clientset kubernetes.Interface, config *Config) sync.Client {
+	if len(config.Namespaces) == 0 {
+		config.Namespaces = []string{metav1.NamespaceDefault}
+	}
+	return &client{
+		config:    config,
+		clientset: clientset,
+	}
+}
+
+// NewClientFromKubeconfig creates a client which connects to the cluster in the kubeconfig file, or
+// to the cluster it is running in if kubeconfig is empty
+func NewClientFromKubeconfig(kubeconfig string, config *Config) (sync.Client, error) {
+	var restConfig *rest.Config
+	var err error
+	if kubeconfig == "" {
+		restConfig, err = rest.InClusterConfig()
+	} else {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	if err != nil {
+		return nil, logger.Errorex("unable to load Kubernetes client configuration", err)
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	return NewClient(clientset, config), nil
+}
+
+// GetHosts returns the hosts with secrets written by coyote in any of the namespaces.  A host whose
+// secret is missing from some of the namespaces or differs between them is returned without a
+// certificate, so that it is written again, as GetHost does.
+func (c *client) GetHosts() ([]*sync.Host, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	var hosts []*sync.Host
+	byDomain := make(map[string]*sync.Host)
+	counts := make(map[string]int)
+	for _, namespace := range c.config.Namespaces {
+		secrets, err := c.clientset.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: ManagedByLabel + "=" + ManagedByValue,
+		})
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+		for i := range secrets.Items {
+			host := secretHost(&secrets.Items[i])
+			existing, ok := byDomain[host.Domain]
+			if !ok {
+				byDomain[host.Domain] = host
+				hosts = append(hosts, host)
+			} else if existing.CertificatePEM != host.CertificatePEM {
+				existing.CertificatePEM, existing.PrivateKeyPEM = "", ""
+			}
+			counts[host.Domain]++
+		}
+	}
+	for _, host := range hosts {
+		if counts[host.Domain] != len(c.config.Namespaces) {
+			host.CertificatePEM, host.PrivateKeyPEM = "", ""
+		}
+	}
+	return hosts, nil
+}
+
+// GetHost returns a single host, or nil if its secret is missing from any of the namespaces or
+// differs between them, so that it is written again
+func (c *client) GetHost(domain string) (*sync.Host, error) {
+	var host *sync.Host
+	for _, namespace := range c.config.Namespaces {
+		secret, err := c.getSecret(namespace, domain)
+		if err != nil {
+			// getSecret has logged the error, and marked it if it is transient
+			return nil, err
+		}
+		if secret == nil {
+			return nil, nil
+		}
+		if host != nil && string(secret.Data[corev1.TLSCertKey]) != host.CertificatePEM {
+			return nil, nil
+		}
+		host = secretHost(secret)
+	}
+	return host, nil
+}
+
+// PutHost creates or updates the secret for a host in each namespace
+func (c *client) PutHost(host *sync.Host) error {
+	certs, err := host.DecodeCertificates()
+	if err != nil {
+		return logger.Errore(err)
+	}
+	thumbprint, err := host.Thumbprint()
+	if err != nil {
+		return logger.Errore(err)
+	}
+	name := c.secretName(host.Domain)
+
+	var failed []string
+	transient := true
+	for _, namespace := range c.config.Namespaces {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels: map[string]string{
+					ManagedByLabel:  ManagedByValue,
+					ThumbprintLabel: thumbprint,
+				},
+				Annotations: map[string]string{
+					DomainAnnotation:  host.Domain,
+					ExpiresAnnotation: certs[0].NotAfter.UTC().Format(time.RFC3339),
+				},
+			},
+			Type: corev1.SecretTypeTLS,
+			Data: map[string][]byte{
+				corev1.TLSCertKey:       []byte(host.CertificatePEM),
+				corev1.TLSPrivateKeyKey: []byte(host.PrivateKeyPEM),
+			},
+		}
+		if err := c.putSecret(secret, thumbprint); err != nil {
+			logger.Errorex("unable to write secret", err,
+				golog.String("namespace", namespace),
+				golog.String("name", name),
+			)
+			failed = append(failed, namespace)
+			transient = transient && sync.IsTransient(err)
+		}
+	}
+	if len(failed) > 0 {
+		err := logger.Error("unable to write secret in all namespaces",
+			golog.String("domain", host.Domain),
+			golog.Strings("namespaces", failed),
+		)
+		if transient {
+			// the secrets which were written are left alone when this is retried
+			return &sync.TransientError{Err: err}
+		}
+		return err
+	}
+	return nil
+}
+
+// DeleteHost deletes the secret for a host from each namespace, if cleanup is enabled
+func (c *client) DeleteHost(domain string) error {
+	if !c.config.Cleanup {
+		logger.Info("not deleting secret as cleanup is disabled", golog.String("domain", domain))
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	for _, namespace := range c.config.Namespaces {
+		secret, err := c.getSecret(namespace, domain)
+		if err != nil {
+			return logger.Errore(err)
+		}
+		if secret == nil {
+			continue
+		}
+		if secret.Labels[ManagedByLabel] != ManagedByValue {
+			logger.Info("not deleting secret which isn't managed by coyote",
+				golog.String("namespace", namespace),
+				golog.String("name", secret.Name),
+			)
+			continue
+		}
+		err = c.clientset.CoreV1().Secrets(namespace).Delete(ctx, secret.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return logger.Errore(err)
+		}
+		logger.Info("deleted secret", golog.String("namespace", namespace), golog.String("name", secret.Name))
+	}
+	return nil
+}
+
+// putSecret creates the secret, or updates it if it has changed.  Secrets which weren't created
+// by coyote are left alone.
+func (c *client) putSecret(secret *corev1.Secret, thumbprint string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	secrets := c.clientset.CoreV1().Secrets(secret.Namespace)
+
+	existing, err := c.getSecret(secret.Namespace, secret.Annotations[DomainAnnotation])
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		if _, err := secrets.Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+			return apiError(err)
+		}
+		logger.Info("created secret", golog.String("namespace", secret.Namespace), golog.String("name", secret.Name))
+		return nil
+	}
+
+	if existing.Labels[ManagedByLabel] != ManagedByValue {
+		return logger.Error("secret exists and isn't managed by coyote",
+			golog.String("namespace", secret.Namespace),
+			golog.String("name", secret.Name),
+		)
+	}
+	if existing.Labels[ThumbprintLabel] == thumbprint &&
+		string(existing.Data[corev1.TLSPrivateKeyKey]) == string(secret.Data[corev1.TLSPrivateKeyKey]) {
+		logger.Debug("secret is up to date", golog.String("namespace", secret.Namespace), golog.String("name", secret.Name))
+		return nil
+	}
+
+	// keep any labels and annotations added by others
+	for k, v := range existing.Labels {
+		if _, ok := secret.Labels[k]; !ok {
+			secret.Labels[k] = v
+		}
+	}
+	for k, v := range existing.Annotations {
+		if _, ok := secret.Annotations[k]; !ok {
+			secret.Annotations[k] = v
+		}
+	}
+	secret.ResourceVersion = existing.ResourceVersion
+	if _, err := secrets.Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		return apiError(err)
+	}
+	logger.Info("updated secret", golog.String("namespace", secret.Namespace), golog.String("name", secret.Name))
+	return nil
+}
+
+// getSecret gets the secret for a domain, or nil if it doesn't exist
+func (c *client) getSecret(namespace string, domain string) (*corev1.Secret, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	secret, err := c.clientset.CoreV1().Secrets(namespace).Get(ctx, c.secretName(domain), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, apiError(err)
+	}
+	return secret, nil
+}
+
+// apiError logs an error from the Kubernetes API, marking it as transient if the request could
+// succeed if it is made again
+func apiError(err error) error {
+	if apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) || apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServiceUnavailable(err) || apierrors.IsInternalError(err) || apierrors.IsConflict(err) {
+		logger.Errorex("transient Kubernetes API error", err)
+		return &sync.TransientError{Err: err}
+	}
+	return logger.Errore(err)
+}
+
+// secretName gets the name of the secret for a domain; the wildcard label is renamed as secret
+// names may only contain lower case letters, digits, '-' and '.'
+func (c *client) secretName(domain string) string {
+	return c.config.NamePrefix + strings.ToLower(strings.Replace(domain, "*", "wildcard", 1))
+}
+
+// secretHost gets the host stored in a secret
+func secretHost(secret *corev1.Secret) *sync.Host {
+	domain := secret.Annotations[DomainAnnotation]
+	if domain == "" {
+		domain = secret.Name
+	}
+	return &sync.Host{
+		Domain:         domain,
+		CertificatePEM: string(secret.Data[corev1.TLSCertKey]),
+		PrivateKeyPEM:  string(secret.Data[corev1.TLSPrivateKeyKey]),
+	}
+}
@@ -0,0 +1,209 @@
+package kubernetes
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stugotech/coyote/sync"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPutHostCreatesSecretInEachNamespace(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	c := NewClient(clientset, &Config{Namespaces: []string{"a", "b"}, NamePrefix: "tls-"})
+	host := newTestHost(t, "*.example.com")
+
+	if err := c.PutHost(host); err != nil {
+		t.Fatal(err)
+	}
+	thumbprint, _ := host.Thumbprint()
+	for _, namespace := range []string{"a", "b"} {
+		secret := getSecret(t, clientset, namespace, "tls-wildcard.example.com")
+		if secret == nil {
+			t.Fatalf("secret wasn't created in %s", namespace)
+		}
+		if secret.Type != corev1.SecretTypeTLS || secret.Labels[ManagedByLabel] != ManagedByValue ||
+			secret.Labels[ThumbprintLabel] != thumbprint || secret.Annotations[DomainAnnotation] != "*.example.com" {
+			t.Errorf("got secret %+v in %s", secret.ObjectMeta, namespace)
+		}
+		if string(secret.Data[corev1.TLSCertKey]) != host.CertificatePEM {
+			t.Errorf("got wrong certificate in %s", namespace)
+		}
+	}
+
+	got, err := c.GetHost("*.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.CertificatePEM != host.CertificatePEM || got.PrivateKeyPEM != host.PrivateKeyPEM {
+		t.Errorf("got host %+v, want the host put", got)
+	}
+}
+
+func TestPutHostUpdatesSecretWhenThumbprintChanges(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	c := NewClient(clientset, &Config{})
+	if err := c.PutHost(newTestHost(t, "example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	// labels added by others are kept
+	secret := getSecret(t, clientset, metav1.NamespaceDefault, "example.com")
+	secret.Labels["team"] = "web"
+	if _, err := clientset.CoreV1().Secrets(metav1.NamespaceDefault).Update(context.Background(), secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	renewed := newTestHost(t, "example.com")
+	if err := c.PutHost(renewed); err != nil {
+		t.Fatal(err)
+	}
+	thumbprint, _ := renewed.Thumbprint()
+	secret = getSecret(t, clientset, metav1.NamespaceDefault, "example.com")
+	if secret.Labels[ThumbprintLabel] != thumbprint || string(secret.Data[corev1.TLSCertKey]) != renewed.CertificatePEM {
+		t.Error("secret wasn't updated with the new certificate")
+	}
+	if secret.Labels["team"] != "web" {
+		t.Error("label added by others was removed")
+	}
+
+	// nothing is written if the secret is up to date
+	clientset.ClearActions()
+	if err := c.PutHost(renewed); err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() != "get" {
+			t.Errorf("got %s request for up to date secret", action.GetVerb())
+		}
+	}
+}
+
+func TestPutHostLeavesUnmanagedSecret(t *testing.T) {
+	unmanaged := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "example.com", Namespace: metav1.NamespaceDefault},
+		Type:       corev1.SecretTypeTLS,
+		Data:       map[string][]byte{corev1.TLSCertKey: []byte("theirs")},
+	}
+	clientset := fake.NewSimpleClientset(unmanaged)
+	c := NewClient(clientset, &Config{Cleanup: true})
+
+	if err := c.PutHost(newTestHost(t, "example.com")); err == nil {
+		t.Error("expected error writing over unmanaged secret")
+	}
+	if err := c.(sync.Deleter).DeleteHost("example.com"); err != nil {
+		t.Fatal(err)
+	}
+	secret := getSecret(t, clientset, metav1.NamespaceDefault, "example.com")
+	if secret == nil || string(secret.Data[corev1.TLSCertKey]) != "theirs" {
+		t.Error("unmanaged secret was changed")
+	}
+}
+
+func TestDeleteHostOnlyWithCleanup(t *testing.T) {
+	for _, cleanup := range []bool{false, true} {
+		clientset := fake.NewSimpleClientset()
+		c := NewClient(clientset, &Config{Namespaces: []string{"a", "b"}, Cleanup: cleanup})
+		if err := c.PutHost(newTestHost(t, "example.com")); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.(sync.Deleter).DeleteHost("example.com"); err != nil {
+			t.Fatal(err)
+		}
+		for _, namespace := range []string{"a", "b"} {
+			exists := getSecret(t, clientset, namespace, "example.com") != nil
+			if exists == cleanup {
+				t.Errorf("with cleanup %v, got secret exists %v in %s", cleanup, exists, namespace)
+			}
+		}
+	}
+}
+
+func TestGetHostsMergesNamespaces(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	c := NewClient(clientset, &Config{Namespaces: []string{"a", "b"}})
+	both := newTestHost(t, "example.com")
+	if err := c.PutHost(both); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutHost(newTestHost(t, "www.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	err := clientset.CoreV1().Secrets("b").Delete(context.Background(), "www.example.com", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hosts, err := c.GetHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 {
+		t.Fatalf("got %d hosts, want 2", len(hosts))
+	}
+	for _, host := range hosts {
+		switch host.Domain {
+		case "example.com":
+			if host.CertificatePEM != both.CertificatePEM {
+				t.Error("got wrong certificate for host in both namespaces")
+			}
+		case "www.example.com":
+			if host.CertificatePEM != "" {
+				t.Error("got certificate for host missing from a namespace, want none")
+			}
+		default:
+			t.Errorf("got unexpected host %s", host.Domain)
+		}
+	}
+}
+
+// getSecret gets a secret from the fake clientset, or nil if it doesn't exist
+func getSecret(t *testing.T, clientset *fake.Clientset, namespace string, name string) *corev1.Secret {
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(context.Background(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+// newTestHost creates a host with a new self-signed certificate for the domain
+func newTestHost(t *testing.T, domain string) *sync.Host {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &sync.Host{
+		Domain:         domain,
+		CertificatePEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		PrivateKeyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
@@ -0,0 +1,255 @@
+package sync
+
+import (
+	"sort"
+	"time"
+
+	"github.com/stugotech/coyote/store"
+	"github.com/stugotech/golog"
+)
+
+// ChangeType describes how a host differs from the store
+type ChangeType string
+
+// Change types
+const (
+	// HostMissing is a name of a certificate in the store which the external system doesn't have
+	HostMissing ChangeType = "missing"
+	// HostStale is a host with a different certificate to the one in the store
+	HostStale ChangeType = "stale"
+	// HostExpired is a host whose certificate has expired
+	HostExpired ChangeType = "expired"
+	// HostUnknown is a host which isn't a name of any certificate in the store
+	HostUnknown ChangeType = "unknown"
+)
+
+// Action is what applying a change does
+type Action string
+
+// Actions
+const (
+	// ActionPut pushes the certificate in the store to the host
+	ActionPut Action = "put"
+	// ActionNone leaves the host alone, e.g. because coyote doesn't know about it or the store has
+	// nothing newer
+	ActionNone Action = "none"
+)
+
+// Change is a difference between a host and the store
+type Change struct {
+	Domain string     `json:"domain" yaml:"domain"`
+	Type   ChangeType `json:"type" yaml:"type"`
+	Action Action     `json:"action" yaml:"action"`
+	// Certificate is the domain of the certificate in the store which has the host's name
+	Certificate string `json:"certificate,omitempty" yaml:"certificate,omitempty"`
+	// Thumbprint is the thumbprint of the certificate in the store
+	Thumbprint string `json:"thumbprint,omitempty" yaml:"thumbprint,omitempty"`
+	// ExternalThumbprint is the thumbprint of the host's certificate, if it has one
+	ExternalThumbprint string `json:"externalThumbprint,omitempty" yaml:"externalThumbprint,omitempty"`
+	// ExternalExpires is when the host's certificate expires, if it has one
+	ExternalExpires *time.Time `json:"externalExpires,omitempty" yaml:"externalExpires,omitempty"`
+}
+
+// Plan is the set of changes which would bring a target in step with the store
+type Plan struct {
+	Target  string    `json:"target" yaml:"target"`
+	Changes []*Change `json:"changes" yaml:"changes"`
+}
+
+// ChangeResult is the outcome of applying a change
+type ChangeResult struct {
+	Change *Change
+	// Verified is true if the host was read back after the change and has the certificate
+	Verified bool
+	// Err is nil if the change was applied
+	Err error
+}
+
+// NewPlan compares every host in the target with the certificates in the store.  Certificates
+// without a private key are left out, as they can't be synced.  If the target can't list its
+// hosts, only the hosts with names in the store are compared.
+func NewPlan(certs []*store.Certificate, target *Target, now time.Time) (*Plan, error) {
+	byName := certificatesByName(certs)
+	hosts, err := target.Client.GetHosts()
+	if err == ErrListNotSupported {
+		hosts, err = getHostsByName(byName, target.Client)
+	}
+	if err != nil {
+		return nil, logger.Errorex("unable to get hosts", err, golog.String("target", target.Name))
+	}
+
+	plan := &Plan{Target: target.Name, Changes: []*Change{}}
+	seen := make(map[string]bool)
+
+	for _, host := range hosts {
+		seen[host.Domain] = true
+		change := &Change{Domain: host.Domain, Action: ActionNone}
+
+		if bundle, err := host.DecodeCertificates(); err == nil {
+			change.ExternalThumbprint, _ = host.Thumbprint()
+			expires := bundle[0].NotAfter
+			change.ExternalExpires = &expires
+		} else {
+			logger.Debug("unable to decode host certificate", golog.String("domain", host.Domain))
+		}
+
+		cert, ok := byName[host.Domain]
+		if !ok {
+			change.Type = HostUnknown
+			plan.Changes = append(plan.Changes, change)
+			continue
+		}
+		change.Certificate = cert.Domain
+		change.Thumbprint = cert.Thumbprint
+
+		expired := change.ExternalExpires != nil && !now.Before(*change.ExternalExpires)
+		switch {
+		case change.ExternalThumbprint == cert.Thumbprint && !expired:
+			// in step
+			continue
+		case expired:
+			change.Type = HostExpired
+			if change.ExternalThumbprint != cert.Thumbprint {
+				change.Action = ActionPut
+			}
+		default:
+			change.Type = HostStale
+			change.Action = ActionPut
+		}
+		plan.Changes = append(plan.Changes, change)
+	}
+
+	for name, cert := range byName {
+		if seen[name] {
+			continue
+		}
+		plan.Changes = append(plan.Changes, &Change{
+			Domain:      name,
+			Type:        HostMissing,
+			Action:      ActionPut,
+			Certificate: cert.Domain,
+			Thumbprint:  cert.Thumbprint,
+		})
+	}
+
+	sort.Slice(plan.Changes, func(i, j int) bool { return plan.Changes[i].Domain < plan.Changes[j].Domain })
+	return plan, nil
+}
+
+// ApplyPlan pushes the certificates for the changes in a plan which have ActionPut.  Before each
+// change the store and the host are checked to be as they were when the plan was made, so that
+// only what the plan showed is done.  A change which fails doesn't stop the others; the outcome of
+// each is returned along with an error if any of them failed.  Each host which is put is read back
+// after the client is committed to check that it has the certificate.
+func ApplyPlan(plan *Plan, certs []*store.Certificate, target *Target) ([]*ChangeResult, error) {
+	byName := certificatesByName(certs)
+	var results []*ChangeResult
+	var failed []string
+
+	for _, change := range plan.Changes {
+		if change.Action != ActionPut {
+			continue
+		}
+		err := applyChange(change, byName[change.Domain], target.Client)
+		if err != nil {
+			failed = append(failed, change.Domain)
+		}
+		results = append(results, &ChangeResult{Change: change, Err: err})
+	}
+
+	if err := Commit(target.Client); err != nil {
+		return results, logger.Errorex("unable to commit changes", err, golog.String("target", target.Name))
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			continue
+		}
+		result.Verified, result.Err = verifyHost(result.Change.Domain, result.Change.Thumbprint, target.Client)
+		if result.Err != nil {
+			failed = append(failed, result.Change.Domain)
+		}
+	}
+	if len(failed) > 0 {
+		return results, logger.Error("unable to apply all changes",
+			golog.String("target", target.Name),
+			golog.Strings("domains", failed),
+		)
+	}
+	return results, nil
+}
+
+// applyChange pushes the certificate for a change if neither it nor the host have changed since
+// the plan was made
+func applyChange(change *Change, cert *store.Certificate, external Client) error {
+	if cert == nil || cert.Thumbprint != change.Thumbprint {
+		return logger.Error("certificate in store has changed since the plan was made",
+			golog.String("domain", change.Domain),
+		)
+	}
+
+	var host *Host
+	err := retry(external, func() (err error) {
+		host, err = external.GetHost(change.Domain)
+		return
+	})
+	if err != nil {
+		return logger.Errore(err)
+	}
+	var extThumbprint string
+	if host != nil {
+		// a host whose certificate can't be decoded was planned with no thumbprint
+		extThumbprint, _ = host.Thumbprint()
+	}
+	if extThumbprint != change.ExternalThumbprint {
+		return logger.Error("host has changed since the plan was made",
+			golog.String("domain", change.Domain),
+			golog.String("thumbprint", extThumbprint),
+		)
+	}
+
+	host = &Host{
+		Domain:         change.Domain,
+		CertificatePEM: string(cert.CertificateChain),
+		PrivateKeyPEM:  string(cert.PrivateKey),
+	}
+	if err := retry(external, func() error { return external.PutHost(host) }); err != nil {
+		return logger.Errore(err)
+	}
+	return nil
+}
+
+// getHostsByName gets the host for each of the names from a client which can't list its hosts
+func getHostsByName(byName map[string]*store.Certificate, external Client) ([]*Host, error) {
+	var hosts []*Host
+	for name := range byName {
+		var host *Host
+		err := retry(external, func() (err error) {
+			host, err = external.GetHost(name)
+			return
+		})
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+		if host != nil {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, nil
+}
+
+// certificatesByName maps each name of the certificates which can be synced to its certificate,
+// preferring the one which expires last if more than one has the same name
+func certificatesByName(certs []*store.Certificate) map[string]*store.Certificate {
+	byName := make(map[string]*store.Certificate)
+	for _, cert := range certs {
+		if len(cert.PrivateKey) == 0 {
+			continue
+		}
+		for _, name := range getAllNames(cert) {
+			if existing, ok := byName[name]; !ok || cert.Expires.After(existing.Expires) {
+				byName[name] = cert
+			}
+		}
+	}
+	return byName
+}
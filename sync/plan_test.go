@@ -0,0 +1,80 @@
+package sync
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stugotech/coyote/store"
+)
+
+func TestPlanAndApply(t *testing.T) {
+	now := time.Now()
+	expires := now.Add(90 * 24 * time.Hour)
+	com := newTestCertificate(t, "example.com", nil, expires)
+	net := newTestCertificate(t, "example.net", nil, expires)
+	org := newTestCertificate(t, "example.org", nil, expires)
+	client := newFakeClient()
+	client.PutHost(hostFor(newTestCertificate(t, "example.org", nil, expires)))
+	client.PutHost(hostFor(newTestCertificate(t, "manual.example.io", nil, expires)))
+	target := &Target{Name: "fake", Client: client}
+
+	plan, err := NewPlan([]*store.Certificate{com, net, org}, target, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, change := range plan.Changes {
+		got = append(got, change.Domain+" "+string(change.Type)+" "+string(change.Action))
+	}
+	want := []string{
+		"example.com missing put",
+		"example.net missing put",
+		"example.org stale put",
+		"manual.example.io unknown none",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got changes %q, want %q", got, want)
+	}
+
+	// the host and the store change after the plan is made, so those changes are skipped
+	changed := newTestCertificate(t, "example.com", nil, expires)
+	client.PutHost(hostFor(changed))
+	renewed := newTestCertificate(t, "example.net", nil, expires)
+
+	results, err := ApplyPlan(plan, []*store.Certificate{com, renewed, org}, target)
+	if err == nil {
+		t.Error("expected error applying changes which are out of date")
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want one for each put", len(results))
+	}
+	for _, result := range results {
+		ok := result.Change.Domain == "example.org"
+		if (result.Err == nil) != ok || result.Verified != ok {
+			t.Errorf("%s: got verified %v and error %v, want applied %v", result.Change.Domain, result.Verified, result.Err, ok)
+		}
+	}
+	wantThumbprints := map[string]string{
+		"example.com": changed.Thumbprint,
+		"example.net": "",
+		"example.org": org.Thumbprint,
+	}
+	for domain, thumbprint := range wantThumbprints {
+		if got := client.thumbprint(t, domain); got != thumbprint {
+			t.Errorf("%s: got certificate %q, want %q", domain, got, thumbprint)
+		}
+	}
+	if client.commits != 1 {
+		t.Errorf("got %d commits, want 1", client.commits)
+	}
+}
+
+// hostFor creates a host with the certificate
+func hostFor(cert *store.Certificate) *Host {
+	return &Host{
+		Domain:         cert.Domain,
+		CertificatePEM: string(cert.CertificateChain),
+		PrivateKeyPEM:  string(cert.PrivateKey),
+	}
+}
@@ -1,8 +1,11 @@
 package sync
 
 import (
+	"context"
 	"crypto/x509"
-	"encoding/pem"
+	"errors"
+	"sort"
+	"time"
 
 	"github.com/stugotech/coyote/coyote"
 	"github.com/stugotech/coyote/cryptutil"
@@ -12,6 +15,43 @@ import (
 
 var logger = golog.NewPackageLogger()
 
+// Retries of requests to the external system
+const (
+	attempts        = 3
+	firstRetryDelay = time.Second
+)
+
+// Retries of certificates which failed to sync while watching the store
+const (
+	firstWatchRetryDelay = 30 * time.Second
+	maxWatchRetryDelay   = 30 * time.Minute
+)
+
+// watchBatchDelay is how long changes to the store are collected for while watching before they are
+// synced together, so that a burst of changes, e.g. when the watch starts, is committed once
+const watchBatchDelay = time.Second
+
+// ErrListNotSupported is returned by GetHosts for clients which can get hosts by name but can't list
+// them; plans are then made from the host for each name in the store.
+var ErrListNotSupported = errors.New("client can't list hosts")
+
+// TransientError is returned by clients for failures which could succeed if the request is made
+// again, e.g. a timeout talking to the external system.  Only these errors are retried.
+type TransientError struct {
+	Err error
+}
+
+// Error gets the error message
+func (e *TransientError) Error() string {
+	return e.Err.Error()
+}
+
+// IsTransient returns true if the error is a TransientError
+func IsTransient(err error) bool {
+	_, ok := err.(*TransientError)
+	return ok
+}
+
 // Client represents the interface to the sync API.
 type Client interface {
 	GetHosts() ([]*Host, error)
@@ -19,6 +59,27 @@ type Client interface {
 	PutHost(host *Host) error
 }
 
+// Committer is implemented by clients which apply the hosts put in a batch together, e.g. by
+// reloading a server once after all of the files have been written.
+type Committer interface {
+	// Commit applies the hosts put since the last commit.
+	Commit() error
+}
+
+// Deleter is implemented by clients which can remove the hosts of certificates which have been
+// deleted from the store.
+type Deleter interface {
+	// DeleteHost removes a host.
+	DeleteHost(domain string) error
+}
+
+// Retrier is implemented by clients which set how many times a request which fails with a
+// TransientError is tried.
+type Retrier interface {
+	// Attempts is the number of times to try each request.
+	Attempts() int
+}
+
 // Host represents a host in the synced system.
 type Host struct {
 	Domain         string
@@ -28,31 +89,31 @@ type Host struct {
 
 // DecodeCertificates returns the decoded certificate
 func (h *Host) DecodeCertificates() ([]*x509.Certificate, error) {
-	var certs []*x509.Certificate
-	// decode the whole bundle
-	for data := []byte(h.CertificatePEM); ; {
-		var block *pem.Block
-		block, data = pem.Decode(data)
-
-		if block == nil {
-			break
-		}
-		if block.Type != "CERTIFICATE" {
-			continue
-		}
-
-		cert, err := x509.ParseCertificate(block.Bytes)
-		if err != nil {
-			return []*x509.Certificate{}, logger.Errorex("error decoding certificate", err)
-		}
+	return cryptutil.ParseCertificatesFromPEM([]byte(h.CertificatePEM))
+}
 
-		certs = append(certs, cert)
+// Thumbprint returns the thumbprint of the leaf certificate
+func (h *Host) Thumbprint() (string, error) {
+	bundle, err := h.DecodeCertificates()
+	if err != nil {
+		return "", logger.Errore(err)
 	}
+	return cryptutil.Thumbprint(bundle[0].Raw), nil
+}
 
-	if len(certs) == 0 {
-		return []*x509.Certificate{}, logger.Error("no certificate data found")
-	}
-	return certs, nil
+// HostResult is the outcome of syncing a certificate to a host.
+type HostResult struct {
+	Domain string
+	// Certificate is the domain of the certificate in the store
+	Certificate string
+	// Changed is true if the certificate was put to the host
+	Changed bool
+	// Verified is true if the host was read back, after it was put if it changed, and has the
+	// certificate; it is false if the client couldn't return the host, e.g. a webhook without a GET
+	// endpoint
+	Verified bool
+	// Err is nil if the host has the certificate
+	Err error
 }
 
 // ExternalWithCoyote copies all certificate keys to the external system
@@ -61,58 +122,397 @@ func ExternalWithCoyote(coy coyote.Coyote, external Client) error {
 	if err != nil {
 		return logger.Errore(err)
 	}
-	return Certificates(certs, external)
+	_, err = Certificates(certs, external)
+	return err
 }
 
 // Certificate pushes the keys for a single certificate to all relevant remote hosts.
-func Certificate(cert *store.Certificate, external Client) error {
-	for _, domain := range getAllNames(cert) {
-		logger.Debug("syncing certificate with external system",
-			golog.String("domain", domain),
-			golog.String("thumbprint", cert.Thumbprint),
-		)
+func Certificate(cert *store.Certificate, external Client) ([]*HostResult, error) {
+	return Certificates([]*store.Certificate{cert}, external)
+}
 
-		host, err := external.GetHost(domain)
-		if err != nil {
-			return logger.Errore(err)
-		}
+// Certificates pushes the keys for a specified certificates to all relevant remote hosts.  Every
+// name of every certificate is synced even if others fail, and each host which is put is read back
+// to check that it has the certificate.  Clients which implement Committer are committed before the
+// hosts are checked, even if a host failed.  If more than one certificate has a name, only the one
+// which expires last is synced to it.  The outcome for each host is returned, along with an error
+// if any of them failed.
+func Certificates(certs []*store.Certificate, external Client) ([]*HostResult, error) {
+	return certificates(certs, certificatesByName(certs), external)
+}
+
+// certificates pushes the certificates to their hosts, skipping the names which byName gives a
+// different certificate for
+func certificates(certs []*store.Certificate, byName map[string]*store.Certificate, external Client) ([]*HostResult, error) {
+	var results []*HostResult
+	var failed []string
+	// the thumbprint of each certificate by domain, to check the hosts against
+	thumbprints := make(map[string]string)
 
-		if host != nil {
-			bundle, err := host.DecodeCertificates()
-			if err != nil {
-				return logger.Errore(err)
+	for _, cert := range certs {
+		thumbprints[cert.Domain] = cert.Thumbprint
+		if len(cert.PrivateKey) == 0 {
+			// the key is held by whoever supplied the CSR, so the certificate can't be installed here
+			logger.Info("skipping sync of certificate without private key",
+				golog.String("domain", cert.Domain),
+			)
+			continue
+		}
+		for _, domain := range getAllNames(cert) {
+			if byName[domain] != cert {
+				logger.Info("skipping name which has a certificate expiring later",
+					golog.String("domain", domain),
+					golog.String("certificate", cert.Domain),
+					golog.String("synced", byName[domain].Domain),
+				)
+				continue
 			}
+			result := &HostResult{Domain: domain, Certificate: cert.Domain}
+			result.Changed, result.Err = putHost(cert, domain, external)
+			// a host which didn't need changing was read and found to have the certificate
+			result.Verified = result.Err == nil && !result.Changed
+			results = append(results, result)
+		}
+	}
+
+	commitErr := Commit(external)
+
+	for _, result := range results {
+		if result.Err == nil && result.Changed {
+			result.Verified, result.Err = verifyHost(result.Domain, thumbprints[result.Certificate], external)
+		}
+		if result.Err != nil {
+			logger.Errorex("unable to sync host", result.Err, golog.String("domain", result.Domain))
+			failed = append(failed, result.Domain)
+		}
+	}
 
-			extThumbprint := cryptutil.Thumbprint(bundle[0].Raw)
+	if commitErr != nil {
+		return results, logger.Errore(commitErr)
+	}
+	if len(failed) > 0 {
+		return results, logger.Error("unable to sync all hosts", golog.Strings("domains", failed))
+	}
+	return results, nil
+}
+
+// putHost puts the certificate to the host for one of its names, unless the host already has it.
+// It returns true if the host was put.
+func putHost(cert *store.Certificate, domain string, external Client) (bool, error) {
+	logger.Debug("syncing certificate with external system",
+		golog.String("domain", domain),
+		golog.String("thumbprint", cert.Thumbprint),
+	)
+
+	var host *Host
+	err := retry(external, func() (err error) {
+		host, err = external.GetHost(domain)
+		return
+	})
+	if err != nil {
+		return false, logger.Errore(err)
+	}
 
+	if host != nil {
+		extThumbprint, err := host.Thumbprint()
+		if err != nil {
+			// treat the host as stale, as NewPlan does, so that it is replaced
+			logger.Info("unable to decode host certificate, replacing it", golog.String("domain", domain))
+		} else {
 			logger.Debug("found certificate in external store",
+				golog.String("domain", domain),
 				golog.String("thumbprint", extThumbprint),
 			)
-
 			if extThumbprint == cert.Thumbprint {
-				return nil
+				return false, nil
 			}
 		}
-		host = &Host{
-			Domain:         domain,
-			CertificatePEM: string(cert.CertificateChain),
-			PrivateKeyPEM:  string(cert.PrivateKey),
-		}
+	}
+
+	host = &Host{
+		Domain:         domain,
+		CertificatePEM: string(cert.CertificateChain),
+		PrivateKeyPEM:  string(cert.PrivateKey),
+	}
+	if err := retry(external, func() error { return external.PutHost(host) }); err != nil {
+		return false, logger.Errore(err)
+	}
+	return true, nil
+}
+
+// verifyHost reads a host back after it was put and checks that it has the certificate with the
+// thumbprint.  It returns false without an error if the client doesn't return the host.
+func verifyHost(domain string, thumbprint string, external Client) (bool, error) {
+	var host *Host
+	err := retry(external, func() (err error) {
+		host, err = external.GetHost(domain)
+		return
+	})
+	if err != nil {
+		return false, logger.Errore(err)
+	}
+	if host == nil {
+		logger.Info("unable to verify host, it wasn't returned after it was put", golog.String("domain", domain))
+		return false, nil
+	}
+
+	extThumbprint, err := host.Thumbprint()
+	if err != nil {
+		return false, logger.Errore(err)
+	}
+	if extThumbprint != thumbprint {
+		return false, logger.Error("host has a different certificate after it was put",
+			golog.String("domain", domain),
+			golog.String("thumbprint", extThumbprint),
+		)
+	}
+	return true, nil
+}
 
-		if err := external.PutHost(host); err != nil {
-			return logger.Errore(err)
+// retry calls the function until it succeeds, fails with an error which isn't transient, or the
+// attempts run out, waiting longer between each attempt, so that transient failures of the external
+// system don't fail the sync.  Clients which implement Retrier set the number of attempts.
+func retry(external Client, f func() error) error {
+	n := attempts
+	if r, ok := external.(Retrier); ok && r.Attempts() > 0 {
+		n = r.Attempts()
+	}
+	delay := firstRetryDelay
+	for i := 1; ; i++ {
+		err := f()
+		if err == nil || !IsTransient(err) || i >= n {
+			return err
 		}
+		logger.Debug("request to external system failed, retrying", golog.Int("attempt", i))
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// Commit commits the hosts put to the client, if it implements Committer.
+func Commit(external Client) error {
+	committer, ok := external.(Committer)
+	if !ok {
+		return nil
+	}
+	if err := committer.Commit(); err != nil {
+		return logger.Errore(err)
 	}
 	return nil
 }
 
-// Certificates pushes the keys for a specified certificates to all relevant remote hosts.
-func Certificates(certs []*store.Certificate, external Client) error {
+// Watch keeps the external systems in step with the store until the context is cancelled,
+// including changes made to the store by other nodes.  Changes are collected for watchBatchDelay and
+// then synced to each target together.  Each name is synced from the certificate in the store which
+// expires last.  A target which fails doesn't stop the others from receiving changes.  Certificates
+// which fail to sync to a target, and hosts which fail to be removed, are retried with increasing
+// delays until they succeed or the store changes them again.
+func Watch(ctx context.Context, st store.Store, targets []*Target) error {
+	events, err := st.WatchCertificates(ctx)
+	if err != nil {
+		return logger.Errore(err)
+	}
+
+	// the certificates in the store, by domain
+	certs := make(map[string]*store.Certificate)
+
+	watched := make([]*watchTarget, 0, len(targets))
+	for _, target := range targets {
+		watched = append(watched, newWatchTarget(target))
+	}
+
+	var batchCh, retryCh <-chan time.Time
+	retryDelay := firstWatchRetryDelay
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return logger.Error("watch on store ended unexpectedly")
+			}
+			switch event.Type {
+			case store.CertificateUpdated:
+				certs[event.Domain] = event.Certificate
+				for _, target := range watched {
+					target.queueSync(event.Domain)
+				}
+			case store.CertificateDeleted:
+				logger.Info("certificate removed from store", golog.String("domain", event.Domain))
+				hosts := []string{event.Domain}
+				if cert, ok := certs[event.Domain]; ok {
+					hosts = getAllNames(cert)
+				}
+				delete(certs, event.Domain)
+				for _, target := range watched {
+					target.queueDelete(event.Domain, hosts)
+				}
+			}
+			if batchCh == nil {
+				batchCh = time.After(watchBatchDelay)
+			}
+			continue
+
+		case <-batchCh:
+			batchCh = nil
+
+		case <-retryCh:
+			retryCh = nil
+			if retryDelay *= 2; retryDelay > maxWatchRetryDelay {
+				retryDelay = maxWatchRetryDelay
+			}
+		}
+
+		all := make([]*store.Certificate, 0, len(certs))
+		for _, cert := range certs {
+			all = append(all, cert)
+		}
+		byName := certificatesByName(all)
+
+		pending := false
+		for _, target := range watched {
+			target.flush(certs, byName)
+			pending = pending || target.hasPending()
+		}
+		switch {
+		case !pending:
+			retryCh = nil
+			retryDelay = firstWatchRetryDelay
+		case retryCh == nil:
+			logger.Info("retrying failed syncs later", golog.String("delay", retryDelay.String()))
+			retryCh = time.After(retryDelay)
+		}
+	}
+}
+
+// watchTarget tracks what has been synced to a target while watching the store
+type watchTarget struct {
+	*Target
+	// synced is the thumbprint of the certificate last synced successfully, by domain
+	synced map[string]string
+	// pending are the domains of the certificates to sync, including those which failed before
+	pending map[string]bool
+	// pendingDeletes are the hosts to remove, including those which failed before
+	pendingDeletes map[string]bool
+}
+
+func newWatchTarget(target *Target) *watchTarget {
+	return &watchTarget{
+		Target:         target,
+		synced:         make(map[string]string),
+		pending:        make(map[string]bool),
+		pendingDeletes: make(map[string]bool),
+	}
+}
+
+// queueSync queues a certificate which has changed in the store to be synced
+func (w *watchTarget) queueSync(domain string) {
+	w.pending[domain] = true
+}
+
+// queueDelete queues the hosts of a certificate which has been deleted from the store to be removed
+func (w *watchTarget) queueDelete(domain string, hosts []string) {
+	delete(w.synced, domain)
+	delete(w.pending, domain)
+	for _, host := range hosts {
+		w.pendingDeletes[host] = true
+	}
+}
+
+// flush removes the queued hosts and syncs the queued certificates which haven't already been
+// synced, committing the target once for each.  A queued host which another certificate in the
+// store has the name of is put with that certificate instead of being removed.
+func (w *watchTarget) flush(certs map[string]*store.Certificate, byName map[string]*store.Certificate) {
+	var hosts []string
+	for host := range w.pendingDeletes {
+		if cert, ok := byName[host]; ok {
+			delete(w.synced, cert.Domain)
+			w.pending[cert.Domain] = true
+			delete(w.pendingDeletes, host)
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	if len(hosts) > 0 {
+		sort.Strings(hosts)
+		if err := deleteHosts(hosts, w.Client); err != nil {
+			logger.Errorex("unable to remove hosts", err,
+				golog.Strings("domains", hosts),
+				golog.String("target", w.Name),
+			)
+		} else {
+			for _, host := range hosts {
+				delete(w.pendingDeletes, host)
+			}
+		}
+	}
+
+	var batch []*store.Certificate
+	for domain := range w.pending {
+		cert, ok := certs[domain]
+		if !ok || w.synced[domain] == cert.Thumbprint {
+			delete(w.pending, domain)
+			continue
+		}
+		batch = append(batch, cert)
+	}
+	if len(batch) == 0 {
+		return
+	}
+	sort.Slice(batch, func(i, j int) bool { return batch[i].Domain < batch[j].Domain })
+	w.sync(batch, byName)
+}
+
+// sync pushes certificates to the target, recording which succeeded and which need retrying
+func (w *watchTarget) sync(certs []*store.Certificate, byName map[string]*store.Certificate) {
+	results, err := certificates(certs, byName, w.Client)
+
+	failed := make(map[string]bool)
+	for _, result := range results {
+		if result.Err != nil {
+			failed[result.Certificate] = true
+		}
+	}
+	if err != nil {
+		logger.Errorex("unable to sync certificates to target", err, golog.String("target", w.Name))
+	}
+
 	for _, cert := range certs {
-		if err := Certificate(cert, external); err != nil {
-			return logger.Errore(err)
+		// an error without failed hosts, e.g. a failed commit, applies to all of the certificates
+		if failed[cert.Domain] || (err != nil && len(failed) == 0) {
+			continue
+		}
+		w.synced[cert.Domain] = cert.Thumbprint
+		delete(w.pending, cert.Domain)
+	}
+}
+
+// hasPending returns true if anything needs retrying
+func (w *watchTarget) hasPending() bool {
+	return len(w.pending) > 0 || len(w.pendingDeletes) > 0
+}
+
+// deleteHosts removes hosts from the external system, if the client implements Deleter.
+func deleteHosts(domains []string, external Client) error {
+	deleter, ok := external.(Deleter)
+	if !ok {
+		return nil
+	}
+	var failed []string
+	for _, domain := range domains {
+		if err := deleter.DeleteHost(domain); err != nil {
+			logger.Errorex("unable to remove host", err, golog.String("domain", domain))
+			failed = append(failed, domain)
 		}
 	}
+	if err := Commit(external); err != nil {
+		return logger.Errore(err)
+	}
+	if len(failed) > 0 {
+		return logger.Error("unable to remove hosts", golog.Strings("domains", failed))
+	}
 	return nil
 }
 
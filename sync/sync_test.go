@@ -0,0 +1,248 @@
+package sync
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/stugotech/coyote/cryptutil"
+	"github.com/stugotech/coyote/store"
+)
+
+// fakeClient keeps hosts in memory and counts commits
+type fakeClient struct {
+	mu      gosync.Mutex
+	hosts   map[string]*Host
+	commits int
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{hosts: make(map[string]*Host)}
+}
+
+func (c *fakeClient) GetHosts() ([]*Host, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hosts := make([]*Host, 0, len(c.hosts))
+	for _, host := range c.hosts {
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+func (c *fakeClient) GetHost(domain string) (*Host, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hosts[domain], nil
+}
+
+func (c *fakeClient) PutHost(host *Host) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hosts[host.Domain] = host
+	return nil
+}
+
+func (c *fakeClient) DeleteHost(domain string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.hosts, domain)
+	return nil
+}
+
+func (c *fakeClient) Commit() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commits++
+	return nil
+}
+
+// thumbprint returns the thumbprint of the host's certificate, or "" if there is no host
+func (c *fakeClient) thumbprint(t *testing.T, domain string) string {
+	host, err := c.GetHost(domain)
+	if err != nil || host == nil {
+		return ""
+	}
+	thumbprint, err := host.Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return thumbprint
+}
+
+func (c *fakeClient) getCommits() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.commits
+}
+
+// watchStore sends the events it is given to the watch
+type watchStore struct {
+	store.Store
+	events chan *store.CertificateEvent
+}
+
+func (s *watchStore) WatchCertificates(ctx context.Context) (<-chan *store.CertificateEvent, error) {
+	return s.events, nil
+}
+
+func TestCertificatesPrefersLastExpiring(t *testing.T) {
+	now := time.Now()
+	long := newTestCertificate(t, "example.com", []string{"www.example.com"}, now.Add(90*24*time.Hour))
+	short := newTestCertificate(t, "www.example.com", nil, now.Add(10*24*time.Hour))
+	client := newFakeClient()
+
+	if _, err := Certificates([]*store.Certificate{short, long}, client); err != nil {
+		t.Fatal(err)
+	}
+	for _, domain := range []string{"example.com", "www.example.com"} {
+		if got := client.thumbprint(t, domain); got != long.Thumbprint {
+			t.Errorf("%s: got certificate %q, want the one expiring last", domain, got)
+		}
+	}
+	if client.commits != 1 {
+		t.Errorf("got %d commits, want 1", client.commits)
+	}
+}
+
+func TestWatchBatchesChanges(t *testing.T) {
+	now := time.Now()
+	long := newTestCertificate(t, "example.com", []string{"www.example.com"}, now.Add(90*24*time.Hour))
+	short := newTestCertificate(t, "www.example.com", nil, now.Add(10*24*time.Hour))
+	other := newTestCertificate(t, "example.org", nil, now.Add(30*24*time.Hour))
+
+	st := &watchStore{events: make(chan *store.CertificateEvent)}
+	client := newFakeClient()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- Watch(ctx, st, []*Target{{Name: "fake", Client: client}}) }()
+
+	// the certificates are sent one at a time, as when the watch starts
+	for _, cert := range []*store.Certificate{long, short, other} {
+		st.events <- &store.CertificateEvent{Type: store.CertificateUpdated, Domain: cert.Domain, Certificate: cert}
+	}
+	waitForCommits(t, client, 1)
+	want := map[string]string{
+		"example.com":     long.Thumbprint,
+		"www.example.com": long.Thumbprint,
+		"example.org":     other.Thumbprint,
+	}
+	for domain, thumbprint := range want {
+		if got := client.thumbprint(t, domain); got != thumbprint {
+			t.Errorf("%s: got certificate %q, want %q", domain, got, thumbprint)
+		}
+	}
+
+	// the shorter certificate has the name once the longer one is removed, and the other hosts go
+	st.events <- &store.CertificateEvent{Type: store.CertificateDeleted, Domain: long.Domain}
+	st.events <- &store.CertificateEvent{Type: store.CertificateDeleted, Domain: other.Domain}
+	waitForCommits(t, client, 3)
+	want = map[string]string{
+		"example.com":     "",
+		"www.example.com": short.Thumbprint,
+		"example.org":     "",
+	}
+	for domain, thumbprint := range want {
+		if got := client.thumbprint(t, domain); got != thumbprint {
+			t.Errorf("%s: got certificate %q, want %q", domain, got, thumbprint)
+		}
+	}
+
+	cancel()
+	close(st.events)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if commits := client.getCommits(); commits != 3 {
+		t.Errorf("got %d commits, want one for each batch of puts and deletes", commits)
+	}
+}
+
+// waitForCommits waits for the client to be committed the number of times
+func waitForCommits(t *testing.T, client *fakeClient, commits int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for client.getCommits() < commits {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d commits, want %d", client.getCommits(), commits)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// wait for anything else the batch does
+	time.Sleep(50 * time.Millisecond)
+}
+
+// newTestCertificate creates a store certificate with a self-signed certificate for the names
+func newTestCertificate(t *testing.T, domain string, altNames []string, expires time.Time) *store.Certificate {
+	key, keyDER, err := cryptutil.CreateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     append([]string{domain}, altNames...),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     expires,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &store.Certificate{
+		Domain:           domain,
+		AlternativeNames: altNames,
+		Expires:          expires,
+		CertificateChain: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		PrivateKey:       pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		Thumbprint:       cryptutil.Thumbprint(der),
+	}
+}
+
+// flakyClient fails each request with a transient error until it has been tried enough times
+type flakyClient struct {
+	*fakeClient
+	attempts int
+	failures int
+	tries    int
+}
+
+func (c *flakyClient) PutHost(host *Host) error {
+	c.tries++
+	if c.tries <= c.failures {
+		return &TransientError{Err: errors.New("unavailable")}
+	}
+	return c.fakeClient.PutHost(host)
+}
+
+func (c *flakyClient) Attempts() int {
+	return c.attempts
+}
+
+func TestRetryUsesClientAttempts(t *testing.T) {
+	tests := []struct {
+		attempts int
+		failures int
+		ok       bool
+	}{
+		{1, 0, true},
+		{1, 1, false},
+		{2, 1, true},
+	}
+	for _, test := range tests {
+		client := &flakyClient{fakeClient: newFakeClient(), attempts: test.attempts, failures: test.failures}
+		err := retry(client, func() error { return client.PutHost(&Host{Domain: "example.com"}) })
+		if (err == nil) != test.ok {
+			t.Errorf("%d attempts, %d failures: got error %v, want ok %v", test.attempts, test.failures, err, test.ok)
+		}
+		if want := test.failures + 1; test.ok && client.tries != want || !test.ok && client.tries != test.attempts {
+			t.Errorf("%d attempts, %d failures: got %d tries", test.attempts, test.failures, client.tries)
+		}
+	}
+}
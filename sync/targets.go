@@ -0,0 +1,48 @@
+package sync
+
+import (
+	"github.com/stugotech/coyote/store"
+	"github.com/stugotech/golog"
+)
+
+// Target is a named external system which certificates are synced to.
+type Target struct {
+	Name   string
+	Client Client
+}
+
+// TargetResult is the outcome of syncing certificates to a target.
+type TargetResult struct {
+	Target string
+	// Hosts is the outcome for each host of the certificates
+	Hosts []*HostResult
+	// Err is nil if all of the certificates were synced
+	Err error
+}
+
+// CertificatesToTargets pushes certificates to every target.  A target which fails doesn't stop the
+// others from receiving the certificates; the outcome for each target is returned in the same
+// order as the targets, along with an error if any of them failed.
+func CertificatesToTargets(certs []*store.Certificate, targets []*Target) ([]*TargetResult, error) {
+	results := make([]*TargetResult, 0, len(targets))
+	var failed []string
+
+	for _, target := range targets {
+		hosts, err := Certificates(certs, target.Client)
+		if err != nil {
+			logger.Errorex("unable to sync certificates to target", err, golog.String("target", target.Name))
+			failed = append(failed, target.Name)
+		} else {
+			logger.Debug("synced certificates to target",
+				golog.String("target", target.Name),
+				golog.Int("certificates", len(certs)),
+			)
+		}
+		results = append(results, &TargetResult{Target: target.Name, Hosts: hosts, Err: err})
+	}
+
+	if len(failed) > 0 {
+		return results, logger.Error("unable to sync certificates to all targets", golog.Strings("targets", failed))
+	}
+	return results, nil
+}
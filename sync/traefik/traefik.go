@@ -0,0 +1,208 @@
+package traefik
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/stugotech/coyote/export"
+	"github.com/stugotech/coyote/sync"
+	"github.com/stugotech/golog"
+	"gopkg.in/yaml.v2"
+)
+
+var logger = golog.NewPackageLogger()
+
+// Config describes where the Traefik file provider reads certificates from
+type Config struct {
+	// Dir is the directory that certificate and key files are written to
+	Dir string `mapstructure:"dir"`
+	// ConfigFile is the dynamic configuration file which lists the certificates, in a directory
+	// watched by the file provider.  Only the tls section is kept when the file is rewritten, so it
+	// should be dedicated to coyote.
+	ConfigFile string `mapstructure:"config-file"`
+}
+
+// dynamicConfig is the part of the Traefik dynamic configuration written by the client
+type dynamicConfig struct {
+	TLS *tlsConfig `yaml:"tls,omitempty"`
+}
+
+// tlsConfig is the tls section of the Traefik dynamic configuration
+type tlsConfig struct {
+	Certificates []*certificateConfig   `yaml:"certificates,omitempty"`
+	Options      map[string]interface{} `yaml:"options,omitempty"`
+	Stores       map[string]interface{} `yaml:"stores,omitempty"`
+}
+
+// certificateConfig is a certificate in the Traefik dynamic configuration
+type certificateConfig struct {
+	CertFile string   `yaml:"certFile"`
+	KeyFile  string   `yaml:"keyFile"`
+	Stores   []string `yaml:"stores,omitempty"`
+}
+
+// client is an implementation of the Client interface for the Traefik file provider
+type client struct {
+	config *Config
+}
+
+// NewClient creates a client which writes certificates to the Traefik dynamic configuration
+func NewClient(config *Config) sync.Client {
+	return &client{config: config}
+}
+
+// GetHosts returns the hosts whose certificates were written by the client
+func (c *client) GetHosts() ([]*sync.Host, error) {
+	dynamic, err := c.readConfig()
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	var hosts []*sync.Host
+	for _, cert := range dynamic.TLS.Certificates {
+		if filepath.Dir(cert.CertFile) != filepath.Clean(c.config.Dir) {
+			// not written by coyote
+			continue
+		}
+		host, err := c.GetHost(domainFromFileName(filepath.Base(cert.CertFile)))
+		if err != nil {
+			return nil, logger.Errore(err)
+		}
+		if host != nil {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts, nil
+}
+
+// GetHost returns a single host, or nil if it has no certificate file
+func (c *client) GetHost(domain string) (*sync.Host, error) {
+	certFile, keyFile := c.paths(domain)
+	cert, err := ioutil.ReadFile(certFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	key, err := ioutil.ReadFile(keyFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, logger.Errore(err)
+	}
+	return &sync.Host{
+		Domain:         domain,
+		CertificatePEM: string(cert),
+		PrivateKeyPEM:  string(key),
+	}, nil
+}
+
+// PutHost writes the certificate and key files for a host and lists them in the dynamic
+// configuration, which Traefik reloads when it changes
+func (c *client) PutHost(host *sync.Host) error {
+	existing, err := c.GetHost(host.Domain)
+	if err != nil {
+		return logger.Errore(err)
+	}
+	if existing != nil && sameCertificate(existing, host) {
+		logger.Debug("Traefik certificate is up to date", golog.String("domain", host.Domain))
+		return nil
+	}
+
+	certFile, keyFile := c.paths(host.Domain)
+	if err := os.MkdirAll(c.config.Dir, 0755); err != nil {
+		return logger.Errore(err)
+	}
+	// write both files before replacing either, so that a failed write leaves the old pair in place
+	keyTmp, err := export.StageFile(keyFile, []byte(host.PrivateKeyPEM), export.DefaultKeyMode)
+	if err != nil {
+		return logger.Errore(err)
+	}
+	defer os.Remove(keyTmp)
+	certTmp, err := export.StageFile(certFile, []byte(host.CertificatePEM), export.DefaultFileMode)
+	if err != nil {
+		return logger.Errore(err)
+	}
+	defer os.Remove(certTmp)
+
+	if err := os.Rename(certTmp, certFile); err != nil {
+		return logger.Errorex("unable to write file", err, golog.String("path", certFile))
+	}
+	if err := os.Rename(keyTmp, keyFile); err != nil {
+		return logger.Errorex("unable to write file", err, golog.String("path", keyFile))
+	}
+	if err := c.addToConfig(certFile, keyFile); err != nil {
+		return logger.Errore(err)
+	}
+
+	logger.Info("wrote Traefik certificate", golog.String("domain", host.Domain), golog.String("cert", certFile))
+	return nil
+}
+
+// addToConfig lists the certificate in the dynamic configuration if it isn't already
+func (c *client) addToConfig(certFile string, keyFile string) error {
+	dynamic, err := c.readConfig()
+	if err != nil {
+		return logger.Errore(err)
+	}
+	for _, cert := range dynamic.TLS.Certificates {
+		if cert.CertFile == certFile {
+			// the files are replaced in place, and Traefik also watches them
+			return nil
+		}
+	}
+	dynamic.TLS.Certificates = append(dynamic.TLS.Certificates, &certificateConfig{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	})
+
+	data, err := yaml.Marshal(dynamic)
+	if err != nil {
+		return logger.Errore(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.config.ConfigFile), 0755); err != nil {
+		return logger.Errore(err)
+	}
+	return export.WriteFile(c.config.ConfigFile, data, export.DefaultFileMode)
+}
+
+// readConfig reads the dynamic configuration file
+func (c *client) readConfig() (*dynamicConfig, error) {
+	dynamic := &dynamicConfig{}
+	data, err := ioutil.ReadFile(c.config.ConfigFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, logger.Errore(err)
+	}
+	if err := yaml.Unmarshal(data, dynamic); err != nil {
+		return nil, logger.Errorex("unable to parse Traefik configuration", err, golog.String("file", c.config.ConfigFile))
+	}
+	if dynamic.TLS == nil {
+		dynamic.TLS = &tlsConfig{}
+	}
+	return dynamic, nil
+}
+
+// paths gets the paths of the certificate and key files for a domain; the wildcard label is renamed
+// so that the file names don't need quoting
+func (c *client) paths(domain string) (string, string) {
+	name := strings.Replace(domain, "*", "_wildcard", 1)
+	return filepath.Join(c.config.Dir, name+".crt"), filepath.Join(c.config.Dir, name+".key")
+}
+
+// domainFromFileName reverses the naming used by paths
+func domainFromFileName(name string) string {
+	return strings.Replace(strings.TrimSuffix(name, ".crt"), "_wildcard", "*", 1)
+}
+
+// sameCertificate returns true if the hosts have the same leaf certificate and key
+func sameCertificate(a *sync.Host, b *sync.Host) bool {
+	at, err := a.Thumbprint()
+	if err != nil {
+		return false
+	}
+	bt, err := b.Thumbprint()
+	if err != nil {
+		return false
+	}
+	return at == bt && a.PrivateKeyPEM == b.PrivateKeyPEM
+}
@@ -0,0 +1,145 @@
+package traefik
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stugotech/coyote/sync"
+	"gopkg.in/yaml.v2"
+)
+
+func TestPutHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traefik")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certDir := filepath.Join(dir, "certs")
+	configFile := filepath.Join(dir, "dynamic", "coyote.yml")
+
+	// a certificate which wasn't written by coyote is kept but not returned
+	manual := "tls:\n  certificates:\n  - certFile: /etc/ssl/manual.crt\n    keyFile: /etc/ssl/manual.key\n"
+	if err := os.MkdirAll(filepath.Dir(configFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(configFile, []byte(manual), 0644); err != nil {
+		t.Fatal(err)
+	}
+	external := NewClient(&Config{Dir: certDir, ConfigFile: configFile})
+
+	host := newTestHost(t, "*.example.com")
+	if err := external.PutHost(host); err != nil {
+		t.Fatal(err)
+	}
+	for name, mode := range map[string]os.FileMode{"_wildcard.example.com.crt": 0644, "_wildcard.example.com.key": 0600} {
+		info, err := os.Stat(filepath.Join(certDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Errorf("%s: got mode %v, want %v", name, info.Mode().Perm(), mode)
+		}
+	}
+
+	// a new certificate replaces the files without listing them again
+	host = newTestHost(t, "*.example.com")
+	if err := external.PutHost(host); err != nil {
+		t.Fatal(err)
+	}
+	if err := external.PutHost(newTestHost(t, "example.org")); err != nil {
+		t.Fatal(err)
+	}
+
+	dynamic := readConfig(t, configFile)
+	if len(dynamic.TLS.Certificates) != 3 {
+		t.Fatalf("got %d certificates in the configuration, want 3", len(dynamic.TLS.Certificates))
+	}
+	if dynamic.TLS.Certificates[0].CertFile != "/etc/ssl/manual.crt" {
+		t.Errorf("got first certificate %q, want the manual one kept", dynamic.TLS.Certificates[0].CertFile)
+	}
+	hosts, err := external.GetHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 2 {
+		t.Fatalf("got %d hosts, want the 2 written by coyote", len(hosts))
+	}
+	if hosts[0].Domain != host.Domain || hosts[0].CertificatePEM != host.CertificatePEM || hosts[0].PrivateKeyPEM != host.PrivateKeyPEM {
+		t.Errorf("got host %+v, want %+v", hosts[0], host)
+	}
+
+	files, err := ioutil.ReadDir(certDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 {
+		t.Errorf("got %d files, want the temporary files removed", len(files))
+	}
+}
+
+func TestGetHostMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "traefik")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	external := NewClient(&Config{Dir: dir, ConfigFile: filepath.Join(dir, "coyote.yml")})
+
+	if host, err := external.GetHost("example.com"); err != nil || host != nil {
+		t.Errorf("got host %+v and error %v, want none", host, err)
+	}
+	if hosts, err := external.GetHosts(); err != nil || len(hosts) != 0 {
+		t.Errorf("got hosts %v and error %v, want none", hosts, err)
+	}
+}
+
+// readConfig reads the dynamic configuration file
+func readConfig(t *testing.T, path string) *dynamicConfig {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dynamic := &dynamicConfig{}
+	if err := yaml.Unmarshal(data, dynamic); err != nil {
+		t.Fatal(err)
+	}
+	return dynamic
+}
+
+// newTestHost creates a host with a new self-signed certificate for the domain
+func newTestHost(t *testing.T, domain string) *sync.Host {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &sync.Host{
+		Domain:         domain,
+		CertificatePEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		PrivateKeyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
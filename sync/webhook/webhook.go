@@ -0,0 +1,282 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/stugotech/coyote/sync"
+	"github.com/stugotech/golog"
+)
+
+var logger = golog.NewPackageLogger()
+
+// Request headers
+const (
+	// SignatureHeader is the hex-encoded HMAC-SHA256 of the request body, prefixed with "sha256="
+	SignatureHeader = "X-Coyote-Signature"
+	// IdempotencyKeyHeader is the same for every request that delivers the same certificate for a
+	// domain, so that the receiver can ignore repeats
+	IdempotencyKeyHeader = "Idempotency-Key"
+)
+
+// Defaults
+const (
+	DefaultAttempts    = 3
+	DefaultTimeout     = 30 * time.Second
+	DefaultContentType = "application/json"
+)
+
+// Config describes where certificates are sent
+type Config struct {
+	// URLs are the endpoints which each certificate is POSTed to
+	URLs []string `mapstructure:"urls"`
+	// Secret is the key used to sign request bodies
+	Secret string `mapstructure:"secret"`
+	// Template is an optional text/template for the request body, which is given a Payload.  The
+	// Payload is sent as JSON if not set.
+	Template string `mapstructure:"template"`
+	// ContentType is the content type of the request body
+	ContentType string `mapstructure:"content-type"`
+	// GetURL is an optional endpoint which returns the Payload currently installed for a domain, with
+	// {domain} replaced by the domain, so that unchanged certificates aren't sent again.  It should
+	// return 404 if the domain has no certificate.
+	GetURL string `mapstructure:"get-url"`
+	// Attempts is the number of times to try each request which fails with a network error, a server
+	// error or 429 Too Many Requests
+	Attempts int `mapstructure:"attempts"`
+	// Timeout limits how long each request can take
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// Payload describes a certificate
+type Payload struct {
+	Domain      string    `json:"domain"`
+	Certificate string    `json:"certificate"`
+	PrivateKey  string    `json:"privateKey,omitempty"`
+	Thumbprint  string    `json:"thumbprint,omitempty"`
+	NotBefore   time.Time `json:"notBefore,omitempty"`
+	Expires     time.Time `json:"expires,omitempty"`
+}
+
+// client is an implementation of the Client interface which sends certificates to webhooks
+type client struct {
+	config   *Config
+	template *template.Template
+	http     *http.Client
+}
+
+// NewClient creates a client which POSTs certificates to the configured URLs
+func NewClient(config *Config) (sync.Client, error) {
+	if len(config.URLs) == 0 {
+		return nil, logger.Error("at least one webhook URL is required")
+	}
+	if config.ContentType == "" {
+		config.ContentType = DefaultContentType
+	}
+	if config.Attempts <= 0 {
+		config.Attempts = DefaultAttempts
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	c := &client{
+		config: config,
+		http:   &http.Client{Timeout: config.Timeout},
+	}
+	if config.Template != "" {
+		t, err := template.New("payload").Option("missingkey=error").Parse(config.Template)
+		if err != nil {
+			return nil, logger.Errorex("invalid webhook template", err)
+		}
+		c.template = t
+	}
+	return c, nil
+}
+
+// GetHosts isn't supported, as webhooks can't be listed.  If the GET endpoint is configured,
+// sync.ErrListNotSupported is returned so that plans get each host by name instead.
+func (c *client) GetHosts() ([]*sync.Host, error) {
+	if c.config.GetURL != "" {
+		return nil, sync.ErrListNotSupported
+	}
+	return nil, logger.Error("webhook target can't list hosts without a get-url")
+}
+
+// GetHost gets the certificate installed for a domain from the GET endpoint, or nil if there isn't
+// one or no endpoint is configured
+func (c *client) GetHost(domain string) (*sync.Host, error) {
+	if c.config.GetURL == "" {
+		return nil, nil
+	}
+	getURL := strings.Replace(c.config.GetURL, "{domain}", url.PathEscape(domain), -1)
+
+	req, err := http.NewRequest("GET", getURL, nil)
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	c.sign(req, nil)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		logger.Errorex("unable to get certificate from webhook", err, golog.String("domain", domain))
+		return nil, &sync.TransientError{Err: err}
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &sync.TransientError{Err: logger.Errore(err)}
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp, body)
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, logger.Errorex("unable to decode certificate from webhook", err, golog.String("domain", domain))
+	}
+	return &sync.Host{
+		Domain:         domain,
+		CertificatePEM: payload.Certificate,
+		PrivateKeyPEM:  payload.PrivateKey,
+	}, nil
+}
+
+// PutHost sends the certificate for a host to each URL.  If every URL which failed did so with a
+// transient error, a TransientError is returned so that the certificate is sent again; the
+// idempotency key lets the URLs which succeeded ignore the repeat.
+func (c *client) PutHost(host *sync.Host) error {
+	payload, err := newPayload(host)
+	if err != nil {
+		return logger.Errore(err)
+	}
+	body, err := c.body(payload)
+	if err != nil {
+		return logger.Errore(err)
+	}
+	key := idempotencyKey(host.Domain, payload.Thumbprint)
+
+	var failed []string
+	transient := true
+	for _, u := range c.config.URLs {
+		if err := c.post(u, key, body); err != nil {
+			logger.Errorex("unable to send certificate to webhook", err,
+				golog.String("domain", host.Domain),
+				golog.String("url", u),
+			)
+			failed = append(failed, u)
+			transient = transient && sync.IsTransient(err)
+			continue
+		}
+		logger.Info("sent certificate to webhook", golog.String("domain", host.Domain), golog.String("url", u))
+	}
+	if len(failed) > 0 {
+		err := logger.Error("unable to send certificate to all webhooks",
+			golog.String("domain", host.Domain),
+			golog.Strings("urls", failed),
+		)
+		if transient {
+			return &sync.TransientError{Err: err}
+		}
+		return err
+	}
+	return nil
+}
+
+// Attempts is the number of times to try each request
+func (c *client) Attempts() int {
+	return c.config.Attempts
+}
+
+// post sends the request body to a URL
+func (c *client) post(u string, key string, body []byte) error {
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return logger.Errore(err)
+	}
+	req.Header.Set("Content-Type", c.config.ContentType)
+	req.Header.Set(IdempotencyKeyHeader, key)
+	c.sign(req, body)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return &sync.TransientError{Err: err}
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError(resp, respBody)
+	}
+	return nil
+}
+
+// body creates the request body for the payload
+func (c *client) body(payload *Payload) ([]byte, error) {
+	if c.template == nil {
+		return json.Marshal(payload)
+	}
+	var buf bytes.Buffer
+	if err := c.template.Execute(&buf, payload); err != nil {
+		return nil, logger.Errorex("unable to execute webhook template", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// sign adds the signature of the body to the request, if a secret is configured
+func (c *client) sign(req *http.Request, body []byte) {
+	if c.config.Secret == "" {
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(c.config.Secret))
+	mac.Write(body)
+	req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+// newPayload creates the payload describing a host's certificate
+func newPayload(host *sync.Host) (*Payload, error) {
+	certs, err := host.DecodeCertificates()
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	thumbprint, err := host.Thumbprint()
+	if err != nil {
+		return nil, logger.Errore(err)
+	}
+	return &Payload{
+		Domain:      host.Domain,
+		Certificate: host.CertificatePEM,
+		PrivateKey:  host.PrivateKeyPEM,
+		Thumbprint:  thumbprint,
+		NotBefore:   certs[0].NotBefore,
+		Expires:     certs[0].NotAfter,
+	}, nil
+}
+
+// idempotencyKey derives the idempotency key for delivering a certificate to a domain
+func idempotencyKey(domain string, thumbprint string) string {
+	sum := sha256.Sum256([]byte(domain + "\n" + thumbprint))
+	return hex.EncodeToString(sum[:])
+}
+
+// statusError describes an unsuccessful response; server errors and rate limiting are transient, so
+// are marked to be retried
+func statusError(resp *http.Response, body []byte) error {
+	err := logger.Error("webhook returned unsuccessful status",
+		golog.String("status", resp.Status),
+		golog.String("response", strings.TrimSpace(string(body))),
+	)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return &sync.TransientError{Err: err}
+	}
+	return err
+}
@@ -0,0 +1,215 @@
+package webhook
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"github.com/stugotech/coyote/sync"
+)
+
+// fakeWebhook receives certificates, failing with the status if it is set
+type fakeWebhook struct {
+	mu       gosync.Mutex
+	status   int
+	payloads map[string]*Payload
+	// keys are the idempotency keys of the requests received
+	keys []string
+}
+
+func newFakeWebhook() *fakeWebhook {
+	return &fakeWebhook{payloads: make(map[string]*Payload)}
+}
+
+func (f *fakeWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	if r.Header.Get(SignatureHeader) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		http.Error(w, "bad signature", http.StatusUnauthorized)
+		return
+	}
+	if f.status != 0 {
+		http.Error(w, "failed", f.status)
+		return
+	}
+
+	if r.Method == "GET" {
+		payload, ok := f.payloads[r.URL.Path[1:]]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(payload)
+		return
+	}
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.payloads[payload.Domain] = &payload
+	f.keys = append(f.keys, r.Header.Get(IdempotencyKeyHeader))
+}
+
+func TestPutAndGetHost(t *testing.T) {
+	hooks := []*fakeWebhook{newFakeWebhook(), newFakeWebhook()}
+	var urls []string
+	for _, hook := range hooks {
+		server := httptest.NewServer(hook)
+		defer server.Close()
+		urls = append(urls, server.URL)
+	}
+	external, err := NewClient(&Config{URLs: urls, Secret: "secret", GetURL: urls[0] + "/{domain}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if host, err := external.GetHost("example.com"); err != nil || host != nil {
+		t.Errorf("got host %+v and error %v before it was sent, want none", host, err)
+	}
+	host := newTestHost(t, "example.com")
+	for i := 0; i < 2; i++ {
+		if err := external.PutHost(host); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, hook := range hooks {
+		if len(hook.keys) != 2 || hook.keys[0] != hook.keys[1] {
+			t.Errorf("webhook %d: got idempotency keys %v, want the same key twice", i, hook.keys)
+		}
+		if payload := hook.payloads["example.com"]; payload == nil || payload.PrivateKey != host.PrivateKeyPEM {
+			t.Errorf("webhook %d: got payload %+v, want the host", i, payload)
+		}
+	}
+
+	got, err := external.GetHost("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.CertificatePEM != host.CertificatePEM || got.PrivateKeyPEM != host.PrivateKeyPEM {
+		t.Errorf("got host %+v, want %+v", got, host)
+	}
+	if _, err := external.GetHosts(); err != sync.ErrListNotSupported {
+		t.Errorf("got error %v listing hosts, want ErrListNotSupported", err)
+	}
+}
+
+func TestErrorsAreTransient(t *testing.T) {
+	tests := []struct {
+		status    int
+		transient bool
+	}{
+		{http.StatusBadRequest, false},
+		{http.StatusUnprocessableEntity, false},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusBadGateway, true},
+	}
+	for _, test := range tests {
+		ok := newFakeWebhook()
+		failing := newFakeWebhook()
+		failing.status = test.status
+		okServer := httptest.NewServer(ok)
+		failingServer := httptest.NewServer(failing)
+		external, err := NewClient(&Config{
+			URLs:   []string{okServer.URL, failingServer.URL},
+			Secret: "secret",
+			GetURL: failingServer.URL + "/{domain}",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = external.PutHost(newTestHost(t, "example.com"))
+		if err == nil || sync.IsTransient(err) != test.transient {
+			t.Errorf("%d: got error %v putting host, want transient %v", test.status, err, test.transient)
+		}
+		if len(ok.keys) != 1 {
+			t.Errorf("%d: got %d requests to the working webhook, want 1", test.status, len(ok.keys))
+		}
+		if _, err := external.GetHost("example.com"); err == nil || sync.IsTransient(err) != test.transient {
+			t.Errorf("%d: got error %v getting host, want transient %v", test.status, err, test.transient)
+		}
+		okServer.Close()
+		failingServer.Close()
+	}
+
+	// the webhook isn't running
+	server := httptest.NewServer(newFakeWebhook())
+	server.Close()
+	external, err := NewClient(&Config{URLs: []string{server.URL}, GetURL: server.URL + "/{domain}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := external.PutHost(newTestHost(t, "example.com")); !sync.IsTransient(err) {
+		t.Errorf("got error %v putting host, want a transient error", err)
+	}
+	if _, err := external.GetHost("example.com"); !sync.IsTransient(err) {
+		t.Errorf("got error %v getting host, want a transient error", err)
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     int
+	}{
+		{0, DefaultAttempts},
+		{1, 1},
+		{5, 5},
+	}
+	for _, test := range tests {
+		external, err := NewClient(&Config{URLs: []string{"http://localhost"}, Attempts: test.attempts})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := external.(sync.Retrier).Attempts(); got != test.want {
+			t.Errorf("%d: got %d attempts, want %d", test.attempts, got, test.want)
+		}
+	}
+}
+
+// newTestHost creates a host with a new self-signed certificate for the domain
+func newTestHost(t *testing.T, domain string) *sync.Host {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &sync.Host{
+		Domain:         domain,
+		CertificatePEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		PrivateKeyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}